@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/slack-go/slack"
 )
 
 // Commonly used strings.
@@ -18,6 +19,41 @@ const (
 	APINoEndpoint = "No endpoint found"
 )
 
+// Context key for the actor making an API request.
+type apiActorKey struct{}
+
+// Get the actor making an API request, as recorded in the audit log.
+func APIActor(r *http.Request) string {
+	actor, _ := r.Context().Value(apiActorKey{}).(string)
+	return actor
+}
+
+// Context key for the organization an API request is limited to.
+type apiOrgKey struct{}
+
+// Get the organization an API request is limited to by its key or dashboard session, nil if not limited.
+func APIOrg(r *http.Request) *Org {
+	org, _ := r.Context().Value(apiOrgKey{}).(*Org)
+	return org
+}
+
+// Get the organization an API request acts on, either the one it is limited to,
+// the organization parameter or the first organization.
+func APIRequestOrg(r *http.Request) (*Org, error) {
+	name := r.FormValue("organization")
+	if org := APIOrg(r); org != nil {
+		if name != "" && name != org.Name {
+			return nil, fmt.Errorf("not allowed to use organization %s", name)
+		}
+		return org, nil
+	}
+	org := OrgOrDefault(name)
+	if org == nil {
+		return nil, fmt.Errorf("unknown organization %s", name)
+	}
+	return org, nil
+}
+
 // Main response structure.
 type APIGeneralResp struct {
 	Status string `json:"status"`
@@ -53,17 +89,65 @@ func (s *HTTPServer) APIAuthenticationMiddleware(next http.Handler) http.Handler
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		apiKey := r.Header.Get("X-API-Key")
 
-		if s.config.APIKey != "" && s.config.APIKey != apiKey {
+		// Determine who is making the request for the audit log, and the organization they are limited to.
+		// Users signed in to the dashboard may also use the API.
+		// Without API keys, requests are allowed without either.
+		actor := "api"
+		orgName := ""
+		if key := s.config.FindAPIKey(apiKey); key.Name != "" {
+			actor = ActorAPIKey(key.Name)
+			orgName = key.Organization
+		} else if user, org := s.DashboardUser(r); user != "" {
+			actor = "dashboard:" + user
+			orgName = org
+		} else if s.config.HasAPIKeys() {
+			s.APISendGeneralResp(w, APIERR, APIForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), apiActorKey{}, actor)
+		if orgName != "" {
+			org := OrgByName(orgName)
+			if org == nil {
+				s.APISendGeneralResp(w, APIERR, APIForbidden)
+				return
+			}
+			ctx = context.WithValue(ctx, apiOrgKey{}, org)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Requires an API key or dashboard session, even when no API keys are configured.
+func (s *HTTPServer) APIRequireKeyOrSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if APIActor(r) == "api" {
 			s.APISendGeneralResp(w, APIERR, APIForbidden)
 			return
 		}
+		next(w, r)
+	}
+}
 
+// Browsers cannot set headers on streams, so the stream also accepts the API key in the query.
+func (s *HTTPServer) StreamQueryKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKey := r.URL.Query().Get("api_key"); apiKey != "" && r.Header.Get("X-API-Key") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("X-API-Key", apiKey)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
 // Setup HTTP router with routes for the API calls.
 func (s *HTTPServer) RegisterAPIRoutes(r *mux.Router) {
+	// Stream messages as they are sent, with server-sent events or a websocket.
+	// Filter to service types with a comma separated service_type parameter.
+	// The stream is registered before the other calls, as it is the only one taking the key from the query.
+	// It is never open, as stage displays should not be readable by anyone on the network.
+	r.Handle("/api/stream", s.StreamQueryKeyMiddleware(s.APIAuthenticationMiddleware(s.APIRequireKeyOrSession(s.StreamHandler)))).Methods(http.MethodGet)
+
 	api := r.PathPrefix("/api").Subrouter()
 
 	// Requires authentication.
@@ -74,8 +158,9 @@ func (s *HTTPServer) RegisterAPIRoutes(r *mux.Router) {
 		s.APISendGeneralResp(w, APIOK, "")
 	})
 
-	// Send message to slack channel for the current service.
+	// Send message to the channel for the current service.
 	// Defaults to admin if no service currently occuring.
+	// Set priority to urgent to also text people in the urgent positions, or those listed in positions.
 	api.HandleFunc("/send_message", func(w http.ResponseWriter, r *http.Request) {
 		// Get message, either from URL query or multi part form.
 		var message string
@@ -94,42 +179,37 @@ func (s *HTTPServer) RegisterAPIRoutes(r *mux.Router) {
 			return
 		}
 
-		// Get current time and default conversation.
-		now := time.Now().UTC()
-		conversation := app.config.Slack.DefaultConversation
-
-		// Find plan times that are occuring right now.
-		var planTime PlanTimes
-		app.db.Where("time_type='service' AND starts_at < ? AND ends_at > ?", now, now).First(&planTime)
-		if planTime.Plan != 0 {
-			// If plan found, check for the slack channel.
-			var channel SlackChannels
-			app.db.Where("pc_plan = ?", planTime.Plan).First(&channel)
-			if channel.ID != "" {
-				// If slack channel found, update the conversation to the channel ID.
-				conversation = channel.ID
-			}
-		}
-
-		// If no conversation found, likely will happen if no admin is configured, return error.
-		if conversation == "" {
-			log.Println("No conversation found")
-			s.APISendGeneralResp(w, APIERR, "No conversation found")
+		// Send the message to the current service of the organization.
+		org, err := APIRequestOrg(r)
+		if err != nil {
+			s.APISendGeneralResp(w, APIERR, err.Error())
 			return
 		}
-
-		// Send message to Slack.
-		_, _, err = app.slack.PostMessage(conversation, slack.MsgOptionText(message, false))
+		err = SendMessage(org, APIActor(r), message)
 		if err != nil {
-			log.Println("Error sending message:", err)
-			s.APISendGeneralResp(w, APIERR, "Error sending message")
+			log.Println(err)
+			s.APISendGeneralResp(w, APIERR, err.Error())
 			return
 		}
 
+		// Urgent messages are also sent as text messages to people on the service.
+		if r.FormValue("priority") == "urgent" {
+			var positions []string
+			if p := r.FormValue("positions"); p != "" {
+				positions = strings.Split(p, ",")
+			}
+			go SendUrgentSMS(org, APIActor(r), message, positions)
+		}
+
 		// Return a success.
 		s.APISendGeneralResp(w, APIOK, "")
 	}).Methods(http.MethodPost)
 
+	// Query the audit log of outbound actions.
+	// Filter with actor, action, platform, target, since, until and limit parameters.
+	// The log is never open, as it shows what was sent to whom.
+	api.HandleFunc("/audit_events", s.APIRequireKeyOrSession(s.AuditEventsHandler)).Methods(http.MethodGet)
+
 	// If nothing else, we return a not found response.
 	api.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		s.APISendGeneralResp(w, APIERR, APINoEndpoint)
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Make an API request, returning the status and error of the response.
+func apiTestRequest(t *testing.T, r *mux.Router, req *http.Request) APIGeneralResp {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp APIGeneralResp
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatalf("%s: %s", req.URL, err)
+	}
+	return resp
+}
+
+func TestAPIAuditEventsAuthentication(t *testing.T) {
+	newTestApp(t)
+	app.config.Dashboard = DashboardConfig{Enabled: true, SessionSecret: "secret", SessionLifetime: time.Hour}
+	dashboardSecret = nil
+	s := &HTTPServer{config: &app.config.HTTP}
+	r := mux.NewRouter()
+	s.RegisterAPIRoutes(r)
+
+	// Without API keys, the API is open but the audit log is not.
+	resp := apiTestRequest(t, r, httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+	if resp.Status != APIOK {
+		t.Errorf("ping without API keys responded %+v", resp)
+	}
+	resp = apiTestRequest(t, r, httptest.NewRequest(http.MethodGet, "/api/audit_events", nil))
+	if resp.Status != APIERR || resp.Error != APIForbidden {
+		t.Errorf("audit events without API keys responded %+v", resp)
+	}
+
+	// Dashboard sessions may read it.
+	req := dashboardSessionRequest(t, s, "Ann", "")
+	req.URL.Path = "/api/audit_events"
+	resp = apiTestRequest(t, r, req)
+	if resp.Status != APIOK {
+		t.Errorf("audit events with a dashboard session responded %+v", resp)
+	}
+
+	// With API keys, a key is needed.
+	app.config.HTTP.APIKeys = []APIKeyConfig{{Name: "stage", Key: "k"}}
+	resp = apiTestRequest(t, r, httptest.NewRequest(http.MethodGet, "/api/audit_events", nil))
+	if resp.Status != APIERR || resp.Error != APIForbidden {
+		t.Errorf("audit events without a key responded %+v", resp)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/audit_events", nil)
+	req.Header.Set("X-API-Key", "k")
+	resp = apiTestRequest(t, r, req)
+	if resp.Status != APIOK {
+		t.Errorf("audit events with a key responded %+v", resp)
+	}
+}
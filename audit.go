@@ -0,0 +1,317 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditChannelCreate  = "channel.create"
+	AuditChannelTopic   = "channel.topic"
+	AuditChannelInvite  = "channel.invite"
+	AuditChannelKick    = "channel.kick"
+	AuditChannelArchive = "channel.archive"
+	AuditMessagePost    = "message.post"
+	AuditMessageUpdate  = "message.update"
+	AuditSMSSend        = "sms.send"
+	AuditEmailSend      = "email.send"
+	AuditPCWrite        = "pc.write"
+)
+
+// Actors which perform actions without a person.
+const (
+	ActorCron         = "cron"
+	ActorCLI          = "cli"
+	ActorScheduler    = "scheduler"
+	ActorPCWebhook    = "webhook:planning_center"
+	ActorOSC          = "osc"
+	ActorMIDI         = "midi"
+	ActorProPresenter = "propresenter"
+)
+
+// Platform recorded for actions on Planning Center.
+const AuditPlatformPC = "planning_center"
+
+// Longest request or response summary recorded.
+const AuditSummaryLength = 500
+
+// Filters for querying the audit log.
+type AuditFilter struct {
+	Org      string
+	Actor    string
+	Action   string
+	Platform string
+	Target   string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Actor for a Slack user, such as one using a slash command.
+func ActorSlackUser(userID string) string {
+	return "slack:" + userID
+}
+
+// Actor for a request authenticated with an API key.
+func ActorAPIKey(name string) string {
+	return "api:" + name
+}
+
+// Shorten a summary to the longest recorded.
+func AuditSummary(s string) string {
+	if len(s) <= AuditSummaryLength {
+		return s
+	}
+	// Avoid splitting a multi byte character.
+	n := AuditSummaryLength
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n] + "…"
+}
+
+// Record an outbound action for an organization in the audit log.
+func Audit(org *Org, actor, action, platform, target, request, response string, err error) {
+	event := AuditEvents{
+		Org:       org.Name,
+		CreatedAt: time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		Platform:  platform,
+		Target:    target,
+		Request:   AuditSummary(request),
+		Response:  AuditSummary(response),
+		Result:    "ok",
+	}
+	if err != nil {
+		event.Result = "error"
+		event.Error = AuditSummary(err.Error())
+	}
+	dbErr := app.db.Create(&event).Error
+	if dbErr != nil {
+		log.Println("Error saving audit event:", dbErr)
+	}
+}
+
+// A notifier which records each action in the audit log.
+type AuditedNotifier struct {
+	Notifier
+	org   *Org
+	name  string
+	actor string
+}
+
+// Get a notifier of an organization by name, recording its actions as performed by an actor.
+func NotifierAs(org *Org, name, actor string) Notifier {
+	if name == "" {
+		name = SlackNotifierName
+	}
+	return &AuditedNotifier{
+		Notifier: NotifierByName(org, name),
+		org:      org,
+		name:     name,
+		actor:    actor,
+	}
+}
+
+// Create a conversation, recording the action.
+func (n *AuditedNotifier) CreateConversation(name string) (string, error) {
+	id, err := n.Notifier.CreateConversation(name)
+	Audit(n.org, n.actor, AuditChannelCreate, n.name, id, "name="+name, id, err)
+	return id, err
+}
+
+// Set the topic of a conversation, recording the action.
+func (n *AuditedNotifier) SetTopic(conversationID, topic string) error {
+	err := n.Notifier.SetTopic(conversationID, topic)
+	Audit(n.org, n.actor, AuditChannelTopic, n.name, conversationID, "topic="+topic, "", err)
+	return err
+}
+
+// Invite users to a conversation, recording the action.
+func (n *AuditedNotifier) Invite(conversationID string, userIDs ...string) error {
+	err := n.Notifier.Invite(conversationID, userIDs...)
+	Audit(n.org, n.actor, AuditChannelInvite, n.name, conversationID, "users="+strings.Join(userIDs, ","), "", err)
+	return err
+}
+
+// Remove a user from a conversation, recording the action.
+func (n *AuditedNotifier) Remove(conversationID, userID string) error {
+	err := n.Notifier.Remove(conversationID, userID)
+	Audit(n.org, n.actor, AuditChannelKick, n.name, conversationID, "user="+userID, "", err)
+	return err
+}
+
+// Post a message to a conversation, recording the action.
+func (n *AuditedNotifier) PostMessage(conversationID, message string) error {
+	err := n.Notifier.PostMessage(conversationID, message)
+	Audit(n.org, n.actor, AuditMessagePost, n.name, conversationID, message, "", err)
+	return err
+}
+
+// Send a direct message with blocks to a user, recording the action.
+func (n *AuditedNotifier) PostDirectMessage(userID, text string, blocks ...slack.Block) (string, string, error) {
+	notifier, ok := n.Notifier.(InteractiveNotifier)
+	if !ok {
+		return "", "", ErrNotInteractive
+	}
+	conversationID, ts, err := notifier.PostDirectMessage(userID, text, blocks...)
+	target := conversationID
+	if target == "" {
+		target = userID
+	}
+	Audit(n.org, n.actor, AuditMessagePost, n.name, target, text, "ts="+ts, err)
+	return conversationID, ts, err
+}
+
+// Replace the text of a message, recording the action.
+func (n *AuditedNotifier) UpdateMessage(conversationID, ts, text string) error {
+	notifier, ok := n.Notifier.(InteractiveNotifier)
+	if !ok {
+		return ErrNotInteractive
+	}
+	err := notifier.UpdateMessage(conversationID, ts, text)
+	Audit(n.org, n.actor, AuditMessageUpdate, n.name, conversationID, text, "ts="+ts, err)
+	return err
+}
+
+// Archive a conversation, recording the action.
+func (n *AuditedNotifier) Archive(conversationID string) error {
+	err := n.Notifier.Archive(conversationID)
+	Audit(n.org, n.actor, AuditChannelArchive, n.name, conversationID, "", "", err)
+	return err
+}
+
+// Query the audit log, newest first.
+func QueryAuditEvents(filter AuditFilter) (events []AuditEvents, err error) {
+	query := app.db.Order("created_at DESC, id DESC")
+	if filter.Org != "" {
+		query = query.Where("org = ?", filter.Org)
+	}
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Platform != "" {
+		query = query.Where("platform = ?", filter.Platform)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+	err = query.Limit(filter.Limit).Find(&events).Error
+	return
+}
+
+// Parse a time filter, either a duration ago or an RFC 3339 time.
+func ParseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().UTC().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Run the audit command with its arguments.
+func RunAuditCommand(fs *flag.FlagSet, args []string) {
+	var filter AuditFilter
+	var since, until string
+	filter.Org = app.flags.Org
+	fs.StringVar(&filter.Actor, "actor", "", "Only show events by `ACTOR`, such as cron or slack:U0123456789")
+	fs.StringVar(&filter.Action, "action", "", "Only show `ACTION` events, such as channel.invite")
+	fs.StringVar(&filter.Platform, "platform", "", "Only show events on `PLATFORM`, such as slack or planning_center")
+	fs.StringVar(&filter.Target, "target", "", "Only show events for `TARGET`, such as a channel ID")
+	fs.StringVar(&since, "since", "", "Only show events since `TIME`, either a duration ago such as 24h or an RFC 3339 time")
+	fs.StringVar(&until, "until", "", "Only show events before `TIME`")
+	fs.IntVar(&filter.Limit, "limit", 100, "Show at most `N` events")
+	fs.Parse(args)
+
+	var err error
+	filter.Since, err = ParseAuditTime(since)
+	if err != nil {
+		log.Fatalln("Invalid since:", err)
+	}
+	filter.Until, err = ParseAuditTime(until)
+	if err != nil {
+		log.Fatalln("Invalid until:", err)
+	}
+
+	events, err := QueryAuditEvents(filter)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tORG\tACTOR\tACTION\tPLATFORM\tTARGET\tRESULT\tREQUEST")
+	for _, event := range events {
+		result := event.Result
+		if event.Error != "" {
+			result += ": " + event.Error
+		}
+		request := strings.ReplaceAll(event.Request, "\n", " ")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", event.CreatedAt.Local().Format(time.RFC3339), event.Org, event.Actor, event.Action, event.Platform, event.Target, result, request)
+	}
+	w.Flush()
+}
+
+// Query the audit log with filters from a request.
+func (s *HTTPServer) AuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Org:      query.Get("organization"),
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Platform: query.Get("platform"),
+		Target:   query.Get("target"),
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+	// Keys for one organization only see its events.
+	if org := APIOrg(r); org != nil {
+		filter.Org = org.Name
+	}
+
+	var err error
+	filter.Since, err = ParseAuditTime(query.Get("since"))
+	if err != nil {
+		s.APISendGeneralResp(w, APIERR, "Invalid since")
+		return
+	}
+	filter.Until, err = ParseAuditTime(query.Get("until"))
+	if err != nil {
+		s.APISendGeneralResp(w, APIERR, "Invalid until")
+		return
+	}
+
+	events, err := QueryAuditEvents(filter)
+	if err != nil {
+		log.Println(err)
+		s.APISendGeneralResp(w, APIERR, err.Error())
+		return
+	}
+	s.JSONResponse(w, struct {
+		Status string        `json:"status"`
+		Events []AuditEvents `json:"events"`
+	}{APIOK, events})
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// What a command needs setup before it runs.
+const (
+	CLISetupNone     = iota // Nothing, the command loads the configuration itself.
+	CLISetupConfig          // Only the configuration.
+	CLISetupDB              // Connect to the database without applying migrations.
+	CLISetupMigrated        // Connect to the database and apply pending migrations.
+	CLISetupAll             // Also setup notifiers and text messages.
+)
+
+// Format of dates given to commands.
+const CLIDateFormat = "2006-01-02"
+
+// A command run from the command line, such as channels create.
+type CLICommand struct {
+	Name        string
+	Args        string // Synopsis of the flags and arguments.
+	Description string
+	Setup       int
+	Run         func(fs *flag.FlagSet, args []string)
+}
+
+// All commands, in the order shown in help.
+var CLICommands = []CLICommand{
+	{
+		Name:        "serve",
+		Description: "Run the HTTP server and background jobs, the default without a command",
+		Setup:       CLISetupAll,
+		Run:         CLIServe,
+	},
+	{
+		Name:        "update",
+		Args:        "[-dry-run] [-json]",
+		Description: "Sync data, create and archive channels and send scheduling requests",
+		Setup:       CLISetupAll,
+		Run:         CLIUpdate,
+	},
+	{
+		Name:        "sync",
+		Args:        "[pc|slack]",
+		Description: "Sync Planning Center or Slack data, or both if not given",
+		Setup:       CLISetupAll,
+		Run:         CLISync,
+	},
+	{
+		Name:        "channels create",
+		Args:        "[-plan ID]",
+		Description: "Create or update channels for upcoming services, or a single plan",
+		Setup:       CLISetupAll,
+		Run:         CLIChannelsCreate,
+	},
+	{
+		Name:        "channels archive",
+		Args:        "[-before DATE]",
+		Description: "Archive channels for services which started before the channel time frame or a date",
+		Setup:       CLISetupAll,
+		Run:         CLIChannelsArchive,
+	},
+	{
+		Name:        "channels list",
+		Args:        "[-all]",
+		Description: "List channels which are not archived, or all channels",
+		Setup:       CLISetupMigrated,
+		Run:         CLIChannelsList,
+	},
+	{
+		Name:        "match list",
+		Args:        "[-unmatched]",
+		Description: "List Slack users and the Planning Center people they are matched to",
+		Setup:       CLISetupMigrated,
+		Run:         CLIMatchList,
+	},
+	{
+		Name:        "match link",
+		Args:        "SLACK_USER PC_PERSON",
+		Description: "Match a Slack user to a Planning Center person",
+		Setup:       CLISetupMigrated,
+		Run:         CLIMatchLink,
+	},
+	{
+		Name:        "match unlink",
+		Args:        "[-auto] SLACK_USER",
+		Description: "Unmatch a Slack user from their Planning Center person",
+		Setup:       CLISetupMigrated,
+		Run:         CLIMatchUnlink,
+	},
+	{
+		Name:        "send",
+		Args:        "[-plan ID | -channel ID [-notifier NAME]] MESSAGE",
+		Description: "Send a message to a plan, a channel or the current service",
+		Setup:       CLISetupAll,
+		Run:         CLISend,
+	},
+	{
+		Name:        "plans list",
+		Args:        "[-from DATE] [-to DATE]",
+		Description: "List plans with services between two dates",
+		Setup:       CLISetupMigrated,
+		Run:         CLIPlansList,
+	},
+	{
+		Name:        "status",
+		Description: "Show the database, last update and counts of synced data",
+		Setup:       CLISetupMigrated,
+		Run:         CLIStatus,
+	},
+	{
+		Name:        "config check",
+		Args:        "[-offline]",
+		Description: "Check the configuration and connectivity to the database, Planning Center and Slack",
+		Setup:       CLISetupNone,
+		Run:         CLIConfigCheck,
+	},
+	{
+		Name:        "migrate",
+		Args:        "status|up|down [steps]",
+		Description: "Show, apply or revert database migrations",
+		Setup:       CLISetupDB,
+		Run: func(fs *flag.FlagSet, args []string) {
+			fs.Parse(args)
+			RunMigrateCommand(fs.Args())
+		},
+	},
+	{
+		Name:        "audit",
+		Args:        "[-actor ACTOR] [-action ACTION] [-platform PLATFORM] [-target TARGET] [-since TIME] [-until TIME] [-limit N]",
+		Description: "Show the audit log of outbound actions",
+		Setup:       CLISetupMigrated,
+		Run:         RunAuditCommand,
+	},
+}
+
+// Print the commands for the usage message.
+func CLIPrintCommands() {
+	fmt.Printf("\nCommands:\n")
+	for _, cmd := range CLICommands {
+		fmt.Printf("  %s\n    \t%s\n", strings.TrimSpace(cmd.Name+" "+cmd.Args), cmd.Description)
+	}
+}
+
+// Find the command for the arguments, returning the arguments after the command.
+func FindCLICommand(args []string) (*CLICommand, []string) {
+	// Commands may be one or two words, such as status or channels list.
+	if len(args) >= 2 {
+		for i, cmd := range CLICommands {
+			if cmd.Name == args[0]+" "+args[1] {
+				return &CLICommands[i], args[2:]
+			}
+		}
+	}
+	if len(args) >= 1 {
+		for i, cmd := range CLICommands {
+			if cmd.Name == args[0] {
+				return &CLICommands[i], args[1:]
+			}
+		}
+	}
+	return nil, args
+}
+
+// Run the command given on the command line, serving if none is given.
+func RunCLICommand(args []string) {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	cmd, cmdArgs := FindCLICommand(args)
+	if cmd == nil {
+		fmt.Fprintln(os.Stderr, "Unknown command:", strings.Join(args, " "))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Each command has its own flags and help.
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n\n%s.\n", serviceName, strings.TrimSpace(cmd.Name+" "+cmd.Args), cmd.Description)
+		fs.PrintDefaults()
+	}
+
+	// Setup what the command needs, logging problems with the configuration and failing on errors.
+	if cmd.Setup >= CLISetupConfig {
+		problems, err := app.ReadConfig()
+		for _, problem := range problems {
+			log.Println("Configuration", problem)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if cmd.Setup >= CLISetupMigrated {
+		app.InitDB()
+	} else if cmd.Setup == CLISetupDB {
+		app.OpenDB()
+	}
+	if cmd.Setup >= CLISetupAll {
+		app.InitNotifiers()
+		app.InitSMS()
+	}
+	cmd.Run(fs, cmdArgs)
+}
+
+// Parse a date given to a command.
+func CLIParseDate(name, value string) time.Time {
+	t, err := time.ParseInLocation(CLIDateFormat, value, time.Local)
+	if err != nil {
+		log.Fatalf("Invalid %s date %q, expected YYYY-MM-DD\n", name, value)
+	}
+	return t
+}
+
+// Run the HTTP server and background jobs.
+func CLIServe(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	RunServe()
+}
+
+// Run the update, or preview it.
+func CLIUpdate(fs *flag.FlagSet, args []string) {
+	dryRun := fs.Bool("dry-run", false, "Print the channel changes the update would make, without making them")
+	asJSON := fs.Bool("json", false, "Print the dry run as JSON")
+	fs.Parse(args)
+	if *dryRun || *asJSON {
+		RunDryRun(*asJSON)
+		return
+	}
+	RunUpdate()
+}
+
+// Sync Planning Center or Slack data.
+func CLISync(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	source := fs.Arg(0)
+	if source != "" && source != "pc" && source != "slack" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Record the run so it is shown on the dashboard.
+	run := SyncRuns{StartedAt: time.Now().UTC()}
+	app.db.Create(&run)
+	for _, org := range SelectedOrgs() {
+		if source == "" || source == "pc" {
+			UpdatePCData(org)
+		}
+		if source == "" || source == "slack" {
+			UpdateSlackData(org)
+		}
+	}
+	run.FinishedAt = time.Now().UTC()
+	app.db.Save(&run)
+	WaitForWebhooks()
+}
+
+// Create or update channels.
+func CLIChannelsCreate(fs *flag.FlagSet, args []string) {
+	planID := fs.Uint64("plan", 0, "Only create or update the channel for plan `ID`")
+	fs.Parse(args)
+	if *planID != 0 {
+		CLIPlan(fs, *planID)
+		err := ReconcilePlan(ActorCLI, *planID)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		for _, org := range SelectedOrgs() {
+			ReconcileUpcomingChannels(org, ActorCLI)
+		}
+	}
+	WaitForWebhooks()
+}
+
+// Archive channels for services which have passed.
+func CLIChannelsArchive(fs *flag.FlagSet, args []string) {
+	before := fs.String("before", "", "Archive channels for services which started before `DATE`")
+	fs.Parse(args)
+	for _, org := range SelectedOrgs() {
+		startDate, _ := SlackChannelWindow(org)
+		if *before != "" {
+			startDate = CLIParseDate("before", *before)
+		}
+		ArchiveSlackChannels(org, ActorCLI, startDate)
+	}
+}
+
+// List channels.
+func CLIChannelsList(fs *flag.FlagSet, args []string) {
+	all := fs.Bool("all", false, "Include archived channels")
+	fs.Parse(args)
+	query := app.db.Where("org IN ?", OrgNames(SelectedOrgs())).Order("starts_at ASC")
+	if !*all {
+		query = query.Where("archived != 1")
+	}
+	var channels []SlackChannels
+	query.Find(&channels)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tID\tNAME\tNOTIFIER\tPLAN\tSTARTS\tMEMBERS\tARCHIVED\tTOPIC")
+	for _, channel := range channels {
+		notifier := channel.Notifier
+		if notifier == "" {
+			notifier = SlackNotifierName
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%d\t%t\t%s\n", channel.Org, channel.ID, channel.Name, notifier, channel.PCPlan, channel.StartsAt.Local().Format("2006-01-02 15:04"), len(ChannelActiveUsers(channel.ID)), channel.Archived, channel.Description)
+	}
+	w.Flush()
+}
+
+// List Slack users and their matches.
+func CLIMatchList(fs *flag.FlagSet, args []string) {
+	unmatched := fs.Bool("unmatched", false, "Only list users who are not matched")
+	fs.Parse(args)
+	query := app.db.Where("org IN ? AND deleted != 1 AND is_bot != 1 AND id != 'USLACKBOT'", OrgNames(SelectedOrgs())).Order("real_name ASC")
+	if *unmatched {
+		query = query.Where("pc_id = 0")
+	}
+	var users []SlackUsers
+	query.Find(&users)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tSLACK USER\tNAME\tPC PERSON\tPC NAME\tMANUAL")
+	for _, user := range users {
+		var person People
+		if user.PCID != 0 {
+			app.db.Where("org = ? AND id = ?", user.Org, user.PCID).First(&person)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%t\n", user.Org, user.ID, user.RealName, user.PCID, strings.TrimSpace(person.FirstName+" "+person.LastName), user.ManualMatch)
+	}
+	w.Flush()
+}
+
+// Find a plan given to a command in the organizations selected with the org flag, exiting if there is none.
+func CLIPlan(fs *flag.FlagSet, planID uint64) Plans {
+	var plan Plans
+	app.db.Where("org IN ? AND id = ?", OrgNames(SelectedOrgs()), planID).First(&plan)
+	if plan.ID == 0 {
+		fmt.Fprintln(os.Stderr, "Unknown plan:", planID)
+		fs.Usage()
+		os.Exit(2)
+	}
+	return plan
+}
+
+// Find the Slack user given to a match command in the organizations selected with the org flag.
+func CLIMatchUser(fs *flag.FlagSet, userID string) SlackUsers {
+	var user SlackUsers
+	if userID != "" {
+		app.db.Where("org IN ? AND id = ?", OrgNames(SelectedOrgs()), userID).First(&user)
+	}
+	if user.ID == "" {
+		fmt.Fprintln(os.Stderr, "Unknown Slack user:", userID)
+		fs.Usage()
+		os.Exit(2)
+	}
+	return user
+}
+
+// Match a Slack user to a Planning Center person.
+func CLIMatchLink(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	user := CLIMatchUser(fs, fs.Arg(0))
+	personID, _ := strconv.ParseUint(fs.Arg(1), 10, 64)
+	var person People
+	app.db.Where("org = ? AND id = ?", user.Org, personID).First(&person)
+	if person.ID == 0 {
+		fmt.Fprintln(os.Stderr, "Unknown Planning Center person in organization", user.Org+":", fs.Arg(1))
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Keep the match when Slack data is synced.
+	user.PCID = person.ID
+	user.ManualMatch = true
+	app.db.Save(&user)
+	ResetChannelMemberFailures(user.ID)
+	fmt.Printf("Matched %s to %s %s\n", user.RealName, person.FirstName, person.LastName)
+}
+
+// Unmatch a Slack user.
+func CLIMatchUnlink(fs *flag.FlagSet, args []string) {
+	auto := fs.Bool("auto", false, "Match the user automatically on the next Slack sync instead of leaving them unmatched")
+	fs.Parse(args)
+	user := CLIMatchUser(fs, fs.Arg(0))
+	user.PCID = 0
+	user.ManualMatch = !*auto
+	app.db.Save(&user)
+	fmt.Printf("Unmatched %s\n", user.RealName)
+}
+
+// Send a message.
+func CLISend(fs *flag.FlagSet, args []string) {
+	planID := fs.Uint64("plan", 0, "Send to the channel for plan `ID`")
+	channelID := fs.String("channel", "", "Send to conversation `ID`, in the workspace of the organization given with -org or the first")
+	notifierName := fs.String("notifier", SlackNotifierName, "Send to the conversation with notifier `NAME`")
+	fs.Parse(args)
+	message := strings.Join(fs.Args(), " ")
+	if message == "" || (*planID != 0 && *channelID != "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if *planID != 0 {
+		CLIPlan(fs, *planID)
+		err = PostPlanMessage(ActorCLI, *planID, message)
+	} else if *channelID != "" {
+		err = NotifierAs(SelectedOrg(), *notifierName, ActorCLI).PostMessage(*channelID, message)
+	} else {
+		err = SendMessage(SelectedOrg(), ActorCLI, message)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	WaitForWebhooks()
+}
+
+// List plans.
+func CLIPlansList(fs *flag.FlagSet, args []string) {
+	now := time.Now()
+	from := fs.String("from", now.Format(CLIDateFormat), "List plans with services from `DATE`")
+	to := fs.String("to", now.Add(time.Hour*24*14).Format(CLIDateFormat), "List plans with services before `DATE`")
+	fs.Parse(args)
+	fromDate := CLIParseDate("from", *from)
+	toDate := CLIParseDate("to", *to)
+
+	var plans []Plans
+	app.db.Where("org IN ? AND last_time_at >= ? AND first_time_at < ?", OrgNames(SelectedOrgs()), fromDate.UTC(), toDate.UTC()).Order("first_time_at ASC").Find(&plans)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tPLAN\tFIRST TIME\tTOPIC\tPEOPLE\tCHANNEL")
+	for _, plan := range plans {
+		var serviceType ServiceTypes
+		app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+		var people int64
+		app.db.Model(&PlanPeople{}).Where("plan = ? AND status != 'D'", plan.ID).Count(&people)
+		channel := PlanChannel(plan.ID)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\n", plan.Org, plan.ID, plan.FirstTimeAt.Local().Format("2006-01-02 15:04"), PlanTopic(serviceType, plan), people, channel.Name)
+	}
+	w.Flush()
+}
+
+// Show the status of the service.
+func CLIStatus(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Version:\t%s\n", serviceVersion)
+	fmt.Fprintf(w, "Database:\t%s\n", app.config.DB.Type)
+
+	// Migrations.
+	applied, err := AppliedMigrations()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Fprintf(w, "Migrations:\t%d of %d applied\n", len(applied), len(Migrations))
+
+	// Last update.
+	var run SyncRuns
+	app.db.Order("started_at DESC").First(&run)
+	switch {
+	case run.ID == 0:
+		fmt.Fprintf(w, "Last update:\tnever\n")
+	case run.FinishedAt.Unix() <= 0:
+		fmt.Fprintf(w, "Last update:\t%s (did not finish)\n", run.StartedAt.Local().Format(time.RFC1123))
+	default:
+		fmt.Fprintf(w, "Last update:\t%s (%s)\n", run.StartedAt.Local().Format(time.RFC1123), run.FinishedAt.Sub(run.StartedAt).Round(time.Second))
+	}
+
+	// Counts of synced data for each organization.
+	for _, org := range SelectedOrgs() {
+		count := func(model interface{}, query string, args ...interface{}) (n int64) {
+			q := app.db.Model(model).Where("org = ?", org.Name)
+			if query != "" {
+				q = q.Where(query, args...)
+			}
+			q.Count(&n)
+			return
+		}
+		startDate, lastDate := SlackChannelWindow(org)
+		if len(app.config.Organizations) != 0 {
+			fmt.Fprintf(w, "Organization:\t%s\n", org.Name)
+		}
+		fmt.Fprintf(w, "Service types:\t%d\n", count(&ServiceTypes{}, ""))
+		fmt.Fprintf(w, "Upcoming plans:\t%d\n", count(&Plans{}, "last_time_at >= ? AND first_time_at < ?", startDate, lastDate))
+		fmt.Fprintf(w, "Open channels:\t%d\n", count(&SlackChannels{}, "archived != 1"))
+		fmt.Fprintf(w, "People:\t%d\n", count(&People{}, ""))
+		fmt.Fprintf(w, "Slack users:\t%d (%d unmatched)\n", count(&SlackUsers{}, "deleted != 1 AND is_bot != 1"), count(&SlackUsers{}, "deleted != 1 AND is_bot != 1 AND pc_id = 0"))
+	}
+
+	// Webhooks are not specific to an organization.
+	var undelivered int64
+	app.db.Model(&WebhookDeliveries{}).Where("delivered_at IS NULL OR delivered_at < ?", time.Unix(0, 0)).Count(&undelivered)
+	fmt.Fprintf(w, "Undelivered webhooks:\t%d\n", undelivered)
+	w.Flush()
+}
+
+// Check the configuration, which is validated when loaded, and connectivity.
+func CLIConfigCheck(fs *flag.FlagSet, args []string) {
+	offline := fs.Bool("offline", false, "Do not check connectivity")
+	fs.Parse(args)
+
+	// Print problems with the configuration, failing if it could not be loaded.
+	problems, err := app.ReadConfig()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if err != nil {
+		fmt.Println("Configuration is not valid:", err)
+		os.Exit(1)
+	}
+	if app.configFile != "" {
+		fmt.Println("Configuration is valid:", app.configFile)
+	} else {
+		fmt.Println("Configuration is valid, loaded from the environment")
+	}
+	if *offline {
+		return
+	}
+
+	// Check each connection, failing if any can not connect.
+	failed := false
+	type check struct {
+		name  string
+		check func() error
+	}
+	checks := []check{{"database", CheckDatabase}}
+	for _, org := range SelectedOrgs() {
+		org := org
+		prefix := ""
+		if len(app.config.Organizations) != 0 {
+			prefix = org.Name + " "
+		}
+		checks = append(checks,
+			check{prefix + "planning_center", func() error { return CheckPlanningCenter(org) }},
+			check{prefix + "slack", func() error { return CheckSlack(org) }},
+		)
+	}
+	for _, c := range checks {
+		err := c.check()
+		if err != nil {
+			fmt.Printf("%s: unable to connect: %s\n", c.name, err)
+			failed = true
+		} else {
+			fmt.Printf("%s: ok\n", c.name)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
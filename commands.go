@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Usage for the slash command.
+const SlackCommandUsage = "Usage:\n" +
+	"• `next` - Your upcoming services and positions.\n" +
+	"• `team <position>` - Who is assigned to a position.\n" +
+	"• `whoami` - Your matched Planning Center identity.\n" +
+	"• `channel` - Link to this week's channel.\n" +
+	"• `sms on|off` - Receive urgent text messages during services.\n" +
+	"• `calendar [reset]` - Link to a calendar feed of your services."
+
+// Planning center status codes for people on a plan.
+var PlanPeopleStatusNames = map[string]string{
+	"C": "confirmed",
+	"U": "unconfirmed",
+	"D": "declined",
+}
+
+// Escape character of LIKE patterns, which is not a backslash as MySQL treats those specially in strings.
+const LikeEscape = "!"
+
+// Escape the wildcards of a LIKE pattern, which is matched with LikeEscape as the escape character.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(LikeEscape, LikeEscape+LikeEscape, "%", LikeEscape+"%", "_", LikeEscape+"_").Replace(s)
+}
+
+// Format a time so Slack displays it in the user's time zone.
+func SlackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} at {time}|%s>", t.Unix(), t.Format(time.RFC1123))
+}
+
+// Get the name of a planning center person of an organization, mentioning their slack user if matched.
+func PersonDisplayName(orgName string, personID uint64) string {
+	var slackUser SlackUsers
+	app.db.Where("org = ? AND pc_id = ?", orgName, personID).First(&slackUser)
+	if slackUser.ID != "" {
+		return "<@" + slackUser.ID + ">"
+	}
+	var person People
+	app.db.Where("org = ? AND id = ?", orgName, personID).First(&person)
+	if person.ID == 0 {
+		return "Unknown person"
+	}
+	return person.FirstName + " " + person.LastName
+}
+
+// Find the plan for a command, either the plan of the channel it was sent in or the next service of the organization.
+func SlackCommandPlan(org *Org, channelID string) (plan Plans) {
+	// If sent in a service channel, use that plan.
+	var channel SlackChannels
+	app.db.Where("org = ? AND id = ?", org.Name, channelID).First(&channel)
+	if channel.PCPlan != 0 {
+		app.db.Where("id = ?", channel.PCPlan).First(&plan)
+		return
+	}
+
+	// Otherwise use the next service that has not ended.
+	var planTime PlanTimes
+	app.db.Where("org = ? AND time_type='service' AND ends_at > ?", org.Name, time.Now().UTC()).Order("starts_at ASC").First(&planTime)
+	if planTime.Plan != 0 {
+		app.db.Where("id = ?", planTime.Plan).First(&plan)
+	}
+	return
+}
+
+// List the upcoming plans of the organization a user is assigned to, with times and positions.
+func SlackCommandNext(org *Org, user SlackUsers) string {
+	if user.PCID == 0 {
+		return "You are not matched to a Planning Center person."
+	}
+
+	// Find plans the user is assigned to.
+	var assignments []PlanPeople
+	app.db.Where("org = ? AND person = ?", org.Name, user.PCID).Find(&assignments)
+	positions := make(map[uint64][]string)
+	var planIDs []uint64
+	for _, assignment := range assignments {
+		if _, ok := positions[assignment.Plan]; !ok {
+			planIDs = append(planIDs, assignment.Plan)
+		}
+		position := assignment.TeamPositionName
+		if status, ok := PlanPeopleStatusNames[assignment.Status]; ok && assignment.Status != "C" {
+			position += " (" + status + ")"
+		}
+		positions[assignment.Plan] = append(positions[assignment.Plan], position)
+	}
+	if len(planIDs) == 0 {
+		return "You are not assigned to any services."
+	}
+
+	// Find upcoming times for those plans.
+	var planTimes []PlanTimes
+	app.db.Where("org = ? AND plan IN ? AND ends_at > ?", org.Name, planIDs, time.Now().UTC()).Order("starts_at ASC").Find(&planTimes)
+	if len(planTimes) == 0 {
+		return "You are not assigned to any upcoming services."
+	}
+
+	// Group times by plan, keeping the order of the first time.
+	var order []uint64
+	times := make(map[uint64][]PlanTimes)
+	for _, planTime := range planTimes {
+		if _, ok := times[planTime.Plan]; !ok {
+			order = append(order, planTime.Plan)
+		}
+		times[planTime.Plan] = append(times[planTime.Plan], planTime)
+	}
+
+	// Build the response, limited to the next few plans.
+	var b strings.Builder
+	for i, planID := range order {
+		if i >= 3 {
+			break
+		}
+		var plan Plans
+		app.db.Where("id = ?", planID).First(&plan)
+		var serviceType ServiceTypes
+		app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+
+		fmt.Fprintf(&b, "*%s*\n", PlanTopic(serviceType, plan))
+		for _, planTime := range times[planID] {
+			name := planTime.Name
+			if name == "" && planTime.TimeType != "" {
+				name = strings.ToUpper(planTime.TimeType[:1]) + planTime.TimeType[1:]
+			}
+			fmt.Fprintf(&b, "• %s: %s\n", name, SlackDate(planTime.StartsAt))
+		}
+		fmt.Fprintf(&b, "Positions: %s\n\n", strings.Join(positions[planID], ", "))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// List who is assigned to a position on a plan.
+func SlackCommandTeam(org *Org, channelID, position string) string {
+	if position == "" {
+		return "Please provide a position, such as `team sound`."
+	}
+
+	// Find the plan to look at.
+	plan := SlackCommandPlan(org, channelID)
+	if plan.ID == 0 {
+		return "No upcoming services found."
+	}
+	var serviceType ServiceTypes
+	app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+
+	// Find people assigned to positions matching the search.
+	var assignments []PlanPeople
+	pattern := "%" + EscapeLike(strings.ToLower(position)) + "%"
+	app.db.Where("org = ? AND plan = ? AND LOWER(team_position_name) LIKE ? ESCAPE '"+LikeEscape+"'", org.Name, plan.ID, pattern).Find(&assignments)
+	if len(assignments) == 0 {
+		return fmt.Sprintf("Nobody is assigned to %s for %s.", position, PlanTopic(serviceType, plan))
+	}
+
+	// Build the response.
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*\n", PlanTopic(serviceType, plan))
+	for _, assignment := range assignments {
+		fmt.Fprintf(&b, "• %s: %s", assignment.TeamPositionName, PersonDisplayName(org.Name, assignment.Person))
+		if status, ok := PlanPeopleStatusNames[assignment.Status]; ok && assignment.Status != "C" {
+			fmt.Fprintf(&b, " (%s)", status)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Describe the planning center person matched to a user.
+func SlackCommandWhoami(user SlackUsers) string {
+	if user.PCID == 0 {
+		return "You are not matched to a Planning Center person. Please ask an administrator to check your name."
+	}
+	var person People
+	app.db.Where("id = ?", user.PCID).First(&person)
+	return fmt.Sprintf("You are matched to %s %s (Planning Center ID %d).", person.FirstName, person.LastName, person.ID)
+}
+
+// Link to this week's channel of the organization, preferring one the user was assigned to.
+func SlackCommandChannel(org *Org, user SlackUsers) string {
+	startDate, _ := SlackChannelWindow(org)
+
+	// Find plans the user is assigned to.
+	var planIDs []uint64
+	app.db.Model(&PlanPeople{}).Where("person = ?", user.PCID).Distinct().Pluck("plan", &planIDs)
+
+	// Find the next channel for the user's plans, otherwise the next channel.
+	var channel SlackChannels
+	if len(planIDs) != 0 {
+		app.db.Where("org = ? AND pc_plan IN ? AND starts_at > ? AND archived != 1", org.Name, planIDs, startDate).Order("starts_at ASC").First(&channel)
+	}
+	if channel.ID == "" {
+		app.db.Where("org = ? AND starts_at > ? AND archived != 1", org.Name, startDate).Order("starts_at ASC").First(&channel)
+	}
+	if channel.ID == "" {
+		return "No channel has been created for this week."
+	}
+	return fmt.Sprintf("This week's channel is <#%s>.", channel.ID)
+}
+
+// Opt in or out of urgent text messages.
+func SlackCommandSMS(user SlackUsers, arg string) string {
+	if app.sms == nil {
+		return "Text messages are not enabled."
+	}
+	if user.PCID == 0 {
+		return "You are not matched to a Planning Center person."
+	}
+	switch strings.ToLower(arg) {
+	case "on":
+		if user.Phone == "" {
+			return "Please add a phone number to your Slack profile first."
+		}
+		SMSSetOptIn(user.PCID, user.Phone, true)
+		return fmt.Sprintf("You will receive urgent text messages at %s during your services. Reply STOP to any text to opt out.", user.Phone)
+	case "off":
+		SMSSetOptIn(user.PCID, "", false)
+		return "You will no longer receive text messages."
+	}
+	return "Usage: `sms on` or `sms off`."
+}
+
+// Link to the calendar feed for a user, resetting the link if requested.
+func SlackCommandCalendar(user SlackUsers, arg string) string {
+	if !app.config.ICal.Enabled {
+		return "Calendar feeds are not enabled."
+	}
+	if user.PCID == 0 {
+		return "You are not matched to a Planning Center person."
+	}
+	reset := strings.ToLower(arg) == "reset"
+	text := fmt.Sprintf("Subscribe to %s in Google or Apple Calendar to see your services. Keep this link private.", CalendarURL(user.PCID, reset))
+	if reset {
+		text = "Your previous calendar link no longer works. " + text
+	}
+	return text
+}
+
+// Handle the slash command for volunteers.
+func (s *HTTPServer) SlackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		log.Println("Error parsing slash command:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Find the organization and user who sent the command.
+	org := SlackOrg(r)
+	var user SlackUsers
+	app.db.Where("org = ? AND id = ?", org.Name, cmd.UserID).First(&user)
+
+	// Split the sub command from its arguments.
+	args := strings.Fields(cmd.Text)
+	subCommand := ""
+	if len(args) != 0 {
+		subCommand = strings.ToLower(args[0])
+	}
+
+	// Run the sub command.
+	var text string
+	switch subCommand {
+	case "next":
+		text = SlackCommandNext(org, user)
+	case "team":
+		text = SlackCommandTeam(org, cmd.ChannelID, strings.Join(args[1:], " "))
+	case "whoami":
+		text = SlackCommandWhoami(user)
+	case "channel":
+		text = SlackCommandChannel(org, user)
+	case "sms":
+		text = SlackCommandSMS(user, strings.Join(args[1:], " "))
+	case "calendar":
+		text = SlackCommandCalendar(user, strings.Join(args[1:], " "))
+	default:
+		text = SlackCommandUsage
+	}
+
+	// Respond only to the user who sent the command.
+	s.JSONResponse(w, slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         text,
+	})
+}
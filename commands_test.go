@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeLike(t *testing.T) {
+	for s, want := range map[string]string{
+		"sound":    "sound",
+		"sound_1":  "sound!_1",
+		"100%":     "100!%",
+		"wow!":     "wow!!",
+		"%_!plain": "!%!_!!plain",
+	} {
+		if got := EscapeLike(s); got != want {
+			t.Errorf("escaped %q as %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestSlackCommandTeam(t *testing.T) {
+	newTestApp(t)
+	createTestPlan(t, 10, 5, 6, 7)
+	for id, position := range map[uint64]string{1000: "Sound_1", 1001: "SoundX1", 1002: "100% Vocals"} {
+		app.db.Model(&PlanPeople{}).Where("id = ?", id).Update("team_position_name", position)
+	}
+	app.db.Create(&People{ID: 5, Org: DefaultOrgName, FirstName: "Ann", LastName: "Lee"})
+	app.db.Create(&SlackUsers{ID: "U6", Org: DefaultOrgName, PCID: 6})
+	org := app.orgs[0]
+
+	// Wildcards in the search are matched literally.
+	for _, c := range []struct {
+		search string
+		want   []string
+		not    []string
+	}{
+		{"sound", []string{"Sound_1", "SoundX1"}, []string{"Vocals"}},
+		{"sound_1", []string{"Sound_1: Ann Lee"}, []string{"SoundX1"}},
+		{"%", []string{"100% Vocals: Unknown person"}, []string{"Sound"}},
+		{"x1", []string{"SoundX1: <@U6>"}, []string{"Sound_1"}},
+	} {
+		text := SlackCommandTeam(org, "", c.search)
+		for _, want := range c.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("team %s responded %q, want %q", c.search, text, want)
+			}
+		}
+		for _, not := range c.not {
+			if strings.Contains(text, not) {
+				t.Errorf("team %s responded %q, which includes %q", c.search, text, not)
+			}
+		}
+	}
+	if text := SlackCommandTeam(org, "", "x_"); !strings.HasPrefix(text, "Nobody is assigned") {
+		t.Errorf("team x_ responded %q, want nobody", text)
+	}
+
+	// People and users of other organizations with the same IDs are not shown.
+	app.db.Where("id = ?", 5).Delete(&People{})
+	app.db.Create(&People{ID: 5, Org: "north", FirstName: "Bob", LastName: "Ray"})
+	app.db.Create(&SlackUsers{ID: "U7", Org: "north", PCID: 7})
+	if text := SlackCommandTeam(org, "", "sound_1"); !strings.Contains(text, "Unknown person") {
+		t.Errorf("team responded %q, want the person of another organization unknown", text)
+	}
+	if name := PersonDisplayName(DefaultOrgName, 7); name != "Unknown person" {
+		t.Errorf("named %q, want the user of another organization unknown", name)
+	}
+	if name := PersonDisplayName("north", 7); name != "<@U7>" {
+		t.Errorf("named %q, want <@U7>", name)
+	}
+}
+
+func TestSlackCommandNext(t *testing.T) {
+	newTestApp(t)
+	createTestPlan(t, 10, 5)
+	app.db.Model(&PlanPeople{}).Where("id = ?", 1000).Updates(map[string]interface{}{"status": "U", "team_position_name": "Vocals"})
+	user := SlackUsers{ID: "U5", Org: DefaultOrgName, PCID: 5}
+	org := app.orgs[0]
+
+	text := SlackCommandNext(org, user)
+	if !strings.Contains(text, "*Sunday - Easter*") || !strings.Contains(text, "Positions: Vocals (unconfirmed)") {
+		t.Errorf("responded %q, want the plan and position", text)
+	}
+
+	// Assignments in other organizations to a person with the same ID are not listed.
+	north := &Org{Name: "north"}
+	if text := SlackCommandNext(north, SlackUsers{ID: "U5", Org: "north", PCID: 5}); text != "You are not assigned to any services." {
+		t.Errorf("responded %q in another organization", text)
+	}
+	app.db.Create(&PlanPeople{ID: 2000, Org: "north", Plan: 20, Person: 5, Status: "C", TeamPositionName: "Drums"})
+	if text := SlackCommandNext(org, user); strings.Contains(text, "Drums") {
+		t.Errorf("responded %q, which includes the assignment of another organization", text)
+	}
+}
@@ -1,14 +1,19 @@
 package main
 
 import (
-	"log"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/kkyr/fig"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
 )
 
 // Configurations relating to HTTP server.
@@ -17,6 +22,45 @@ type HTTPConfig struct {
 	Port     uint   `fig:"port"`
 	Debug    bool   `fig:"debug"`
 	APIKey   string `fig:"api_key"`
+
+	APIKeys []APIKeyConfig `fig:"api_keys"` // Named API keys, recorded as the actor in the audit log.
+
+	StreamReplay int `fig:"stream_replay"` // Number of recent messages replayed to stream clients on connect.
+}
+
+// A named API key.
+type APIKeyConfig struct {
+	Name         string `fig:"name"`
+	Key          string `fig:"key"`
+	Organization string `fig:"organization"` // Limit the key to one organization, all organizations if empty.
+}
+
+// Check if any API keys are configured.
+func (c HTTPConfig) HasAPIKeys() bool {
+	return c.APIKey != "" || len(c.APIKeys) != 0
+}
+
+// Get the name of an API key, or an empty string if it is not a configured key.
+// The api_key option is named default.
+func (c HTTPConfig) APIKeyName(key string) string {
+	return c.FindAPIKey(key).Name
+}
+
+// Get the configuration of an API key, with an empty name if it is not a configured key.
+// The api_key option is named default and may be used with all organizations.
+func (c HTTPConfig) FindAPIKey(key string) APIKeyConfig {
+	if key == "" {
+		return APIKeyConfig{}
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(c.APIKey)) == 1 {
+		return APIKeyConfig{Name: "default", Key: key}
+	}
+	for _, apiKey := range c.APIKeys {
+		if apiKey.Key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(apiKey.Key)) == 1 {
+			return apiKey
+		}
+	}
+	return APIKeyConfig{}
 }
 
 // Configurations relating to database.
@@ -28,9 +72,10 @@ type DBConfig struct {
 
 // Configurations relating to Planning Center API/Sync.
 type PlanningCenterConfig struct {
-	AppID          string   `fig:"app_id"`
-	Secret         string   `fig:"secret"`
-	ServiceTypeIDs []uint64 `fig:"service_type_ids"` // Filter to service type IDs listed.
+	AppID          string            `fig:"app_id"`
+	Secret         string            `fig:"secret"`
+	ServiceTypeIDs []uint64          `fig:"service_type_ids"` // Filter to service type IDs listed.
+	WebhookSecrets map[string]string `fig:"webhook_secrets"`  // Authenticity secrets for webhooks, keyed by event name.
 }
 
 // Configurations relating to Slack API/channel creation.
@@ -38,23 +83,244 @@ type SlackConfig struct {
 	CreateFromWeekday   int           `fig:"create_from_weekday"`   // Create ahead from this weekday. -1 value is default and will instead create from the current time of operation.
 	CreateChannelsAhead time.Duration `fig:"create_channels_ahead"` // Amount of time of future services to create channels head for. Defaults to 8 days head.
 	APIToken            string        `fig:"api_token"`
+	SigningSecret       string        `fig:"signing_secret"`       // Used to verify requests from Slack.
 	StickyUsers         []string      `fig:"sticky_users"`         // Users to add to every channel.
 	DefaultConversation string        `fig:"default_conversation"` // Slack user that administers this app.
+	SchedulingRequests  bool          `fig:"scheduling_requests"`  // Ask unconfirmed people to accept or decline in a direct message.
 }
 
-// Configuration Structure.
-type Config struct {
-	HTTP           HTTPConfig           `fig:"http"`
-	DB             DBConfig             `fig:"database"`
+// Configurations relating to reminder digests of unconfirmed and unfilled positions.
+type RemindersConfig struct {
+	CoordinatorChannel string        `fig:"coordinator_channel"` // Channel to post digests to, digests are disabled if empty.
+	Interval           time.Duration `fig:"interval"`            // Minimum time between digests. Defaults to 1 day.
+	Weekdays           []int         `fig:"weekdays"`            // Weekdays to send digests on, every day if empty.
+	NudgeUnconfirmed   bool          `fig:"nudge_unconfirmed"`   // Send unconfirmed people a direct message.
+	NudgeInterval      time.Duration `fig:"nudge_interval"`      // Minimum time between nudges to the same person. Defaults to 2 days.
+	QuietHoursStart    int           `fig:"quiet_hours_start"`   // Hour of the day, in local time, that quiet hours start.
+	QuietHoursEnd      int           `fig:"quiet_hours_end"`     // Hour of the day that quiet hours end. Same as start to disable.
+}
+
+// Configurations for an organization, such as a campus, with its own Planning Center account and Slack workspace.
+type OrganizationConfig struct {
+	Name           string               `fig:"name"` // Recorded with synced data, so should not change once used.
 	PlanningCenter PlanningCenterConfig `fig:"planning_center"`
 	Slack          SlackConfig          `fig:"slack"`
+	Reminders      RemindersConfig      `fig:"reminders"`
+	Email          EmailConfig          `fig:"email"` // Used instead of the top level email when a host is set.
+}
+
+// Configurations for a notification posted relative to a plan time.
+type CountdownConfig struct {
+	TimeType       string        `fig:"time_type"`        // Plan time type, such as rehearsal, service or other.
+	Offset         time.Duration `fig:"offset"`           // Time relative to the start, negative for before.
+	Message        string        `fig:"message"`          // Template of the message to post to the plan channel.
+	ServiceTypeIDs []uint64      `fig:"service_type_ids"` // Service types this applies to, all if empty.
+}
+
+// Configurations for a notifier on a platform other than slack.
+type NotifierConfig struct {
+	Name                string            `fig:"name"`                 // Name used to reference this notifier.
+	Type                string            `fig:"type"`                 // Either discord or mattermost.
+	BaseURL             string            `fig:"base_url"`             // API URL, defaults to the discord API for discord.
+	Token               string            `fig:"token"`                // Bot token for discord, or access token for mattermost.
+	GuildID             string            `fig:"guild_id"`             // Discord server to create channels in.
+	ArchiveCategoryID   string            `fig:"archive_category_id"`  // Discord category to move old channels to.
+	TeamID              string            `fig:"team_id"`              // Mattermost team to create channels in.
+	StickyUsers         []string          `fig:"sticky_users"`         // Users to add to every channel.
+	DefaultConversation string            `fig:"default_conversation"` // Conversation for messages when no service is occurring.
+	Users               map[string]string `fig:"users"`                // Planning center person IDs mapped to user IDs.
+	ServiceTypeIDs      []uint64          `fig:"service_type_ids"`     // Service types which use this notifier instead of slack.
+}
+
+// Configurations relating to emailing people who are not matched to a chat user.
+type EmailConfig struct {
+	Host                string `fig:"host"` // SMTP server, email is disabled if empty.
+	Port                uint   `fig:"port"`
+	TLS                 bool   `fig:"tls"` // Use implicit TLS instead of STARTTLS.
+	Username            string `fig:"username"`
+	Password            string `fig:"password"`
+	From                string `fig:"from"`
+	BatchSize           int    `fig:"batch_size"`           // Number of emails to send per connection.
+	AnnouncementSubject string `fig:"announcement_subject"` // Template of the subject when added to a service.
+	AnnouncementText    string `fig:"announcement_text"`    // Template of the text body when added to a service.
+	AnnouncementHTML    string `fig:"announcement_html"`    // Template of the HTML body when added to a service.
+	MessageSubject      string `fig:"message_subject"`      // Template of the subject for messages sent to a service.
+	MessageText         string `fig:"message_text"`         // Template of the text body for messages sent to a service.
+	MessageHTML         string `fig:"message_html"`         // Template of the HTML body for messages sent to a service.
+}
+
+// Configurations relating to text messages.
+type SMSConfig struct {
+	Provider        string   `fig:"provider"` // Only twilio is supported, text messages are disabled if empty.
+	BaseURL         string   `fig:"base_url"` // API URL, defaults to the twilio API.
+	AccountSID      string   `fig:"account_sid"`
+	AuthToken       string   `fig:"auth_token"`
+	From            string   `fig:"from"`             // Phone number to send from.
+	WebhookURL      string   `fig:"webhook_url"`      // Full URL twilio sends replies to, used to verify signatures.
+	UrgentPositions []string `fig:"urgent_positions"` // Positions to text urgent messages to, all if empty.
+}
+
+// Configurations for a webhook which is sent lifecycle events.
+type OutgoingWebhookConfig struct {
+	URL         string   `fig:"url"`
+	Secret      string   `fig:"secret"`       // Used to sign payloads with HMAC-SHA256.
+	Events      []string `fig:"events"`       // Events to send, all if empty.
+	MaxAttempts int      `fig:"max_attempts"` // Number of delivery attempts. Defaults to 5.
+}
+
+// Configurations for an OSC address mapped to a message.
+type OSCMappingConfig struct {
+	Address   string   `fig:"address"`   // Address pattern to match, such as /service/*.
+	Args      []string `fig:"args"`      // Argument values which must match, in order.
+	Message   string   `fig:"message"`   // Template of the message, with .Address and .Args available.
+	Priority  string   `fig:"priority"`  // Set to urgent to also send a text message.
+	Positions []string `fig:"positions"` // Positions to text urgent messages to.
+}
+
+// Configurations relating to the OSC server.
+type OSCConfig struct {
+	BindAddr     string             `fig:"bind_addr"`
+	Port         uint               `fig:"port"`         // UDP port to listen on, the server is disabled if zero.
+	TCP          bool               `fig:"tcp"`          // Also listen on TCP with the same port.
+	Framing      string             `fig:"framing"`      // TCP framing, either slip (OSC 1.1) or length (OSC 1.0).
+	Organization string             `fig:"organization"` // Organization messages are sent to, the first if empty.
+	Mappings     []OSCMappingConfig `fig:"mappings"`
+}
+
+// Configurations for a MIDI event mapped to a message.
+type MIDIMappingConfig struct {
+	Type      string   `fig:"type"`      // Either note_on, note_off, control_change or program_change.
+	Channel   int      `fig:"channel"`   // Channel 1 through 16, any channel if zero.
+	Number    *int     `fig:"number"`    // Note, controller or program number, any if not set.
+	MinValue  int      `fig:"min_value"` // Minimum velocity or controller value.
+	Message   string   `fig:"message"`   // Template of the message, with the event and .Session available.
+	Priority  string   `fig:"priority"`  // Set to urgent to also send a text message.
+	Positions []string `fig:"positions"` // Positions to text urgent messages to.
+}
+
+// Configurations relating to the RTP-MIDI server.
+type MIDIConfig struct {
+	BindAddr     string              `fig:"bind_addr"`
+	Port         uint                `fig:"port"`         // Control port, with the data port following it. The server is disabled if zero.
+	Name         string              `fig:"name"`         // Session name shown to peers.
+	Organization string              `fig:"organization"` // Organization messages are sent to, the first if empty.
+	Mappings     []MIDIMappingConfig `fig:"mappings"`
+}
+
+// Configurations for a ProPresenter slide rule.
+type ProPresenterRuleConfig struct {
+	Presentation string   `fig:"presentation"` // Regular expression matching the presentation name, any if empty.
+	SlideLabel   string   `fig:"slide_label"`  // Regular expression matching the slide label, any if empty.
+	SlideIndex   *int     `fig:"slide_index"`  // Index of the slide counting from zero, any if not set.
+	Message      string   `fig:"message"`      // Template of the message, with the presentation and slide available.
+	Priority     string   `fig:"priority"`     // Set to urgent to also send a text message.
+	Positions    []string `fig:"positions"`    // Positions to text urgent messages to.
+}
+
+// Configurations relating to ProPresenter.
+type ProPresenterConfig struct {
+	URL          string                   `fig:"url"`           // Network API URL, such as http://10.0.0.5:50001. Disabled if empty.
+	Mode         string                   `fig:"mode"`          // Either stream or poll, defaults to stream.
+	PollInterval time.Duration            `fig:"poll_interval"` // How often to poll for the current slide.
+	Organization string                   `fig:"organization"`  // Organization messages are sent to, the first if empty.
+	Rules        []ProPresenterRuleConfig `fig:"rules"`
+}
+
+// Configurations relating to the web dashboard.
+type DashboardConfig struct {
+	Enabled           bool          `fig:"enabled"`
+	SessionSecret     string        `fig:"session_secret"`      // Used to sign sessions, random on each start if empty.
+	SessionLifetime   time.Duration `fig:"session_lifetime"`    // How long a sign in lasts.
+	SlackClientID     string        `fig:"slack_client_id"`     // Slack app credentials for Sign in with Slack.
+	SlackClientSecret string        `fig:"slack_client_secret"` //
+	SlackRedirectURL  string        `fig:"slack_redirect_url"`  // Full URL of /dashboard/login/slack/callback.
+	AllowedUsers      []string      `fig:"allowed_users"`       // Slack user IDs allowed to sign in, workspace admins if empty.
+}
+
+// Configurations relating to calendar feeds.
+type ICalConfig struct {
+	Enabled   bool   `fig:"enabled"`
+	PublicURL string `fig:"public_url"` // URL the service is reachable at, used to link to feeds.
+	TimeZone  string `fig:"time_zone"`  // IANA time zone events are shown in, such as America/Chicago.
+}
+
+// Configuration Structure.
+type Config struct {
+	HTTP             HTTPConfig              `fig:"http"`
+	DB               DBConfig                `fig:"database"`
+	PlanningCenter   PlanningCenterConfig    `fig:"planning_center"`
+	Slack            SlackConfig             `fig:"slack"`
+	Reminders        RemindersConfig         `fig:"reminders"`
+	Countdowns       []CountdownConfig       `fig:"countdowns"`
+	Notifiers        []NotifierConfig        `fig:"notifiers"`
+	Email            EmailConfig             `fig:"email"`
+	SMS              SMSConfig               `fig:"sms"`
+	OutgoingWebhooks []OutgoingWebhookConfig `fig:"outgoing_webhooks"`
+	OSC              OSCConfig               `fig:"osc"`
+	MIDI             MIDIConfig              `fig:"midi"`
+	ProPresenter     ProPresenterConfig      `fig:"propresenter"`
+	Dashboard        DashboardConfig         `fig:"dashboard"`
+	ICal             ICalConfig              `fig:"ical"`
+
+	Organizations []OrganizationConfig `fig:"organizations"` // Organizations served, otherwise planning_center, slack and reminders are used as one organization.
+}
+
+// Decode a configuration file into a document of maps and lists, as fig does by its extension.
+// Returns nil if the file can not be read or decoded, which fig reports when loading it.
+func ReadConfigDocument(configFile string) map[string]interface{} {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil
+	}
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		var tree *toml.Tree
+		tree, err = toml.LoadBytes(data)
+		if err == nil {
+			doc = tree.ToMap()
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	return doc
+}
+
+// Defaults of the email configuration, which are also used for each organization.
+var DefaultEmailConfig = EmailConfig{
+	Port:                587,
+	BatchSize:           50,
+	AnnouncementSubject: DefaultAnnouncementSubject,
+	AnnouncementText:    DefaultAnnouncementText,
+	AnnouncementHTML:    DefaultAnnouncementHTML,
+	MessageSubject:      DefaultMessageSubject,
+	MessageText:         DefaultMessageText,
+	MessageHTML:         DefaultMessageHTML,
 }
 
-// Load the configuration.
-func (a *App) ReadConfig() {
+// Defaults of the Slack configuration, which are also used for each organization.
+var DefaultSlackConfig = SlackConfig{
+	CreateFromWeekday:   -1,
+	CreateChannelsAhead: time.Hour * 24 * 8,
+}
+
+// Defaults of the reminders configuration, which are also used for each organization.
+var DefaultRemindersConfig = RemindersConfig{
+	Interval:      time.Hour * 24,
+	NudgeInterval: time.Hour * 24 * 2,
+}
+
+// Load the configuration, returning the problems found with it.
+// The configuration is only set if it could be loaded without errors.
+func (a *App) ReadConfig() (ConfigProblems, error) {
 	usr, err := user.Current()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	// Configuration paths.
@@ -72,9 +338,8 @@ func (a *App) ReadConfig() {
 		configFile = homeDirConfig
 	} else if _, err := os.Stat(etcConfig); err == nil {
 		configFile = etcConfig
-	} else {
-		log.Fatal("Unable to find a configuration file.")
 	}
+	app.configFile = configFile
 
 	// Load the configuration file.
 	config := &Config{
@@ -82,26 +347,62 @@ func (a *App) ReadConfig() {
 			BindAddr: "",
 			Port:     34935,
 			Debug:    true,
+
+			StreamReplay: 50,
 		},
 		DB: DBConfig{
 			Type:       "sqlite3",
 			Connection: "service-notifications.db",
 		},
-		Slack: SlackConfig{
-			CreateFromWeekday:   -1,
-			CreateChannelsAhead: time.Hour * 24 * 8,
+		Slack:     DefaultSlackConfig,
+		Email:     DefaultEmailConfig,
+		Reminders: DefaultRemindersConfig,
+		MIDI: MIDIConfig{
+			Name: serviceName,
+		},
+		ProPresenter: ProPresenterConfig{
+			PollInterval: time.Second,
+		},
+		Dashboard: DashboardConfig{
+			SessionLifetime: time.Hour * 12,
+		},
+		ICal: ICalConfig{
+			TimeZone: "UTC",
 		},
 	}
 
-	// Load configuration.
-	filePath, fileName := path.Split(configFile)
-	err = fig.Load(config,
-		fig.File(fileName),
-		fig.Dirs(filePath),
-	)
+	// Lists are decoded into existing items, so fill in defaults for each organization configured.
+	for i := 0; i < ConfigOrganizationCount(configFile); i++ {
+		config.Organizations = append(config.Organizations, OrganizationConfig{
+			Slack:     DefaultSlackConfig,
+			Reminders: DefaultRemindersConfig,
+			Email:     DefaultEmailConfig,
+		})
+	}
+
+	// Load configuration from the file, then the environment which takes precedence.
+	// Without a file, the configuration is only loaded from the environment.
+	options := []fig.Option{fig.UseEnv(ConfigEnvPrefix)}
+	if configFile != "" {
+		filePath, fileName := path.Split(configFile)
+		options = append(options, fig.File(fileName), fig.Dirs(filePath))
+	} else {
+		options = append(options, fig.IgnoreFile())
+	}
+	err = fig.Load(config, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing configuration %s: %w", configFile, err)
+	}
+	err = LoadConfigEnvFiles(config)
 	if err != nil {
-		log.Printf("Error parsing configuration: %s\n", err)
-		return
+		return nil, fmt.Errorf("error reading configuration file from environment: %w", err)
+	}
+
+	// Default the number of attempts for webhooks.
+	for i := range config.OutgoingWebhooks {
+		if config.OutgoingWebhooks[i].MaxAttempts <= 0 {
+			config.OutgoingWebhooks[i].MaxAttempts = 5
+		}
 	}
 
 	// Override flags.
@@ -112,6 +413,15 @@ func (a *App) ReadConfig() {
 		config.HTTP.Port = app.flags.HTTPPort
 	}
 
+	// Validate the configuration, failing if there are errors.
+	problems := append(ConfigKeyProblems(configFile), ConfigEnvProblems(config)...)
+	problems = append(problems, config.Validate()...)
+	if problems.HasErrors() {
+		return problems, errors.New("invalid configuration")
+	}
+
 	// Set global config structure.
 	app.config = config
+	app.InitOrgs()
+	return problems, nil
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+	"time"
+)
+
+// How late a countdown notification may be sent, such as after a restart.
+const CountdownGracePeriod = time.Minute * 5
+
+// Data available to countdown message templates.
+type CountdownData struct {
+	ServiceType ServiceTypes
+	Plan        Plans
+	PlanTime    PlanTimes
+	Topic       string
+	StartsAt    time.Time // Start of the plan time in local time.
+	Minutes     int       // Minutes until the plan time starts.
+}
+
+// The key used to record that a countdown was sent for a plan time.
+func (c CountdownConfig) Key() string {
+	return fmt.Sprintf("%s %s", c.TimeType, c.Offset)
+}
+
+// Check if a countdown applies to a service type.
+func (c CountdownConfig) AppliesTo(serviceTypeID uint64) bool {
+	if len(c.ServiceTypeIDs) == 0 {
+		return true
+	}
+	for _, id := range c.ServiceTypeIDs {
+		if id == serviceTypeID {
+			return true
+		}
+	}
+	return false
+}
+
+// Render a countdown message for a plan time.
+func (c CountdownConfig) Render(planTime PlanTimes, plan Plans, serviceType ServiceTypes) (string, error) {
+	tmpl, err := template.New("countdown").Parse(c.Message)
+	if err != nil {
+		return "", err
+	}
+	data := CountdownData{
+		ServiceType: serviceType,
+		Plan:        plan,
+		PlanTime:    planTime,
+		Topic:       PlanTopic(serviceType, plan),
+		StartsAt:    planTime.StartsAt.Local(),
+		Minutes:     int(-c.Offset.Minutes()),
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Send countdown notifications that are due.
+func SendCountdowns() {
+	now := time.Now().UTC()
+	for _, countdown := range app.config.Countdowns {
+		// Find plan times where the countdown is due, allowing for a grace period.
+		var planTimes []PlanTimes
+		app.db.Where("time_type = ? AND starts_at > ? AND starts_at <= ?", countdown.TimeType, now.Add(-countdown.Offset-CountdownGracePeriod), now.Add(-countdown.Offset)).Find(&planTimes)
+		for _, planTime := range planTimes {
+			// Skip if already sent.
+			var sent SentNotifications
+			app.db.Where("plan_time = ? AND notification = ?", planTime.ID, countdown.Key()).First(&sent)
+			if sent.ID != 0 {
+				continue
+			}
+
+			// Get the plan and check the service type.
+			var plan Plans
+			app.db.Where("id = ?", planTime.Plan).First(&plan)
+			if plan.ID == 0 || !countdown.AppliesTo(plan.ServiceType) {
+				continue
+			}
+			var serviceType ServiceTypes
+			app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+
+			// Build the message.
+			message, err := countdown.Render(planTime, plan, serviceType)
+			if err != nil {
+				log.Println("Error rendering countdown:", err)
+				continue
+			}
+
+			// Post to the plan channel.
+			err = PostPlanMessage(ActorScheduler, plan.ID, message)
+			if err != nil {
+				log.Println("Error sending countdown:", err)
+				continue
+			}
+
+			// Record it was sent so restarts do not send it again.
+			app.db.Create(&SentNotifications{
+				PlanTime:     planTime.ID,
+				Notification: countdown.Key(),
+				SentAt:       now,
+			})
+		}
+	}
+}
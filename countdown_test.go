@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Create a plan with a channel whose service starts after a duration.
+func countdownTestPlan(t *testing.T, planID uint64, after time.Duration) {
+	createTestPlan(t, planID)
+	app.db.Model(&PlanTimes{}).Where("id = ?", planID).Update("starts_at", time.Now().Add(after).UTC())
+	app.db.Create(&SlackChannels{ID: fmt.Sprintf("C%d", planID), Org: DefaultOrgName, PCPlan: planID})
+}
+
+func TestSendCountdowns(t *testing.T) {
+	notifier := newTestApp(t)
+	app.config.Countdowns = []CountdownConfig{{
+		TimeType: "service",
+		Offset:   -10 * time.Minute,
+		Message:  "{{.Topic}} starts in {{.Minutes}} minutes",
+	}}
+	countdownTestPlan(t, 10, 9*time.Minute)  // Due.
+	countdownTestPlan(t, 11, 12*time.Minute) // Not due yet.
+	countdownTestPlan(t, 12, 4*time.Minute)  // Missed by more than the grace period.
+
+	// Only the countdown which is due is sent, once.
+	SendCountdowns()
+	SendCountdowns()
+	posts := notifier.WaitForPosts(t, 1)
+	if len(posts) != 1 || posts[0].Conversation != "C10" || posts[0].Message != "Sunday - Easter starts in 10 minutes" {
+		t.Fatalf("posted %+v, want one countdown to C10", posts)
+	}
+	var sent []SentNotifications
+	app.db.Find(&sent)
+	if len(sent) != 1 || sent[0].PlanTime != 10 || sent[0].Notification != "service -10m0s" {
+		t.Errorf("recorded %+v, want the countdown of plan time 10", sent)
+	}
+	events, _ := QueryAuditEvents(AuditFilter{Actor: ActorScheduler, Action: AuditMessagePost})
+	if len(events) != 1 || events[0].Target != "C10" {
+		t.Errorf("audit events %+v, want the countdown recorded", events)
+	}
+
+	// Countdowns of other time types and service types are not sent.
+	app.db.Where("1 = 1").Delete(&SentNotifications{})
+	app.config.Countdowns[0].ServiceTypeIDs = []uint64{2}
+	SendCountdowns()
+	app.config.Countdowns[0].ServiceTypeIDs = nil
+	app.config.Countdowns[0].TimeType = "rehearsal"
+	SendCountdowns()
+	if posts := notifier.WaitForPosts(t, 0); len(posts) != 1 {
+		t.Errorf("posted %+v, want nothing more", posts)
+	}
+}
+
+func TestCountdownRender(t *testing.T) {
+	countdown := CountdownConfig{Offset: -30 * time.Minute, Message: "{{.ServiceType.Name}} {{.PlanTime.TimeType}} in {{.Minutes}}"}
+	message, err := countdown.Render(PlanTimes{TimeType: "rehearsal"}, Plans{}, ServiceTypes{Name: "Sunday"})
+	if err != nil || message != "Sunday rehearsal in 30" {
+		t.Errorf("rendered %q %v", message, err)
+	}
+	countdown.Message = "{{.Missing}}"
+	if _, err := countdown.Render(PlanTimes{}, Plans{}, ServiceTypes{}); err == nil {
+		t.Error("rendered a template with an unknown field")
+	}
+}
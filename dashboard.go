@@ -0,0 +1,460 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Cookies used by the dashboard.
+const (
+	DashboardSessionCookie = "service_notifications_session"
+	DashboardStateCookie   = "service_notifications_state"
+)
+
+// Slack OpenID Connect endpoints.
+const (
+	SlackOpenIDAuthorizeURL = "https://slack.com/openid/connect/authorize"
+	SlackOpenIDTokenURL     = "https://slack.com/api/openid.connect.token"
+	SlackOpenIDUserInfoURL  = "https://slack.com/api/openid.connect.userInfo"
+)
+
+// Templates and static files for the dashboard.
+//
+//go:embed web
+var dashboardFS embed.FS
+
+// Functions available to dashboard templates.
+var DashboardTemplateFuncs = template.FuncMap{
+	"topic": PlanTopic,
+	"datetime": func(t time.Time) string {
+		if t.IsZero() || t.Unix() <= 0 {
+			return ""
+		}
+		return t.Local().Format("Mon Jan 2, 3:04 PM")
+	},
+	"duration": func(start, end time.Time) string {
+		if end.Before(start) {
+			return ""
+		}
+		return end.Sub(start).Round(time.Second).String()
+	},
+}
+
+// Parsed dashboard templates.
+var dashboardTemplates = template.Must(template.New("").Funcs(DashboardTemplateFuncs).ParseFS(dashboardFS, "web/*.html"))
+
+// An upcoming plan shown on the dashboard.
+type DashboardPlan struct {
+	Plan        Plans
+	ServiceType ServiceTypes
+	Times       []PlanTimes
+	Channel     SlackChannels
+}
+
+// A channel shown on the dashboard with its members.
+type DashboardChannel struct {
+	Channel SlackChannels
+	Members []string
+}
+
+// Data available to the dashboard template.
+type DashboardData struct {
+	User            string
+	Organization    string // Organization the user is limited to, all if empty.
+	Plans           []DashboardPlan
+	Channels        []DashboardChannel
+	UnmatchedPeople []People
+	UnmatchedUsers  []SlackUsers
+	SyncRuns        []SyncRuns
+	Messages        []StreamMessage
+}
+
+// Data available to the login template.
+type DashboardLoginData struct {
+	APIKey bool
+	Slack  bool
+	Error  string
+}
+
+// Response from the Slack OpenID Connect token endpoint.
+type SlackOpenIDTokenResp struct {
+	OK          bool   `json:"ok"`
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+// Response from the Slack OpenID Connect user info endpoint.
+type SlackOpenIDUserInfo struct {
+	OK     bool   `json:"ok"`
+	Error  string `json:"error"`
+	UserID string `json:"https://slack.com/user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+// Secret used to sign sessions, generated at startup if not configured.
+var dashboardSecret []byte
+
+// Get the secret used to sign sessions.
+func DashboardSecret() []byte {
+	if dashboardSecret == nil {
+		if app.config.Dashboard.SessionSecret != "" {
+			dashboardSecret = []byte(app.config.Dashboard.SessionSecret)
+		} else {
+			dashboardSecret = make([]byte, 32)
+			rand.Read(dashboardSecret)
+		}
+	}
+	return dashboardSecret
+}
+
+// Sign a session value.
+func DashboardSign(value string) string {
+	mac := hmac.New(sha256.New, DashboardSecret())
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The signed contents of a dashboard session cookie.
+type DashboardSession struct {
+	User    string `json:"user"`
+	Expires int64  `json:"expires"`
+	Org     string `json:"org,omitempty"` // Organization the user is limited to, all if empty.
+}
+
+// Start a dashboard session for a user, limited to an organization if not empty.
+func (s *HTTPServer) DashboardLogin(w http.ResponseWriter, r *http.Request, user, org string) {
+	expires := time.Now().Add(app.config.Dashboard.SessionLifetime)
+	session, _ := json.Marshal(DashboardSession{User: user, Expires: expires.Unix(), Org: org})
+	value := base64.RawURLEncoding.EncodeToString(session)
+	http.SetCookie(w, &http.Cookie{
+		Name:     DashboardSessionCookie,
+		Value:    value + "." + DashboardSign(value),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Println("Dashboard login:", user)
+	http.Redirect(w, r, "/dashboard/", http.StatusSeeOther)
+}
+
+// Get the user signed in to the dashboard and the organization they are limited to, empty if not signed in.
+func (s *HTTPServer) DashboardUser(r *http.Request) (user, org string) {
+	if !app.config.Dashboard.Enabled {
+		return
+	}
+	cookie, err := r.Cookie(DashboardSessionCookie)
+	if err != nil {
+		return
+	}
+
+	// Verify the signature.
+	value, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(DashboardSign(value))) {
+		return
+	}
+
+	// Check the session has not expired.
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return
+	}
+	var session DashboardSession
+	err = json.Unmarshal(decoded, &session)
+	if err != nil || session.User == "" || time.Now().Unix() > session.Expires {
+		return
+	}
+	return session.User, session.Org
+}
+
+// Check if a Slack user may sign in to the dashboard.
+func DashboardSlackUserAllowed(userID string) bool {
+	// If users are listed, only they are allowed.
+	if len(app.config.Dashboard.AllowedUsers) != 0 {
+		for _, allowed := range app.config.Dashboard.AllowedUsers {
+			if allowed == userID {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Otherwise workspace admins are allowed.
+	var slackUser SlackUsers
+	app.db.Where("id = ?", userID).First(&slackUser)
+	return !slackUser.Deleted && (slackUser.IsAdmin || slackUser.IsOwner)
+}
+
+// Render a dashboard template.
+func (s *HTTPServer) DashboardRender(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplates.ExecuteTemplate(w, name, data)
+	if err != nil {
+		log.Println("Error rendering dashboard:", err)
+	}
+}
+
+// Get the data shown on the dashboard, limited to an organization if not empty.
+func DashboardGetData(org string) (data DashboardData) {
+	now := time.Now().UTC()
+	orgScope := func(db *gorm.DB) *gorm.DB {
+		if org == "" {
+			return db
+		}
+		return db.Where("org = ?", org)
+	}
+
+	// Upcoming plans with their times and channel.
+	var plans []Plans
+	app.db.Scopes(orgScope).Where("last_time_at >= ?", now).Order("first_time_at ASC").Limit(25).Find(&plans)
+	var planIDs []uint64
+	for _, plan := range plans {
+		planIDs = append(planIDs, plan.ID)
+		p := DashboardPlan{Plan: plan}
+		app.db.Where("id = ?", plan.ServiceType).First(&p.ServiceType)
+		app.db.Where("plan = ?", plan.ID).Order("starts_at ASC").Find(&p.Times)
+		p.Channel = PlanChannel(plan.ID)
+		data.Plans = append(data.Plans, p)
+	}
+
+	// Channels which are not archived, with the names of their members.
+	var channels []SlackChannels
+	app.db.Scopes(orgScope).Where("archived != 1").Order("starts_at ASC").Find(&channels)
+	for _, channel := range channels {
+		c := DashboardChannel{Channel: channel}
+		for _, uid := range ChannelActiveUsers(channel.ID) {
+			var slackUser SlackUsers
+			app.db.Where("id = ?", uid).First(&slackUser)
+			if slackUser.RealName != "" {
+				uid = slackUser.RealName
+			}
+			c.Members = append(c.Members, uid)
+		}
+		data.Channels = append(data.Channels, c)
+	}
+
+	// People on upcoming plans without a matching Slack user.
+	if len(planIDs) != 0 {
+		var personIDs []uint64
+		app.db.Model(&PlanPeople{}).Where("plan IN ? AND status != 'D'", planIDs).Distinct().Pluck("person", &personIDs)
+		if len(personIDs) != 0 {
+			app.db.Where("id IN ? AND id NOT IN (?)", personIDs, app.db.Model(&SlackUsers{}).Select("pc_id")).Order("last_name ASC, first_name ASC").Find(&data.UnmatchedPeople)
+		}
+	}
+
+	// Slack users without a matching person.
+	app.db.Scopes(orgScope).Where("pc_id = 0 AND deleted != 1 AND is_bot != 1 AND id != 'USLACKBOT'").Order("real_name ASC").Find(&data.UnmatchedUsers)
+
+	// Recent update runs.
+	app.db.Order("started_at DESC").Limit(10).Find(&data.SyncRuns)
+
+	// Recent messages, newest first.
+	messageStream.Lock()
+	for i := len(messageStream.recent) - 1; i >= 0; i-- {
+		if org == "" || messageStream.recent[i].Organization == org {
+			data.Messages = append(data.Messages, messageStream.recent[i])
+		}
+	}
+	messageStream.Unlock()
+	return
+}
+
+// Random value used to verify the Slack sign in state.
+func DashboardRandomState() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Exchange a Slack sign in code for the user signing in.
+func SlackOpenIDUser(code string) (info SlackOpenIDUserInfo, err error) {
+	config := app.config.Dashboard
+
+	// Exchange the code for an access token.
+	res, err := http.PostForm(SlackOpenIDTokenURL, url.Values{
+		"client_id":     {config.SlackClientID},
+		"client_secret": {config.SlackClientSecret},
+		"code":          {code},
+		"redirect_uri":  {config.SlackRedirectURL},
+	})
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	var token SlackOpenIDTokenResp
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return
+	}
+	if !token.OK {
+		return info, fmt.Errorf("token exchange failed: %s", token.Error)
+	}
+
+	// Get the user the token is for.
+	req, err := http.NewRequest(http.MethodGet, SlackOpenIDUserInfoURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		return
+	}
+	if !info.OK {
+		return info, fmt.Errorf("user info failed: %s", info.Error)
+	}
+	return
+}
+
+// Setup HTTP router with routes for the dashboard.
+func (s *HTTPServer) RegisterDashboardRoutes(r *mux.Router) {
+	config := app.config.Dashboard
+	if !config.Enabled {
+		return
+	}
+	slackEnabled := config.SlackClientID != ""
+	if !s.config.HasAPIKeys() && !slackEnabled {
+		log.Fatalln("The dashboard requires an API key or Slack sign in to be configured")
+	}
+	d := r.PathPrefix("/dashboard").Subrouter()
+
+	// Static files.
+	static, _ := fs.Sub(dashboardFS, "web/static")
+	d.PathPrefix("/static/").Handler(http.StripPrefix("/dashboard/static/", http.FileServer(http.FS(static))))
+
+	// The dashboard, redirecting to login if not signed in.
+	d.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		user, org := s.DashboardUser(r)
+		if user == "" {
+			http.Redirect(w, r, "/dashboard/login", http.StatusSeeOther)
+			return
+		}
+		data := DashboardGetData(org)
+		data.User = user
+		data.Organization = org
+		s.DashboardRender(w, "dashboard.html", data)
+	}).Methods(http.MethodGet)
+
+	// Login page.
+	loginData := DashboardLoginData{
+		APIKey: s.config.HasAPIKeys(),
+		Slack:  slackEnabled,
+	}
+	d.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s.DashboardRender(w, "login.html", loginData)
+	}).Methods(http.MethodGet)
+
+	// Login with the API key.
+	d.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		key := s.config.FindAPIKey(r.PostFormValue("api_key"))
+		if key.Name == "" {
+			data := loginData
+			data.Error = "Invalid API key"
+			w.WriteHeader(http.StatusForbidden)
+			s.DashboardRender(w, "login.html", data)
+			return
+		}
+		s.DashboardLogin(w, r, "API key "+key.Name, key.Organization)
+	}).Methods(http.MethodPost)
+
+	// Logout.
+	d.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{
+			Name:   DashboardSessionCookie,
+			Path:   "/",
+			MaxAge: -1,
+		})
+		http.Redirect(w, r, "/dashboard/login", http.StatusSeeOther)
+	}).Methods(http.MethodPost)
+
+	if !slackEnabled {
+		return
+	}
+
+	// Start Slack sign in.
+	d.HandleFunc("/login/slack", func(w http.ResponseWriter, r *http.Request) {
+		state := DashboardRandomState()
+		http.SetCookie(w, &http.Cookie{
+			Name:     DashboardStateCookie,
+			Value:    state,
+			Path:     "/dashboard/login/slack",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		query := url.Values{
+			"response_type": {"code"},
+			"scope":         {"openid profile email"},
+			"client_id":     {config.SlackClientID},
+			"redirect_uri":  {config.SlackRedirectURL},
+			"state":         {state},
+		}
+		http.Redirect(w, r, SlackOpenIDAuthorizeURL+"?"+query.Encode(), http.StatusSeeOther)
+	}).Methods(http.MethodGet)
+
+	// Finish Slack sign in.
+	d.HandleFunc("/login/slack/callback", func(w http.ResponseWriter, r *http.Request) {
+		failed := func(message string) {
+			data := loginData
+			data.Error = message
+			w.WriteHeader(http.StatusForbidden)
+			s.DashboardRender(w, "login.html", data)
+		}
+
+		// Verify the state matches the one we started with.
+		cookie, err := r.Cookie(DashboardStateCookie)
+		if err != nil || cookie.Value == "" || cookie.Value != r.URL.Query().Get("state") {
+			failed("Sign in expired, please try again")
+			return
+		}
+		if r.URL.Query().Get("error") != "" {
+			failed("Sign in was cancelled")
+			return
+		}
+
+		// Get the user and check they are allowed.
+		info, err := SlackOpenIDUser(r.URL.Query().Get("code"))
+		if err != nil {
+			log.Println("Slack sign in failed:", err)
+			failed("Sign in with Slack failed")
+			return
+		}
+		if !DashboardSlackUserAllowed(info.UserID) {
+			log.Println("Slack user not allowed on dashboard:", info.UserID, info.Name)
+			failed("You are not allowed to access the dashboard")
+			return
+		}
+		// Workspace admins are limited to their organization, people listed as allowed are not.
+		org := ""
+		if len(app.config.Dashboard.AllowedUsers) == 0 {
+			var slackUser SlackUsers
+			app.db.Where("id = ?", info.UserID).First(&slackUser)
+			org = slackUser.Org
+		}
+		s.DashboardLogin(w, r, info.Name, org)
+	}).Methods(http.MethodGet)
+}
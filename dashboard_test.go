@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sign in to the dashboard, returning a request with the session cookie.
+func dashboardSessionRequest(t *testing.T, s *HTTPServer, user, org string) *http.Request {
+	w := httptest.NewRecorder()
+	s.DashboardLogin(w, httptest.NewRequest(http.MethodPost, "/dashboard/login", nil), user, org)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("set cookies %+v, want the session", cookies)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/dashboard/", nil)
+	r.AddCookie(cookies[0])
+	return r
+}
+
+func TestDashboardSession(t *testing.T) {
+	app = &App{config: &Config{Dashboard: DashboardConfig{
+		Enabled:         true,
+		SessionSecret:   "secret",
+		SessionLifetime: time.Hour,
+	}}}
+	dashboardSecret = nil
+	s := &HTTPServer{}
+
+	// Names with the characters used by earlier sessions are kept intact.
+	for _, c := range []struct{ user, org string }{
+		{"Ann Lee", ""},
+		{"Ann | Worship|123|north", "south"},
+		{"api:stage", "north"},
+	} {
+		r := dashboardSessionRequest(t, s, c.user, c.org)
+		user, org := s.DashboardUser(r)
+		if user != c.user || org != c.org {
+			t.Errorf("session for %q %q read as %q %q", c.user, c.org, user, org)
+		}
+	}
+
+	// A session with a changed payload is rejected.
+	r := dashboardSessionRequest(t, s, "Ann", "north")
+	cookie, _ := r.Cookie(DashboardSessionCookie)
+	_, signature, _ := strings.Cut(cookie.Value, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"user":"Ann","expires":9999999999}`))
+	r = httptest.NewRequest(http.MethodGet, "/dashboard/", nil)
+	r.AddCookie(&http.Cookie{Name: DashboardSessionCookie, Value: forged + "." + signature})
+	if user, _ := s.DashboardUser(r); user != "" {
+		t.Errorf("forged session accepted for %q", user)
+	}
+
+	// An expired session is rejected.
+	app.config.Dashboard.SessionLifetime = -time.Minute
+	r = dashboardSessionRequest(t, s, "Ann", "")
+	if user, _ := s.DashboardUser(r); user != "" {
+		t.Errorf("expired session accepted for %q", user)
+	}
+}
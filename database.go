@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"gorm.io/driver/mysql"
@@ -14,6 +16,7 @@ import (
 // Planning Center service types.
 type ServiceTypes struct {
 	ID         uint64    `gorm:"primary_key" json:"id"`
+	Org        string    `gorm:"size:64;index;default:default" json:"org"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 	ArchivedAt time.Time `json:"archived_at"`
@@ -24,6 +27,7 @@ type ServiceTypes struct {
 // Planning Center plans.
 type Plans struct {
 	ID          uint64    `gorm:"primary_key" json:"id"`
+	Org         string    `gorm:"size:64;index;default:default" json:"org"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	SeriesTitle string    `json:"series_title"`
@@ -38,6 +42,7 @@ type Plans struct {
 // Planning Center plan times, different times a plan has assigned.
 type PlanTimes struct {
 	ID           uint64    `gorm:"primary_key" json:"id"`
+	Org          string    `gorm:"size:64;index;default:default" json:"org"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	Name         string    `json:"name"`
@@ -52,17 +57,34 @@ type PlanTimes struct {
 // Planning Center people assigned to a plan.
 type PlanPeople struct {
 	ID               uint64    `gorm:"primary_key" json:"id"`
+	Org              string    `gorm:"size:64;index;default:default" json:"org"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	Status           string    `json:"status"`
 	TeamPositionName string    `json:"team_position_name"`
 	Person           uint64    `json:"person"`
 	Plan             uint64    `json:"plan"`
+	RequestSentAt    time.Time `json:"request_sent_at"`    // When the scheduling request was sent in Slack.
+	RequestChannel   string    `json:"request_channel"`    // Direct message the scheduling request was sent in.
+	RequestMessageTS string    `json:"request_message_ts"` // Timestamp of the scheduling request message.
+	EmailedAt        time.Time `json:"emailed_at"`         // When the channel announcement was emailed.
+}
+
+// Planning Center positions on a plan that still need to be filled.
+type NeededPositions struct {
+	ID               uint64    `gorm:"primary_key" json:"id"`
+	Org              string    `gorm:"size:64;index;default:default" json:"org"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	TeamPositionName string    `json:"team_position_name"`
+	Quantity         uint64    `json:"quantity"`
+	Plan             uint64    `json:"plan"`
 }
 
 // Planning Center people information.
 type People struct {
 	ID          uint64    `gorm:"primary_key" json:"id"`
+	Org         string    `gorm:"size:64;index;default:default" json:"org"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	ArchivedAt  time.Time `json:"archived_at"`
@@ -73,12 +95,14 @@ type People struct {
 	FirstName   string    `json:"first_name"`
 	LastName    string    `json:"last_name"`
 	FacebookID  uint64    `json:"facebook_id"`
+	Email       string    `json:"email"` // Only fetched for people who need to be emailed.
 	Distance    uint64    `gorm:"-:all"`
 }
 
 // Slack users and their association with Planning Center people.
 type SlackUsers struct {
 	ID                string    `gorm:"primary_key" json:"id"`
+	Org               string    `gorm:"size:64;index;default:default" json:"org"`
 	Name              string    `json:"name"`
 	RealName          string    `json:"real_name"`
 	FirstName         string    `json:"first_name"`
@@ -97,54 +121,162 @@ type SlackUsers struct {
 	IsInvitedUser     bool      `json:"is_invited_user"`
 	Updated           time.Time `json:"updated"`
 	PCID              uint64    `json:"pc_id"`
+	ManualMatch       bool      `json:"manual_match"` // Matched or unmatched with the match command, so not matched automatically.
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
 
-// Slack channels that were created and state information.
+// Channels that were created and state information.
 type SlackChannels struct {
-	ID           string    `gorm:"primary_key" json:"id"`
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	PCPlan       uint64    `json:"pc_plan"`
-	StartsAt     time.Time `json:"starts_at"`
-	EndsAt       time.Time `json:"ends_at"`
-	UsersInvited string    `json:"users_invited"`
-	Archived     bool      `json:"archived"`
+	ID          string    `gorm:"primary_key" json:"id"`
+	Org         string    `gorm:"size:64;index;default:default" json:"org"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	PCPlan      uint64    `json:"pc_plan"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	Archived    bool      `json:"archived"`
+	Notifier    string    `json:"notifier"` // Name of the notifier the channel was created with.
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// Configure the database and add tables/adjust tables to match structures above.
-func (a *App) InitDB() {
-	var err error
+// Reminders that were sent, used to keep the reminder cadence across restarts.
+type ReminderLogs struct {
+	ID     uint64    `gorm:"primary_key" json:"id"`
+	Org    string    `gorm:"size:64;index;default:default" json:"org"`
+	Kind   string    `json:"kind"` // Either digest or nudge.
+	Ref    uint64    `json:"ref"`  // Service type for digests, plan person for nudges.
+	SentAt time.Time `json:"sent_at"`
+}
+
+// Notifications sent for plan times, so restarts do not send them again.
+type SentNotifications struct {
+	ID           uint64    `gorm:"primary_key" json:"id"`
+	PlanTime     uint64    `json:"plan_time"`
+	Notification string    `json:"notification"`
+	SentAt       time.Time `json:"sent_at"`
+}
+
+// People who opted in to receive text messages.
+type SMSSubscriptions struct {
+	ID         uint64    `gorm:"primary_key" json:"id"`
+	Person     uint64    `json:"person"`
+	Phone      string    `json:"phone"`
+	OptedIn    bool      `json:"opted_in"`
+	OptedOutAt time.Time `json:"opted_out_at"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Deliveries of events to outgoing webhooks.
+type WebhookDeliveries struct {
+	ID          uint64    `gorm:"primary_key" json:"id"`
+	URL         string    `json:"url"`
+	Event       string    `json:"event"`
+	Payload     string    `json:"payload"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"status_code"`
+	LastError   string    `json:"last_error"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Users invited to channels. User is a reserved word in Postgres, so columns are suffixed with their type.
+type ChannelMembers struct {
+	ID        uint64    `gorm:"primary_key" json:"id"`
+	ChannelID string    `gorm:"size:64;uniqueIndex:idx_channel_members_channel_user" json:"channel_id"`
+	UserID    string    `gorm:"size:64;uniqueIndex:idx_channel_members_channel_user" json:"user_id"`
+	Reason    string    `json:"reason"`     // Either sticky, position or manual.
+	InvitedAt time.Time `json:"invited_at"` // Zero if the user has not been invited successfully.
+	RemovedAt time.Time `json:"removed_at"` // Set when the user leaves or is removed from the channel.
+	LastError string    `json:"last_error"` // Error from the last attempt to invite the user.
+
+	InviteAttempts   int  `json:"invite_attempts"`   // Failed invites since the last success.
+	PermanentFailure bool `json:"permanent_failure"` // Set when inviting will not succeed, so it is not tried again.
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Runs of the update, shown on the dashboard.
+type SyncRuns struct {
+	ID         uint64    `gorm:"primary_key" json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"` // Zero if the update did not finish.
+}
+
+// Tokens for calendar feeds of people.
+type CalendarTokens struct {
+	ID        uint64    `gorm:"primary_key" json:"id"`
+	Person    uint64    `gorm:"uniqueIndex" json:"person"`
+	Token     string    `gorm:"uniqueIndex;size:64" json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Outbound actions, such as creating channels and posting messages.
+type AuditEvents struct {
+	ID        uint64    `gorm:"primary_key" json:"id"`
+	Org       string    `gorm:"size:64;index;default:default" json:"org"` // Organization the action was taken for.
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	Actor     string    `gorm:"size:191;index" json:"actor"`  // Who caused the action, such as cron, api:<key name> or slack:<user id>.
+	Action    string    `gorm:"size:64;index" json:"action"`  // The action taken, such as channel.invite.
+	Platform  string    `gorm:"size:64" json:"platform"`      // The notifier or service acted on.
+	Target    string    `gorm:"size:191;index" json:"target"` // The conversation, person or resource acted on.
+	Request   string    `gorm:"type:text" json:"request"`     // Summary of what was sent.
+	Response  string    `gorm:"type:text" json:"response"`    // Summary of what was received.
+	Result    string    `gorm:"size:16" json:"result"`        // Either ok or error.
+	Error     string    `gorm:"type:text" json:"error"`
+}
+
+// Enable foreign keys on a SQLite connection, which SQLite only enforces when enabled on each connection.
+func SQLiteDSN(connection string) string {
+	if strings.Contains(connection, "_foreign_keys=") || strings.Contains(connection, "_fk=") {
+		return connection
+	}
+	if strings.Contains(connection, "?") {
+		return connection + "&_foreign_keys=1"
+	}
+	return connection + "?_foreign_keys=1"
+}
+
+// Open a database connection.
+func OpenDatabase(config DBConfig) (*gorm.DB, error) {
 	dbConfig := &gorm.Config{}
 	// If debug is enabled, enable the logger.
-	if a.config.DB.Debug {
+	if config.Debug {
 		dbConfig.Logger = logger.Default.LogMode(logger.Info)
 	}
 	// Depending on connection configuration, open the database.
-	if a.config.DB.Type == "sqlite3" {
-		a.db, err = gorm.Open(sqlite.Open(a.config.DB.Connection), dbConfig)
-	} else if a.config.DB.Type == "mysql" {
-		a.db, err = gorm.Open(mysql.Open(a.config.DB.Connection), dbConfig)
-	} else if a.config.DB.Type == "postgres" {
-		a.db, err = gorm.Open(postgres.Open(a.config.DB.Connection), dbConfig)
-	} else {
-		log.Fatal("Incorrect database config")
+	switch config.Type {
+	case "sqlite3":
+		return gorm.Open(sqlite.Open(SQLiteDSN(config.Connection)), dbConfig)
+	case "mysql":
+		return gorm.Open(mysql.Open(config.Connection), dbConfig)
+	case "postgres":
+		return gorm.Open(postgres.Open(config.Connection), dbConfig)
 	}
+	return nil, fmt.Errorf("unknown database type %q", config.Type)
+}
+
+// Connect to the configured database.
+func (a *App) OpenDB() {
+	var err error
+	a.db, err = OpenDatabase(a.config.DB)
 	// If a error occurs connecting to the database, fail.
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
-	// Update tables on database to match the above definitions.
-	a.db.AutoMigrate(&ServiceTypes{})
-	a.db.AutoMigrate(&Plans{})
-	a.db.AutoMigrate(&PlanTimes{})
-	a.db.AutoMigrate(&PlanPeople{})
-	a.db.AutoMigrate(&People{})
-	a.db.AutoMigrate(&SlackUsers{})
-	a.db.AutoMigrate(&SlackChannels{})
+// Connect to the database and apply migrations which have not been applied.
+func (a *App) InitDB() {
+	a.OpenDB()
+	err := MigrateUp(0)
+	if err != nil {
+		log.Fatalln(err)
+	}
 }
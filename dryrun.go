@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// An action which would have been taken during a dry run.
+type DryRunAction struct {
+	Action       string   `json:"action"`
+	Notifier     string   `json:"notifier"`
+	Channel      string   `json:"channel,omitempty"`
+	ChannelName  string   `json:"channel_name,omitempty"`
+	Topic        string   `json:"topic,omitempty"`
+	Users        []string `json:"users,omitempty"`
+	Message      string   `json:"message,omitempty"`
+	NewChannelID string   `json:"new_channel_id,omitempty"`
+}
+
+// The actions a dry run would have taken, in order.
+type DryRunPlan struct {
+	Actions []DryRunAction `json:"actions"`
+
+	channelNames map[string]string
+}
+
+// Add an action to the plan, naming the channel it acts on.
+func (p *DryRunPlan) Add(action DryRunAction) {
+	if action.Channel != "" && action.ChannelName == "" {
+		action.ChannelName = p.channelNames[action.Channel]
+		if action.ChannelName == "" {
+			var channel SlackChannels
+			app.db.Where("id = ?", action.Channel).First(&channel)
+			action.ChannelName = channel.Name
+		}
+	}
+	p.Actions = append(p.Actions, action)
+}
+
+// A notifier which records actions in a dry run plan instead of taking them.
+type DryRunNotifier struct {
+	Notifier
+	name string
+	plan *DryRunPlan
+}
+
+// Record creating a conversation, returning a placeholder ID.
+func (n *DryRunNotifier) CreateConversation(name string) (string, error) {
+	id := "dry-run:" + name
+	n.plan.channelNames[id] = name
+	n.plan.Add(DryRunAction{Action: AuditChannelCreate, Notifier: n.name, ChannelName: name, NewChannelID: id})
+	return id, nil
+}
+
+// Record setting the topic of a conversation.
+func (n *DryRunNotifier) SetTopic(conversationID, topic string) error {
+	n.plan.Add(DryRunAction{Action: AuditChannelTopic, Notifier: n.name, Channel: conversationID, Topic: topic})
+	return nil
+}
+
+// Record inviting users to a conversation.
+func (n *DryRunNotifier) Invite(conversationID string, userIDs ...string) error {
+	n.plan.Add(DryRunAction{Action: AuditChannelInvite, Notifier: n.name, Channel: conversationID, Users: userIDs})
+	return nil
+}
+
+// The update does not remove users from conversations, but a dry run never passes removals through.
+func (n *DryRunNotifier) Remove(conversationID, userID string) error {
+	return nil
+}
+
+// Record posting a message to a conversation.
+func (n *DryRunNotifier) PostMessage(conversationID, message string) error {
+	n.plan.Add(DryRunAction{Action: AuditMessagePost, Notifier: n.name, Channel: conversationID, Message: message})
+	return nil
+}
+
+// Record archiving a conversation.
+func (n *DryRunNotifier) Archive(conversationID string) error {
+	n.plan.Add(DryRunAction{Action: AuditChannelArchive, Notifier: n.name, Channel: conversationID})
+	return nil
+}
+
+// Run the update in a transaction which is rolled back, printing the channel changes it would make.
+func RunDryRun(asJSON bool) {
+	// Run everything against a transaction that is never committed.
+	db := app.db
+	tx := db.Begin()
+	if tx.Error != nil {
+		log.Fatalln("Unable to start transaction:", tx.Error)
+	}
+	app.db = tx
+	defer func() {
+		tx.Rollback()
+		app.db = db
+	}()
+
+	// Record notifier actions instead of taking them.
+	plan := &DryRunPlan{channelNames: make(map[string]string)}
+	notifiers := app.notifiers
+	app.notifiers = make(map[string]Notifier)
+	for name, notifier := range notifiers {
+		app.notifiers[name] = &DryRunNotifier{
+			Notifier: notifier,
+			name:     name,
+			plan:     plan,
+		}
+	}
+	orgNotifiers := make([]Notifier, len(app.orgs))
+	for i, org := range app.orgs {
+		orgNotifiers[i] = org.notifier
+		org.notifier = &DryRunNotifier{
+			Notifier: org.notifier,
+			name:     SlackNotifierName,
+			plan:     plan,
+		}
+	}
+
+	// Other outbound actions are skipped, using a copy of the configuration without them.
+	config := app.config
+	dryRunConfig := *config
+	dryRunConfig.OutgoingWebhooks = nil
+	dryRunConfig.Email.Host = ""
+	app.config = &dryRunConfig
+	orgEmails := make([]*EmailConfig, len(app.orgs))
+	for i, org := range app.orgs {
+		orgEmails[i] = org.Email
+		org.Email = &EmailConfig{}
+	}
+	defer func() {
+		app.config = config
+		app.notifiers = notifiers
+		for i, org := range app.orgs {
+			org.notifier = orgNotifiers[i]
+			org.Email = orgEmails[i]
+		}
+	}()
+
+	// Update data and reconcile channels as the update would.
+	run := SyncRuns{StartedAt: time.Now().UTC()}
+	app.db.Create(&run)
+	for _, org := range SelectedOrgs() {
+		UpdatePCData(org)
+		UpdateSlackData(org)
+		CreateSlackChannels(org)
+	}
+
+	// Print the plan.
+	if asJSON {
+		if plan.Actions == nil {
+			plan.Actions = []DryRunAction{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(plan)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	if len(plan.Actions) == 0 {
+		fmt.Println("No changes.")
+		return
+	}
+	for _, action := range plan.Actions {
+		channel := action.ChannelName
+		if channel == "" {
+			channel = action.Channel
+		}
+		switch action.Action {
+		case AuditChannelCreate:
+			fmt.Printf("%s: create #%s\n", action.Notifier, channel)
+		case AuditChannelTopic:
+			fmt.Printf("%s: set topic of #%s to %q\n", action.Notifier, channel, action.Topic)
+		case AuditChannelInvite:
+			fmt.Printf("%s: invite %s to #%s\n", action.Notifier, strings.Join(action.Users, ", "), channel)
+		case AuditMessagePost:
+			fmt.Printf("%s: post to %s %q\n", action.Notifier, channel, action.Message)
+		case AuditChannelArchive:
+			fmt.Printf("%s: archive #%s\n", action.Notifier, channel)
+		}
+	}
+}
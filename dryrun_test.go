@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Serve Planning Center and Slack with one upcoming plan and its volunteer.
+func dryRunStandIn(t *testing.T) {
+	startsAt := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	resources := map[string]string{
+		"/services/v2/people":                                    `[{"id":"5","attributes":{"first_name":"Ann","last_name":"Lee"}}]`,
+		"/services/v2/service_types":                             `[{"id":"1","attributes":{"name":"Sunday"}}]`,
+		"/services/v2/service_types/1/plans":                     `[{"id":"10","attributes":{"title":"Easter","sort_date":"` + startsAt + `"}}]`,
+		"/services/v2/service_types/1/plans/10/plan_times":       `[{"id":"100","attributes":{"time_type":"service","starts_at":"` + startsAt + `","ends_at":"` + startsAt + `"}}]`,
+		"/services/v2/service_types/1/plans/10/team_members":     `[{"id":"1000","attributes":{"status":"C","team_position_name":"Vocals"},"relationships":{"person":{"data":{"id":"5"}}}}]`,
+		"/services/v2/service_types/1/plans/10/needed_positions": `[]`,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/users.list" {
+			w.Write([]byte(`{"ok":true,"members":[{"id":"U5","name":"ann","real_name":"Ann Lee","profile":{"first_name":"Ann","last_name":"Lee"}}]}`))
+			return
+		}
+		data, ok := resources[r.URL.Path]
+		if !ok {
+			t.Errorf("requested %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":` + data + `}`))
+	}))
+	t.Cleanup(s.Close)
+	pcBaseURL := PCBaseURL
+	PCBaseURL = s.URL
+	t.Cleanup(func() { PCBaseURL = pcBaseURL })
+	app.orgs[0].slack = slack.New("xoxb-token", slack.OptionAPIURL(s.URL+"/api/"))
+}
+
+// Run a dry run, returning the plan printed as JSON.
+func dryRunTestPlan(t *testing.T) DryRunPlan {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	RunDryRun(true)
+	os.Stdout = stdout
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan DryRunPlan
+	err = json.Unmarshal(out, &plan)
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	return plan
+}
+
+// Count the rows of every table.
+func dryRunTestRows(t *testing.T) map[string]int64 {
+	tables, err := app.db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string]int64)
+	for _, table := range tables {
+		var count int64
+		app.db.Table(table).Count(&count)
+		rows[table] = count
+	}
+	return rows
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	notifier := newTestApp(t)
+	app.config.Slack.StickyUsers = []string{"U_ADMIN"}
+	app.config.Slack.CreateChannelsAhead = 7 * 24 * time.Hour
+	app.config.OutgoingWebhooks = []OutgoingWebhookConfig{{URL: "https://example.com/hook"}}
+	app.config.Email.Host = "smtp.example.com"
+	app.db.Create(&ServiceTypes{ID: 1, Org: DefaultOrgName, Name: "Old name"})
+	dryRunStandIn(t)
+	config := app.config
+	rows := dryRunTestRows(t)
+
+	plan := dryRunTestPlan(t)
+
+	// The channel changes are planned.
+	var created, invited bool
+	for _, action := range plan.Actions {
+		switch action.Action {
+		case AuditChannelCreate:
+			created = true
+		case AuditChannelInvite:
+			invited = true
+		}
+	}
+	if !created || !invited {
+		t.Errorf("planned %+v, want a channel created and users invited", plan.Actions)
+	}
+
+	// But nothing is written or sent.
+	if after := dryRunTestRows(t); len(after) != len(rows) {
+		t.Errorf("tables %v after the dry run, want %v", after, rows)
+	} else {
+		for table, count := range rows {
+			if after[table] != count {
+				t.Errorf("%s has %d rows after the dry run, want %d", table, after[table], count)
+			}
+		}
+	}
+	var serviceType ServiceTypes
+	app.db.Where("id = ?", 1).First(&serviceType)
+	if serviceType.Name != "Old name" {
+		t.Errorf("service type renamed to %q", serviceType.Name)
+	}
+	if len(notifier.WaitForPosts(t, 0)) != 0 || len(notifier.TakeInvites()) != 0 {
+		t.Error("dry run used the notifier")
+	}
+
+	// The configuration and notifiers are restored.
+	if app.config != config || len(app.config.OutgoingWebhooks) != 1 || app.config.Email.Host != "smtp.example.com" {
+		t.Errorf("configuration changed to %+v", app.config)
+	}
+	if app.orgs[0].notifier != notifier {
+		t.Error("organization notifier not restored")
+	}
+}
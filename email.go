@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Default templates for emails.
+const (
+	DefaultAnnouncementSubject = "You are scheduled for {{.Topic}}"
+	DefaultAnnouncementText    = `Hi {{.FirstName}},
+
+You are scheduled for {{.Topic}} on {{.StartsAt.Format "Monday, January 2 at 3:04 PM"}}.
+
+Positions: {{join .Positions ", "}}
+
+Messages sent to the team will be emailed to you.
+`
+	DefaultAnnouncementHTML = `<p>Hi {{.FirstName}},</p>
+<p>You are scheduled for <strong>{{.Topic}}</strong> on {{.StartsAt.Format "Monday, January 2 at 3:04 PM"}}.</p>
+<p>Positions: {{join .Positions ", "}}</p>
+<p>Messages sent to the team will be emailed to you.</p>
+`
+	DefaultMessageSubject = "{{.Topic}}"
+	DefaultMessageText    = "{{.Message}}\n"
+	DefaultMessageHTML    = "<p>{{.Message}}</p>\n"
+)
+
+// Functions available to email templates.
+var EmailTemplateFuncs = map[string]interface{}{
+	"join": strings.Join,
+}
+
+// Certificate authorities trusted for the SMTP server, the system pool if nil.
+var SMTPRootCAs *x509.CertPool
+
+// Data available to email templates.
+type EmailData struct {
+	FirstName string
+	LastName  string
+	Topic     string
+	StartsAt  time.Time // Start of the service in local time.
+	Positions []string
+	Message   string
+}
+
+// An email to be sent.
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Get a person with their email address, fetching it from planning center if not already known.
+func PersonWithEmail(personID uint64) (person People) {
+	app.db.Where("id = ?", personID).First(&person)
+	if person.ID == 0 || person.Email != "" {
+		return
+	}
+	org := OrgByName(person.Org)
+	if org == nil {
+		log.Println("Unknown organization for person:", personID, person.Org)
+		return
+	}
+
+	// Get the email addresses for the person.
+	emails, err := PCGetAll(org, fmt.Sprintf("/people/v2/people/%d/emails", personID))
+	if err != nil {
+		log.Println("Unable to get email for person:", personID, err)
+		return
+	}
+
+	// Prefer the primary address, otherwise use the first.
+	for _, data := range emails {
+		attributes := data.GetDict("attributes")
+		if person.Email == "" || attributes.GetBool("primary") {
+			person.Email = attributes.GetString("address")
+		}
+	}
+	if person.Email != "" {
+		app.db.Save(&person)
+	}
+	return
+}
+
+// Render an email from templates.
+func RenderEmail(to, subject, text, html string, data EmailData) (e Email, err error) {
+	e.To = to
+	var b bytes.Buffer
+
+	// Render the subject and text body with text templates.
+	for _, t := range []struct {
+		tmpl string
+		out  *string
+	}{{subject, &e.Subject}, {text, &e.Text}} {
+		tmpl, err := texttemplate.New("email").Funcs(EmailTemplateFuncs).Parse(t.tmpl)
+		if err != nil {
+			return e, err
+		}
+		b.Reset()
+		err = tmpl.Execute(&b, data)
+		if err != nil {
+			return e, err
+		}
+		*t.out = b.String()
+	}
+	e.Subject = strings.TrimSpace(e.Subject)
+
+	// Render the HTML body with an HTML template so values are escaped.
+	tmpl, err := htmltemplate.New("email").Funcs(EmailTemplateFuncs).Parse(html)
+	if err != nil {
+		return e, err
+	}
+	b.Reset()
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		return e, err
+	}
+	e.HTML = b.String()
+	return e, nil
+}
+
+// Build the email message with text and HTML alternatives.
+func (e Email) Message(from string) []byte {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+
+	// Random message ID.
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(addr.Address, "@"); i != -1 {
+			domain = addr.Address[i+1:]
+		}
+	}
+
+	// Write the headers.
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", e.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", e.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	// Write the text and HTML parts.
+	for _, part := range []struct {
+		contentType string
+		body        string
+	}{{"text/plain", e.Text}, {"text/html", e.HTML}} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType+"; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "8bit")
+		w, _ := mw.CreatePart(header)
+		w.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(part.body, "\r\n", "\n"), "\n", "\r\n")))
+	}
+	mw.Close()
+	return b.Bytes()
+}
+
+// Connect to the SMTP server of an email configuration and authenticate.
+func SMTPConnect(config *EmailConfig) (*smtp.Client, error) {
+	addr := net.JoinHostPort(config.Host, strconv.FormatUint(uint64(config.Port), 10))
+	tlsConfig := &tls.Config{ServerName: config.Host, RootCAs: SMTPRootCAs}
+
+	// Connect, either with implicit TLS or upgrading with STARTTLS if available.
+	var c *smtp.Client
+	if config.TLS {
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		c, err = smtp.NewClient(conn, config.Host)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		c, err = smtp.Dial(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			err = c.StartTLS(tlsConfig)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	// Authenticate if configured.
+	if config.Username != "" {
+		err := c.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host))
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Send a single email on an SMTP connection.
+func SMTPSend(c *smtp.Client, from string, e Email) error {
+	// Get the envelope addresses.
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+	toAddr, err := mail.ParseAddress(e.To)
+	if err != nil {
+		return err
+	}
+
+	// Send the message.
+	err = c.Mail(fromAddr.Address)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(toAddr.Address)
+	if err != nil {
+		c.Reset()
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(e.Message(from))
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Send emails for an organization in batches, with each batch sent on one connection.
+// Returns an error for each email, nil if it was sent.
+func SendEmails(org *Org, actor string, emails []Email) []error {
+	errs := make([]error, len(emails))
+	batchSize := org.Email.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	for start := 0; start < len(emails); start += batchSize {
+		end := start + batchSize
+		if end > len(emails) {
+			end = len(emails)
+		}
+
+		// Connect for this batch.
+		c, err := SMTPConnect(org.Email)
+		if err != nil {
+			log.Println("Unable to connect to SMTP server:", err)
+			for i := start; i < end; i++ {
+				errs[i] = err
+				Audit(org, actor, AuditEmailSend, "email", emails[i].To, emails[i].Subject, "", err)
+			}
+			continue
+		}
+
+		// Send each email in the batch.
+		for i := start; i < end; i++ {
+			errs[i] = SMTPSend(c, org.Email.From, emails[i])
+			Audit(org, actor, AuditEmailSend, "email", emails[i].To, emails[i].Subject, "", errs[i])
+			if errs[i] != nil {
+				log.Println("Unable to send email to", emails[i].To, errs[i])
+			}
+		}
+		c.Quit()
+	}
+	return errs
+}
+
+// Get the data for emails about a plan.
+func PlanEmailData(planID uint64) (data EmailData) {
+	var plan Plans
+	app.db.Where("id = ?", planID).First(&plan)
+	var serviceType ServiceTypes
+	app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+	var planTime PlanTimes
+	app.db.Where("plan = ? AND time_type='service'", planID).Order("starts_at ASC").First(&planTime)
+
+	data.Topic = PlanTopic(serviceType, plan)
+	data.StartsAt = planTime.StartsAt.Local()
+	return
+}
+
+// Email people on a plan of an organization, who were not invited to the channel, that they were scheduled.
+func EmailAnnouncements(org *Org, actor string, planTime PlanTimes, unmatched []PlanPeople) {
+	// Group positions by person, skipping those already emailed.
+	var personIDs []uint64
+	positions := make(map[uint64][]PlanPeople)
+	for _, planPerson := range unmatched {
+		if !planPerson.EmailedAt.IsZero() || planPerson.Status == "D" {
+			continue
+		}
+		if _, ok := positions[planPerson.Person]; !ok {
+			personIDs = append(personIDs, planPerson.Person)
+		}
+		positions[planPerson.Person] = append(positions[planPerson.Person], planPerson)
+	}
+
+	// Build the email for each person.
+	var emails []Email
+	var emailed [][]PlanPeople
+	for _, personID := range personIDs {
+		person := PersonWithEmail(personID)
+		if person.Email == "" {
+			continue
+		}
+
+		// Render the announcement.
+		data := PlanEmailData(planTime.Plan)
+		data.FirstName = person.FirstName
+		data.LastName = person.LastName
+		for _, planPerson := range positions[personID] {
+			data.Positions = append(data.Positions, planPerson.TeamPositionName)
+		}
+		to := (&mail.Address{Name: person.FirstName + " " + person.LastName, Address: person.Email}).String()
+		config := org.Email
+		e, err := RenderEmail(to, config.AnnouncementSubject, config.AnnouncementText, config.AnnouncementHTML, data)
+		if err != nil {
+			log.Println("Error rendering announcement email:", err)
+			return
+		}
+		emails = append(emails, e)
+		emailed = append(emailed, positions[personID])
+	}
+
+	// Send the emails and record who was emailed.
+	now := time.Now().UTC()
+	for i, err := range SendEmails(org, actor, emails) {
+		if err != nil {
+			continue
+		}
+		for _, planPerson := range emailed[i] {
+			planPerson.EmailedAt = now
+			app.db.Save(&planPerson)
+		}
+	}
+}
+
+// Email a message sent to a plan of an organization to people who are not in the channel.
+func EmailPlanMessage(org *Org, actor string, planID uint64, message string) {
+	// Find the notifier used for the plan.
+	channel := PlanChannel(planID)
+	notifier := NotifierByName(org, channel.Notifier)
+
+	// Find people on the plan who are not matched to a user.
+	var peopleOnPlan []PlanPeople
+	app.db.Where("plan = ? AND status != 'D'", planID).Find(&peopleOnPlan)
+	var emails []Email
+	seen := make(map[uint64]bool)
+	for _, planPerson := range peopleOnPlan {
+		if seen[planPerson.Person] || notifier.UserForPerson(planPerson.Person) != "" {
+			continue
+		}
+		seen[planPerson.Person] = true
+		person := PersonWithEmail(planPerson.Person)
+		if person.Email == "" {
+			continue
+		}
+
+		// Render the message.
+		data := PlanEmailData(planID)
+		data.FirstName = person.FirstName
+		data.LastName = person.LastName
+		data.Message = message
+		to := (&mail.Address{Name: person.FirstName + " " + person.LastName, Address: person.Email}).String()
+		config := org.Email
+		e, err := RenderEmail(to, config.MessageSubject, config.MessageText, config.MessageHTML, data)
+		if err != nil {
+			log.Println("Error rendering message email:", err)
+			return
+		}
+		emails = append(emails, e)
+	}
+
+	// Send the emails.
+	SendEmails(org, actor, emails)
+}
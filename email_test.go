@@ -0,0 +1,353 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A message received by the SMTP sink.
+type smtpMessage struct {
+	From string
+	To   string
+	Data string
+}
+
+// A connection to the SMTP sink.
+type smtpSession struct {
+	TLS      bool   // Whether the connection was encrypted.
+	AuthTLS  bool   // Whether the connection was encrypted when authenticating.
+	Auth     string // Decoded AUTH PLAIN credentials.
+	Messages []smtpMessage
+}
+
+// A minimal in-process SMTP server which records the messages it receives.
+type smtpSink struct {
+	listener net.Listener
+	startTLS *tls.Config // Offer STARTTLS with this configuration, if set.
+	implicit bool        // Connections use implicit TLS.
+
+	mu       sync.Mutex
+	sessions []*smtpSession
+}
+
+// Start an SMTP sink, offering STARTTLS or using implicit TLS, and configure email to send to it.
+func newSMTPSink(t *testing.T, startTLS, implicit bool) *smtpSink {
+	// Borrow the certificate of a TLS test server, which is valid for 127.0.0.1.
+	ts := httptest.NewTLSServer(nil)
+	tlsConfig := &tls.Config{Certificates: ts.TLS.Certificates}
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	ts.Close()
+
+	s := &smtpSink{implicit: implicit}
+	var err error
+	if implicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if startTLS {
+		s.startTLS = tlsConfig
+	}
+	t.Cleanup(func() { s.listener.Close() })
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	// Send email to the sink.
+	SMTPRootCAs = pool
+	t.Cleanup(func() { SMTPRootCAs = nil })
+	newTestApp(t)
+	app.config.Email = EmailConfig{
+		Host:      "127.0.0.1",
+		Port:      uint(s.listener.Addr().(*net.TCPAddr).Port),
+		TLS:       implicit,
+		From:      "Service Notifications <notify@example.com>",
+		BatchSize: 2,
+	}
+	return s
+}
+
+// Handle an SMTP connection.
+func (s *smtpSink) serve(conn net.Conn) {
+	session := &smtpSession{TLS: s.implicit}
+	s.mu.Lock()
+	s.sessions = append(s.sessions, session)
+	s.mu.Unlock()
+
+	tp := textproto.NewConn(conn)
+	defer func() { tp.Close() }()
+	tp.PrintfLine("220 localhost ESMTP")
+	var message smtpMessage
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			if s.startTLS != nil && !session.TLS {
+				tp.PrintfLine("250-STARTTLS")
+			}
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.startTLS)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(tlsConn)
+			s.mu.Lock()
+			session.TLS = true
+			s.mu.Unlock()
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			s.mu.Lock()
+			session.Auth = string(credentials)
+			session.AuthTLS = session.TLS
+			s.mu.Unlock()
+			tp.PrintfLine("235 Authenticated")
+		case "MAIL":
+			message = smtpMessage{From: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			message.To = strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Send data")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			s.mu.Lock()
+			session.Messages = append(session.Messages, message)
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// Get the sessions received so far.
+func (s *smtpSink) Sessions() []smtpSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []smtpSession
+	for _, session := range s.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
+
+// Render emails to different people with the default message templates.
+func testEmails(t *testing.T, n int) []Email {
+	var emails []Email
+	for i := 0; i < n; i++ {
+		to := (&mail.Address{Name: "Person " + string(rune('A'+i)), Address: string(rune('a'+i)) + "@example.com"}).String()
+		e, err := RenderEmail(to, DefaultMessageSubject, DefaultMessageText, DefaultMessageHTML, EmailData{Topic: "Sunday", Message: "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		emails = append(emails, e)
+	}
+	return emails
+}
+
+func TestRenderEmail(t *testing.T) {
+	data := EmailData{
+		FirstName: "Ann",
+		LastName:  "Lee",
+		Topic:     "Sunday <AM>",
+		StartsAt:  time.Date(2024, 3, 10, 9, 30, 0, 0, time.UTC),
+		Positions: []string{"Vocals", "Keys & Synth"},
+	}
+	e, err := RenderEmail("ann@example.com", DefaultAnnouncementSubject, DefaultAnnouncementText, DefaultAnnouncementHTML, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Subject != "You are scheduled for Sunday <AM>" {
+		t.Errorf("subject %q", e.Subject)
+	}
+	for _, want := range []string{"Hi Ann,", "Sunday <AM> on Sunday, March 10 at 9:30 AM", "Positions: Vocals, Keys & Synth"} {
+		if !strings.Contains(e.Text, want) {
+			t.Errorf("text %q does not contain %q", e.Text, want)
+		}
+	}
+	// HTML values are escaped.
+	for _, want := range []string{"<strong>Sunday &lt;AM&gt;</strong>", "Positions: Vocals, Keys &amp; Synth"} {
+		if !strings.Contains(e.HTML, want) {
+			t.Errorf("HTML %q does not contain %q", e.HTML, want)
+		}
+	}
+
+	_, err = RenderEmail("ann@example.com", "{{.Missing", DefaultMessageText, DefaultMessageHTML, data)
+	if err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	e, err := RenderEmail("Ann Lee <ann@example.com>", "Café {{.Topic}}", DefaultMessageText, DefaultMessageHTML, EmailData{Topic: "Sunday", Message: "Line one\nLine <two>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(e.Message("Notify <notify@example.com>"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "Café Sunday" {
+		t.Errorf("subject %q", subject)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("message ID %q is not on the sender's domain", id)
+	}
+
+	// The text and HTML alternatives are included in order.
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q: %v", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Line one\r\nLine <two>\r\n"},
+		{"text/html; charset=utf-8", "<p>Line one\r\nLine &lt;two&gt;</p>\r\n"},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("part %q %q, want %q %q", part.Header.Get("Content-Type"), body, want.contentType, want.body)
+		}
+	}
+}
+
+func TestSendEmailsBatches(t *testing.T) {
+	s := newSMTPSink(t, false, false)
+	emails := testEmails(t, 5)
+	for _, err := range SendEmails(app.orgs[0], ActorCron, emails) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Batches of two are sent on their own connections, without TLS when it is not offered.
+	sessions := s.Sessions()
+	if len(sessions) != 3 {
+		t.Fatalf("%d connections, want 3", len(sessions))
+	}
+	i := 0
+	for n, session := range sessions {
+		want := 2
+		if n == 2 {
+			want = 1
+		}
+		if len(session.Messages) != want {
+			t.Errorf("connection %d sent %d messages, want %d", n, len(session.Messages), want)
+		}
+		if session.TLS || session.Auth != "" {
+			t.Errorf("connection %d used TLS or authenticated", n)
+		}
+		for _, message := range session.Messages {
+			to, _ := mail.ParseAddress(emails[i].To)
+			if message.From != "notify@example.com" || message.To != to.Address {
+				t.Errorf("envelope from %q to %q, want to %q", message.From, message.To, to.Address)
+			}
+			if !strings.Contains(message.Data, "Subject: Sunday\n") || !strings.Contains(message.Data, "<p>Hello</p>") {
+				t.Errorf("message %q is missing the rendered subject or body", message.Data)
+			}
+			i++
+		}
+	}
+
+	// Each email is recorded in the audit log.
+	var count int64
+	app.db.Model(&AuditEvents{}).Where("action = ? AND actor = ? AND result = 'ok'", AuditEmailSend, ActorCron).Count(&count)
+	if count != int64(len(emails)) {
+		t.Errorf("%d audit events, want %d", count, len(emails))
+	}
+}
+
+func TestSendEmailsStartTLS(t *testing.T) {
+	s := newSMTPSink(t, true, false)
+	app.config.Email.Username = "user"
+	app.config.Email.Password = "pass"
+	for _, err := range SendEmails(app.orgs[0], ActorCron, testEmails(t, 1)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The connection is upgraded before authenticating.
+	sessions := s.Sessions()
+	if len(sessions) != 1 || len(sessions[0].Messages) != 1 {
+		t.Fatalf("sessions %+v, want one message", sessions)
+	}
+	if !sessions[0].AuthTLS || sessions[0].Auth != "\x00user\x00pass" {
+		t.Errorf("authenticated with %q, over TLS %t", sessions[0].Auth, sessions[0].AuthTLS)
+	}
+}
+
+func TestSendEmailsImplicitTLS(t *testing.T) {
+	s := newSMTPSink(t, false, true)
+	for _, err := range SendEmails(app.orgs[0], ActorCron, testEmails(t, 1)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions := s.Sessions()
+	if len(sessions) != 1 || !sessions[0].TLS || len(sessions[0].Messages) != 1 {
+		t.Errorf("sessions %+v, want one message over TLS", sessions)
+	}
+}
+
+func TestSendEmailsUntrustedCertificate(t *testing.T) {
+	newSMTPSink(t, true, false)
+	SMTPRootCAs = x509.NewCertPool()
+	errs := SendEmails(app.orgs[0], ActorCron, testEmails(t, 3))
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("email %d sent over an untrusted connection", i)
+		}
+	}
+
+	// Failures are recorded in the audit log.
+	var count int64
+	app.db.Model(&AuditEvents{}).Where("action = ? AND result = 'error'", AuditEmailSend).Count(&count)
+	if count != int64(len(errs)) {
+		t.Errorf("%d failed audit events, want %d", count, len(errs))
+	}
+}
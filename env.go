@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix of environment variables which override the configuration, such as SERVICE_NOTIFICATIONS_SLACK_API_TOKEN.
+const ConfigEnvPrefix = "SERVICE_NOTIFICATIONS"
+
+// Suffix of environment variables which read a value from a file, such as SERVICE_NOTIFICATIONS_SLACK_API_TOKEN_FILE.
+const ConfigEnvFileSuffix = "_FILE"
+
+// Call visit with the environment variable name of each field in the configuration,
+// using the same names as fig. Items in lists are named by index, such as NOTIFIERS_0_TOKEN.
+func walkConfigEnv(v reflect.Value, key string, visit func(key string, v reflect.Value)) {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(time.Time{}):
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("fig"), ",")[0]
+			if name == "" {
+				name = field.Name
+			}
+			walkConfigEnv(v.Field(i), key+"_"+strings.ToUpper(name), visit)
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < v.Len(); i++ {
+			walkConfigEnv(v.Index(i), fmt.Sprintf("%s_%d", key, i), visit)
+		}
+	default:
+		visit(key, v)
+	}
+}
+
+// Read a secret file named by an environment variable.
+// Relative paths are read from $CREDENTIALS_DIRECTORY when set, for systemd credentials.
+func ReadConfigEnvFile(name string) (string, error) {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Set text fields from files named by environment variables ending in _FILE.
+// These take precedence over the variable without the suffix.
+func LoadConfigEnvFiles(config *Config) error {
+	var err error
+	walkConfigEnv(reflect.ValueOf(config), ConfigEnvPrefix, func(key string, v reflect.Value) {
+		name, ok := os.LookupEnv(key + ConfigEnvFileSuffix)
+		if !ok || err != nil || v.Kind() != reflect.String {
+			return
+		}
+		var value string
+		value, err = ReadConfigEnvFile(name)
+		if err != nil {
+			err = fmt.Errorf("%s: %s", key+ConfigEnvFileSuffix, err)
+			return
+		}
+		v.SetString(value)
+	})
+	return err
+}
+
+// Find environment variables with the configuration prefix which do not configure a field.
+func ConfigEnvProblems(config *Config) (p ConfigProblems) {
+	known := make(map[string]bool)
+	walkConfigEnv(reflect.ValueOf(config), ConfigEnvPrefix, func(key string, v reflect.Value) {
+		known[key] = true
+		if v.Kind() == reflect.String {
+			known[key+ConfigEnvFileSuffix] = true
+		}
+	})
+
+	var unknown []string
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		if strings.HasPrefix(key, ConfigEnvPrefix+"_") && !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		p.Warnf(key, "unknown environment variable")
+	}
+	return
+}
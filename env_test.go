@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWalkConfigEnv(t *testing.T) {
+	config := &Config{
+		Notifiers:     []NotifierConfig{{}},
+		Organizations: []OrganizationConfig{{}, {}},
+	}
+	names := make(map[string]reflect.Kind)
+	walkConfigEnv(reflect.ValueOf(config), ConfigEnvPrefix, func(key string, v reflect.Value) {
+		names[key] = v.Kind()
+	})
+
+	// Fields are named by their keys, with items in lists named by index.
+	for name, kind := range map[string]reflect.Kind{
+		"SERVICE_NOTIFICATIONS_SLACK_API_TOKEN":                  reflect.String,
+		"SERVICE_NOTIFICATIONS_HTTP_PORT":                        reflect.Uint,
+		"SERVICE_NOTIFICATIONS_PLANNING_CENTER_SERVICE_TYPE_IDS": reflect.Slice,
+		"SERVICE_NOTIFICATIONS_NOTIFIERS_0_TOKEN":                reflect.String,
+		"SERVICE_NOTIFICATIONS_ORGANIZATIONS_1_SLACK_API_TOKEN":  reflect.String,
+		"SERVICE_NOTIFICATIONS_REMINDERS_INTERVAL":               reflect.Int64,
+	} {
+		if names[name] != kind {
+			t.Errorf("%s visited as %s, want %s", name, names[name], kind)
+		}
+	}
+	for _, name := range []string{
+		"SERVICE_NOTIFICATIONS_NOTIFIERS_1_TOKEN",
+		"SERVICE_NOTIFICATIONS_ORGANIZATIONS_2_NAME",
+		"SERVICE_NOTIFICATIONS_MIDI_MAPPINGS_0_MESSAGE",
+	} {
+		if _, ok := names[name]; ok {
+			t.Errorf("%s visited without an item", name)
+		}
+	}
+}
+
+func TestLoadConfigEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, value := range map[string]string{
+		"token":  "xoxb-file\n",
+		"secret": "pc-secret\r\n",
+	} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Files take precedence over the variable without the suffix, with relative paths read from $CREDENTIALS_DIRECTORY.
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	t.Setenv("SERVICE_NOTIFICATIONS_SLACK_API_TOKEN", "xoxb-env")
+	t.Setenv("SERVICE_NOTIFICATIONS_SLACK_API_TOKEN_FILE", "token")
+	t.Setenv("SERVICE_NOTIFICATIONS_ORGANIZATIONS_0_PLANNING_CENTER_SECRET_FILE", filepath.Join(dir, "secret"))
+	config := &Config{Organizations: []OrganizationConfig{{}}}
+	config.Slack.APIToken = "xoxb-env"
+	err := LoadConfigEnvFiles(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Slack.APIToken != "xoxb-file" {
+		t.Errorf("slack.api_token is %q, want xoxb-file", config.Slack.APIToken)
+	}
+	if config.Organizations[0].PlanningCenter.Secret != "pc-secret" {
+		t.Errorf("organizations[0].planning_center.secret is %q, want pc-secret", config.Organizations[0].PlanningCenter.Secret)
+	}
+
+	// Without $CREDENTIALS_DIRECTORY, relative paths are read from the working directory.
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := ReadConfigEnvFile("token"); err == nil {
+		t.Error("read a relative path from the credentials directory when it is not set")
+	}
+
+	// Files which can not be read are errors naming the variable.
+	t.Setenv("SERVICE_NOTIFICATIONS_SLACK_API_TOKEN_FILE", filepath.Join(dir, "missing"))
+	err = LoadConfigEnvFiles(&Config{})
+	if err == nil || !strings.HasPrefix(err.Error(), "SERVICE_NOTIFICATIONS_SLACK_API_TOKEN_FILE:") {
+		t.Errorf("loaded a missing file: %v", err)
+	}
+}
+
+func TestConfigEnvProblems(t *testing.T) {
+	t.Setenv("SERVICE_NOTIFICATIONS_SLACK_API_TOKEN", "xoxb-env")
+	t.Setenv("SERVICE_NOTIFICATIONS_SLACK_SIGNING_SECRET_FILE", "signing")
+	t.Setenv("SERVICE_NOTIFICATIONS_SLACK_API_TOKN", "xoxb-env")
+	t.Setenv("SERVICE_NOTIFICATIONS_HTTP_PORT_FILE", "port")
+	t.Setenv("SERVICE_NOTIFICATIONS_NOTIFIERS_0_TOKEN", "token")
+
+	// Misspelled variables, files for fields which are not text and items which are not configured are unknown.
+	var paths []string
+	for _, problem := range ConfigEnvProblems(&Config{}) {
+		paths = append(paths, problem.Path)
+	}
+	want := []string{
+		"SERVICE_NOTIFICATIONS_HTTP_PORT_FILE",
+		"SERVICE_NOTIFICATIONS_NOTIFIERS_0_TOKEN",
+		"SERVICE_NOTIFICATIONS_SLACK_API_TOKN",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("problems for %v, want %v", paths, want)
+	}
+}
+
+func TestConfigOrganizationCount(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		file   string
+		config string
+		count  int
+	}{
+		{"config.yaml", "organizations:\n  - name: north\n  - name: south\n", 2},
+		{"config.json", `{"organizations":[{"name":"north"}]}`, 1},
+		{"config.toml", "[[organizations]]\nname = \"north\"\n\n[[organizations]]\nname = \"south\"\n\n[[organizations]]\nname = \"east\"\n", 3},
+		{"config.toml", "[slack]\napi_token = \"xoxb-token\"\n", 0},
+		{"config.yaml", "organizations: [", 0},
+	} {
+		path := filepath.Join(dir, c.file)
+		err := os.WriteFile(path, []byte(c.config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := ConfigOrganizationCount(path); n != c.count {
+			t.Errorf("counted %d organizations in %s %q, want %d", n, c.file, c.config, c.count)
+		}
+	}
+
+	// Organizations configured by index in the environment are counted too.
+	t.Setenv("SERVICE_NOTIFICATIONS_ORGANIZATIONS_3_NAME", "west")
+	if n := ConfigOrganizationCount(filepath.Join(dir, "config.yaml")); n != 4 {
+		t.Errorf("counted %d organizations with the environment, want 4", n)
+	}
+}
@@ -11,15 +11,19 @@ type Flags struct {
 	ConfigPath string
 	HTTPBind   string
 	HTTPPort   uint
+	Org        string
 	Update     bool
+	DryRun     bool
+	DryRunJSON bool
 }
 
 // Parse the supplied flags.
 func (a *App) ParseFlags() {
 	app.flags = new(Flags)
 	flag.Usage = func() {
-		fmt.Printf(serviceName + ": " + serviceDescription + ".\n\nUsage:\n")
+		fmt.Printf(serviceName + ": " + serviceDescription + ".\n\nUsage: " + serviceName + " [flags] [command]\n\nFlags:\n")
 		flag.PrintDefaults()
+		CLIPrintCommands()
 	}
 
 	// If version is requested.
@@ -35,12 +39,19 @@ func (a *App) ParseFlags() {
 	flag.StringVar(&app.flags.HTTPBind, "http-bind", "", "Bind address for http server")
 	flag.UintVar(&app.flags.HTTPPort, "http-port", 0, "Bind port for http server")
 
+	// Limit commands to one organization.
+	flag.StringVar(&app.flags.Org, "org", "", "Only act on the organization `NAME`, all organizations if not set")
+
 	// Runs database update for Slack and Planning Center information,
 	// then it creates slack channels if needed.
 	usage = "Update database and create channels"
 	flag.BoolVar(&app.flags.Update, "update", false, usage)
 	flag.BoolVar(&app.flags.Update, "u", false, usage+" (shorthand)")
 
+	// Preview what the update would do without changing anything.
+	flag.BoolVar(&app.flags.DryRun, "dry-run", false, "Print the channel changes an update would make, without making them")
+	flag.BoolVar(&app.flags.DryRunJSON, "dry-run-json", false, "Print the dry run as JSON")
+
 	// Parse the flags.
 	flag.Parse()
 
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 
 	"github.com/gorilla/handlers"
@@ -33,20 +34,64 @@ func NewHTTPServer() *HTTPServer {
 	s.mux = r
 	// Register API routes.
 	s.RegisterAPIRoutes(r)
-	// Default to notice of service being online.
+	// Register webhook routes.
+	s.RegisterWebhookRoutes(r)
+	// Register Slack app routes.
+	s.RegisterSlackRoutes(r)
+	// Register SMS routes.
+	s.RegisterSMSRoutes(r)
+	// Register calendar feed routes.
+	s.RegisterICalRoutes(r)
+	// Register dashboard routes.
+	s.RegisterDashboardRoutes(r)
+	// Default to the dashboard if enabled, otherwise a notice of service being online.
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if app.config.Dashboard.Enabled {
+			http.Redirect(w, r, "/dashboard/", http.StatusSeeOther)
+			return
+		}
 		io.WriteString(w, "Srvice Notifications is available\n")
 	})
 
 	s.server.Handler = r
 	// If the debug log is enabled, we'll add a middleware handler to log then pass the request to mux router.
 	if app.config.HTTP.Debug {
-		s.server.Handler = handlers.CombinedLoggingHandler(os.Stdout, r)
+		s.server.Handler = handlers.CustomLoggingHandler(os.Stdout, r, WriteAccessLog)
 	}
 
 	return s
 }
 
+// Query parameters which are redacted from the access log, as they carry secrets.
+var RedactedQueryParams = []string{"api_key", "code"}
+
+// Get the URI of a request with secret query parameters redacted.
+func RedactedRequestURI(u url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, param := range RedactedQueryParams {
+		if _, ok := query[param]; ok {
+			query.Set(param, "REDACTED")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.RequestURI()
+}
+
+// Write an access log line in Apache Combined Log Format, with secrets redacted from the query.
+func WriteAccessLog(w io.Writer, params handlers.LogFormatterParams) {
+	req := params.Request
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	request := req.Method + " " + RedactedRequestURI(params.URL) + " " + req.Proto
+	fmt.Fprintf(w, "%s - - [%s] %q %d %d %q %q\n", host, params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700"), request, params.StatusCode, params.Size, req.Referer(), req.UserAgent())
+}
+
 // Start the HTTP server.
 func (s *HTTPServer) Start(ctx context.Context) {
 	isListening := make(chan bool)
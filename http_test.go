@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/handlers"
+)
+
+func TestWriteAccessLog(t *testing.T) {
+	var b bytes.Buffer
+	h := handlers.CustomLoggingHandler(&b, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler still receives the key.
+		if r.URL.Query().Get("api_key") != "SECRET" {
+			t.Errorf("handler got query %q", r.URL.RawQuery)
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}), WriteAccessLog)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stream?api_key=SECRET&service_type=1", nil)
+	req.Header.Set("User-Agent", "test")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := b.String()
+	if strings.Contains(line, "SECRET") {
+		t.Errorf("access log %q contains the API key", line)
+	}
+	for _, want := range []string{`"GET /api/stream?api_key=REDACTED&service_type=1 HTTP/1.1" 418 0 "" "test"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("access log %q does not contain %q", line, want)
+		}
+	}
+}
+
+func TestRedactedRequestURI(t *testing.T) {
+	for uri, want := range map[string]string{
+		"/api/ping":             "/api/ping",
+		"/api/ping?a=1&b=2":     "/api/ping?a=1&b=2",
+		"/api/stream?api_key=x": "/api/stream?api_key=REDACTED",
+		"/dashboard/login/slack/callback?code=x&state=y": "/dashboard/login/slack/callback?code=REDACTED&state=y",
+	} {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		if got := RedactedRequestURI(*req.URL); got != want {
+			t.Errorf("RedactedRequestURI(%q) = %q, want %q", uri, got, want)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// How far back feeds include plans.
+const ICalHistory = time.Hour * 24 * 30
+
+// Formats of dates in calendars.
+const (
+	ICalUTCFormat   = "20060102T150405Z"
+	ICalLocalFormat = "20060102T150405"
+)
+
+// An event in a calendar feed.
+type ICalEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+	Modified    time.Time
+}
+
+// Escape text values in a calendar.
+func ICalEscape(s string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\",
+		";", "\\;",
+		",", "\\,",
+		"\r\n", "\\n",
+		"\n", "\\n",
+	).Replace(s)
+}
+
+// Write a content line, folding lines longer than 75 octets.
+func ICalWriteLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		// Avoid splitting a multi byte character.
+		n := 75
+		for n > 0 && line[n]&0xC0 == 0x80 {
+			n--
+		}
+		b.WriteString(line[:n] + "\r\n ")
+		line = line[n:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// Format a UTC offset for a calendar.
+func ICalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset/60%60)
+}
+
+// Find the time the offset of a location changes between two times.
+func ICalFindTransition(loc *time.Location, start, end time.Time) time.Time {
+	_, startOffset := start.In(loc).Zone()
+	for end.Sub(start) > time.Second {
+		mid := start.Add(end.Sub(start) / 2)
+		_, offset := mid.In(loc).Zone()
+		if offset == startOffset {
+			start = mid
+		} else {
+			end = mid
+		}
+	}
+	return end.Truncate(time.Second)
+}
+
+// Write the time zone definition, with each offset change in the years the events occur.
+func ICalWriteTimeZone(b *strings.Builder, loc *time.Location, events []ICalEvent) {
+	ICalWriteLine(b, "BEGIN:VTIMEZONE")
+	ICalWriteLine(b, "TZID:"+loc.String())
+
+	// Find the years events occur in.
+	first, last := time.Now().Year(), time.Now().Year()
+	for _, event := range events {
+		if year := event.Start.In(loc).Year(); year < first {
+			first = year
+		} else if year > last {
+			last = year
+		}
+	}
+
+	// Write a component for each offset change, checking each day for changes.
+	start := time.Date(first, 1, 1, 0, 0, 0, 0, loc)
+	end := time.Date(last+1, 1, 1, 0, 0, 0, 0, loc)
+	name, offset := start.Zone()
+	transitions := 0
+	for t := start; t.Before(end); t = t.Add(time.Hour * 24) {
+		next := t.Add(time.Hour * 24)
+		nextName, nextOffset := next.Zone()
+		if nextOffset == offset && nextName == name {
+			continue
+		}
+		at := ICalFindTransition(loc, t, next)
+		component := "STANDARD"
+		if nextOffset > offset {
+			component = "DAYLIGHT"
+		}
+		ICalWriteLine(b, "BEGIN:"+component)
+		ICalWriteLine(b, "DTSTART:"+at.In(time.FixedZone("", offset)).Format(ICalLocalFormat))
+		ICalWriteLine(b, "TZOFFSETFROM:"+ICalOffset(offset))
+		ICalWriteLine(b, "TZOFFSETTO:"+ICalOffset(nextOffset))
+		ICalWriteLine(b, "TZNAME:"+ICalEscape(nextName))
+		ICalWriteLine(b, "END:"+component)
+		name, offset = nextName, nextOffset
+		transitions++
+	}
+
+	// Zones without changes have a single standard offset.
+	if transitions == 0 {
+		ICalWriteLine(b, "BEGIN:STANDARD")
+		ICalWriteLine(b, "DTSTART:19700101T000000")
+		ICalWriteLine(b, "TZOFFSETFROM:"+ICalOffset(offset))
+		ICalWriteLine(b, "TZOFFSETTO:"+ICalOffset(offset))
+		ICalWriteLine(b, "TZNAME:"+ICalEscape(name))
+		ICalWriteLine(b, "END:STANDARD")
+	}
+	ICalWriteLine(b, "END:VTIMEZONE")
+}
+
+// Build a calendar with events.
+func ICalCalendar(name string, loc *time.Location, events []ICalEvent) string {
+	var b strings.Builder
+	ICalWriteLine(&b, "BEGIN:VCALENDAR")
+	ICalWriteLine(&b, "VERSION:2.0")
+	ICalWriteLine(&b, "PRODID:-//"+serviceName+"//"+serviceVersion+"//EN")
+	ICalWriteLine(&b, "CALSCALE:GREGORIAN")
+	ICalWriteLine(&b, "METHOD:PUBLISH")
+	ICalWriteLine(&b, "X-WR-CALNAME:"+ICalEscape(name))
+	ICalWriteLine(&b, "X-WR-TIMEZONE:"+loc.String())
+	ICalWriteTimeZone(&b, loc, events)
+
+	now := time.Now().UTC().Format(ICalUTCFormat)
+	for _, event := range events {
+		ICalWriteLine(&b, "BEGIN:VEVENT")
+		ICalWriteLine(&b, "UID:"+event.UID)
+		ICalWriteLine(&b, "DTSTAMP:"+now)
+		if !event.Modified.IsZero() {
+			ICalWriteLine(&b, "LAST-MODIFIED:"+event.Modified.UTC().Format(ICalUTCFormat))
+		}
+		ICalWriteLine(&b, "DTSTART;TZID="+loc.String()+":"+event.Start.In(loc).Format(ICalLocalFormat))
+		ICalWriteLine(&b, "DTEND;TZID="+loc.String()+":"+event.End.In(loc).Format(ICalLocalFormat))
+		ICalWriteLine(&b, "SUMMARY:"+ICalEscape(event.Summary))
+		if event.Description != "" {
+			ICalWriteLine(&b, "DESCRIPTION:"+ICalEscape(event.Description))
+		}
+		ICalWriteLine(&b, "END:VEVENT")
+	}
+	ICalWriteLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// Build the events for the times of a plan.
+func ICalPlanEvents(plan Plans, uidSuffix, description string) (events []ICalEvent) {
+	var serviceType ServiceTypes
+	app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+	topic := PlanTopic(serviceType, plan)
+
+	var planTimes []PlanTimes
+	app.db.Where("plan = ?", plan.ID).Order("starts_at ASC").Find(&planTimes)
+	for _, planTime := range planTimes {
+		// Name the event after the time, such as rehearsal.
+		timeName := planTime.Name
+		if timeName == "" {
+			timeName = planTime.TimeType
+			if timeName != "" {
+				timeName = strings.ToUpper(timeName[:1]) + timeName[1:]
+			}
+		}
+		summary := topic
+		if timeName != "" {
+			summary = fmt.Sprintf("%s (%s)", topic, timeName)
+		}
+
+		// Use the plan time ID so updates replace the event.
+		events = append(events, ICalEvent{
+			UID:         fmt.Sprintf("plan-time-%d%s@%s", planTime.ID, uidSuffix, serviceName),
+			Summary:     summary,
+			Description: description,
+			Start:       planTime.StartsAt,
+			End:         planTime.EndsAt,
+			Modified:    planTime.UpdatedAt,
+		})
+	}
+	return
+}
+
+// Get the calendar token for a person, creating one if needed.
+func CalendarToken(personID uint64, reset bool) string {
+	var token CalendarTokens
+	app.db.Where("person = ?", personID).First(&token)
+	if token.ID != 0 && !reset {
+		return token.Token
+	}
+
+	// Generate an unguessable token.
+	b := make([]byte, 24)
+	rand.Read(b)
+	token.Person = personID
+	token.Token = hex.EncodeToString(b)
+	if token.ID == 0 {
+		app.db.Create(&token)
+	} else {
+		app.db.Save(&token)
+	}
+	return token.Token
+}
+
+// Get the URL of the calendar feed for a person.
+func CalendarURL(personID uint64, reset bool) string {
+	return strings.TrimSuffix(app.config.ICal.PublicURL, "/") + "/ical/person/" + CalendarToken(personID, reset) + ".ics"
+}
+
+// Get the time zone calendars are written in.
+func ICalLocation() *time.Location {
+	loc, err := time.LoadLocation(app.config.ICal.TimeZone)
+	if err != nil {
+		log.Println("Invalid calendar time zone:", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// Write a calendar response.
+func (s *HTTPServer) ICalResponse(w http.ResponseWriter, name, calendar string) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	w.Write([]byte(calendar))
+}
+
+// Setup HTTP router with routes for calendar feeds.
+func (s *HTTPServer) RegisterICalRoutes(r *mux.Router) {
+	if !app.config.ICal.Enabled {
+		return
+	}
+
+	// Feed of services a person is scheduled for.
+	r.HandleFunc("/ical/person/{token}.ics", func(w http.ResponseWriter, r *http.Request) {
+		var token CalendarTokens
+		app.db.Where("token = ?", mux.Vars(r)["token"]).First(&token)
+		if token.ID == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		var person People
+		app.db.Where("id = ?", token.Person).First(&person)
+
+		// Group positions by plan.
+		var planPeople []PlanPeople
+		app.db.Where("person = ? AND status != 'D'", token.Person).Find(&planPeople)
+		positions := make(map[uint64][]string)
+		for _, planPerson := range planPeople {
+			positions[planPerson.Plan] = append(positions[planPerson.Plan], planPerson.TeamPositionName)
+		}
+
+		// Build events for each plan.
+		var events []ICalEvent
+		var plans []Plans
+		planIDs := make([]uint64, 0, len(positions))
+		for planID := range positions {
+			planIDs = append(planIDs, planID)
+		}
+		if len(planIDs) != 0 {
+			app.db.Where("id IN ? AND last_time_at >= ?", planIDs, time.Now().UTC().Add(-ICalHistory)).Find(&plans)
+		}
+		for _, plan := range plans {
+			sort.Strings(positions[plan.ID])
+			description := "Positions: " + strings.Join(positions[plan.ID], ", ")
+			events = append(events, ICalPlanEvents(plan, fmt.Sprintf("-person-%d", token.Person), description)...)
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+
+		name := strings.TrimSpace(person.FirstName + " " + person.LastName + " - Serving schedule")
+		s.ICalResponse(w, "schedule.ics", ICalCalendar(name, ICalLocation(), events))
+	}).Methods(http.MethodGet)
+
+	// Feed of all services of a service type.
+	r.HandleFunc("/ical/service_type/{id}.ics", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+		var serviceType ServiceTypes
+		app.db.Where("id = ?", id).First(&serviceType)
+		if serviceType.ID == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		var plans []Plans
+		app.db.Where("service_type = ? AND last_time_at >= ?", serviceType.ID, time.Now().UTC().Add(-ICalHistory)).Order("first_time_at ASC").Find(&plans)
+		var events []ICalEvent
+		for _, plan := range plans {
+			events = append(events, ICalPlanEvents(plan, "", "")...)
+		}
+		s.ICalResponse(w, fmt.Sprintf("service-type-%d.ics", serviceType.ID), ICalCalendar(serviceType.Name, ICalLocation(), events))
+	}).Methods(http.MethodGet)
+}
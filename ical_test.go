@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gorilla/mux"
+)
+
+// Setup calendar routes in a time zone, with a plan on Easter 2030 with a rehearsal and service.
+func icalTestRouter(t *testing.T) *mux.Router {
+	newTestApp(t)
+	app.config.ICal = ICalConfig{Enabled: true, PublicURL: "https://notify.example.com/", TimeZone: "America/Chicago"}
+	createTestPlan(t, 10, 5, 5, 6)
+	startsAt := time.Date(2030, 4, 21, 14, 0, 0, 0, time.UTC)
+	app.db.Model(&Plans{}).Where("id = ?", 10).Updates(map[string]interface{}{"first_time_at": startsAt, "last_time_at": startsAt.Add(time.Hour)})
+	app.db.Model(&PlanTimes{}).Where("id = ?", 10).Updates(map[string]interface{}{"starts_at": startsAt, "ends_at": startsAt.Add(time.Hour)})
+	app.db.Create(&PlanTimes{ID: 11, Org: DefaultOrgName, Plan: 10, TimeType: "rehearsal", Name: "Run through", StartsAt: startsAt.Add(-2 * time.Hour), EndsAt: startsAt.Add(-time.Hour)})
+	app.db.Model(&PlanPeople{}).Where("id = ?", 1000).Update("team_position_name", "Vocals")
+	app.db.Model(&PlanPeople{}).Where("id = ?", 1001).Update("team_position_name", "Acoustic, Guitar")
+	app.db.Model(&PlanPeople{}).Where("id = ?", 1002).Updates(map[string]interface{}{"team_position_name": "Drums", "status": "D"})
+	app.db.Create(&People{ID: 5, Org: DefaultOrgName, FirstName: "Ann", LastName: "Lee"})
+	s := &HTTPServer{config: &app.config.HTTP}
+	r := mux.NewRouter()
+	s.RegisterICalRoutes(r)
+	return r
+}
+
+// Get a calendar feed, returning the response.
+func icalTestGet(r *mux.Router, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+// Unfold the content lines of a calendar.
+func icalTestLines(t *testing.T, calendar string) []string {
+	t.Helper()
+	if !strings.HasSuffix(calendar, "\r\n") {
+		t.Errorf("calendar does not end with CRLF")
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(calendar, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if strings.HasPrefix(line, " ") && len(lines) != 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Check a calendar contains lines in order.
+func icalTestContains(t *testing.T, lines []string, want ...string) {
+	t.Helper()
+	i := 0
+	for _, line := range lines {
+		if i < len(want) && line == want[i] {
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Errorf("calendar is missing %q in:\n%s", want[i], strings.Join(lines, "\n"))
+	}
+}
+
+func TestICalPersonFeed(t *testing.T) {
+	r := icalTestRouter(t)
+	url := CalendarURL(5, false)
+	if !strings.HasPrefix(url, "https://notify.example.com/ical/person/") || CalendarURL(5, false) != url {
+		t.Fatalf("calendar URL %q changed or is not public", url)
+	}
+
+	w := icalTestGet(r, strings.TrimPrefix(url, "https://notify.example.com"))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/calendar; charset=utf-8" {
+		t.Fatalf("responded %d with %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := icalTestLines(t, w.Body.String())
+
+	// Times are in the configured zone, ordered by start, with the positions of the person.
+	icalTestContains(t, lines,
+		"BEGIN:VCALENDAR",
+		"X-WR-CALNAME:Ann Lee - Serving schedule",
+		"BEGIN:VTIMEZONE",
+		"TZID:America/Chicago",
+		"BEGIN:DAYLIGHT",
+		"TZOFFSETFROM:-0600",
+		"TZOFFSETTO:-0500",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"TZOFFSETFROM:-0500",
+		"TZOFFSETTO:-0600",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:plan-time-11-person-5@"+serviceName,
+		"DTSTART;TZID=America/Chicago:20300421T070000",
+		"DTEND;TZID=America/Chicago:20300421T080000",
+		"SUMMARY:Sunday - Easter (Run through)",
+		"DESCRIPTION:Positions: Acoustic\\, Guitar\\, Vocals",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:plan-time-10-person-5@"+serviceName,
+		"DTSTART;TZID=America/Chicago:20300421T090000",
+		"SUMMARY:Sunday - Easter (Service)",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+
+	// Resetting the link stops the old one working.
+	if CalendarURL(5, true) == url || icalTestGet(r, strings.TrimPrefix(url, "https://notify.example.com")).Code != http.StatusNotFound {
+		t.Error("old calendar link still works after resetting")
+	}
+
+	// People who declined are not shown the plan.
+	w = icalTestGet(r, strings.TrimPrefix(CalendarURL(6, false), "https://notify.example.com"))
+	if strings.Contains(w.Body.String(), "BEGIN:VEVENT") {
+		t.Errorf("declined plan in the calendar:\n%s", w.Body)
+	}
+}
+
+func TestICalServiceTypeFeed(t *testing.T) {
+	r := icalTestRouter(t)
+	w := icalTestGet(r, "/ical/service_type/1.ics")
+	lines := icalTestLines(t, w.Body.String())
+	icalTestContains(t, lines,
+		"X-WR-CALNAME:Sunday",
+		"UID:plan-time-11@"+serviceName,
+		"UID:plan-time-10@"+serviceName,
+	)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "DESCRIPTION:") {
+			t.Errorf("service type feed includes %q", line)
+		}
+	}
+	if w := icalTestGet(r, "/ical/service_type/2.ics"); w.Code != http.StatusNotFound {
+		t.Errorf("responded %d for an unknown service type", w.Code)
+	}
+}
+
+func TestICalWriteLine(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		want string
+	}{
+		{"SUMMARY:short", "SUMMARY:short\r\n"},
+		{strings.Repeat("a", 80), strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 5) + "\r\n"},
+		// Multi byte characters are not split across lines.
+		{strings.Repeat("a", 74) + "é", strings.Repeat("a", 74) + "\r\n é\r\n"},
+	} {
+		var b strings.Builder
+		ICalWriteLine(&b, c.line)
+		if b.String() != c.want {
+			t.Errorf("wrote %q, want %q", b.String(), c.want)
+		}
+	}
+	if escaped := ICalEscape("a;b,c\\d\r\ne\nf"); escaped != `a\;b\,c\\d\ne\nf` {
+		t.Errorf("escaped as %q", escaped)
+	}
+	for offset, want := range map[int]string{0: "+0000", -5 * 3600: "-0500", 5*3600 + 30*60: "+0530"} {
+		if got := ICalOffset(offset); got != want {
+			t.Errorf("offset %d formatted %q, want %q", offset, got, want)
+		}
+	}
+}
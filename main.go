@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"github.com/slack-go/slack"
 	"gorm.io/gorm"
 )
 
@@ -18,11 +19,14 @@ const (
 
 // App is the global application structure for communicating between servers and storing information.
 type App struct {
-	flags  *Flags
-	config *Config
-	db     *gorm.DB
-	slack  *slack.Client
-	http   *HTTPServer
+	flags      *Flags
+	config     *Config
+	configFile string
+	db         *gorm.DB
+	orgs       []*Org
+	notifiers  map[string]Notifier
+	sms        SMSProvider
+	http       *HTTPServer
 }
 
 var app *App
@@ -30,18 +34,41 @@ var app *App
 func main() {
 	app = new(App)
 	app.ParseFlags()
-	app.ReadConfig()
-	app.InitDB()
-	app.slack = slack.New(app.config.Slack.APIToken)
-
-	// If update is requested, run updates and end the program.
-	if app.flags.Update {
-		UpdatePCData()
-		UpdateSlackData()
-		CreateSlackChannels()
-		return
+
+	// The update and dry run flags are kept for existing cron jobs.
+	args := flag.Args()
+	if len(args) == 0 && (app.flags.DryRun || app.flags.DryRunJSON) {
+		args = []string{"update", "-dry-run"}
+		if app.flags.DryRunJSON {
+			args = append(args, "-json")
+		}
+	} else if len(args) == 0 && app.flags.Update {
+		args = []string{"update"}
 	}
+	RunCLICommand(args)
+}
+
+// Sync data, create and archive channels and send scheduling requests.
+func RunUpdate() {
+	// Record the run so it is shown on the dashboard.
+	run := SyncRuns{StartedAt: time.Now().UTC()}
+	app.db.Create(&run)
+	for _, org := range SelectedOrgs() {
+		UpdatePCData(org)
+		UpdateSlackData(org)
+		CreateSlackChannels(org)
+		if org.Slack.SchedulingRequests {
+			SendSchedulingRequests(org)
+		}
+	}
+	run.FinishedAt = time.Now().UTC()
+	app.db.Save(&run)
+	// Wait for webhooks about the update to be delivered.
+	WaitForWebhooks()
+}
 
+// Run the HTTP server and background jobs until a signal is received.
+func RunServe() {
 	// Configure the HTTP server.
 	app.http = NewHTTPServer()
 
@@ -49,6 +76,19 @@ func main() {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	app.http.Start(ctx)
 
+	// Start background jobs.
+	StartScheduler(ctx, ScheduledJobs())
+	ResumeWebhookDeliveries()
+	if app.config.OSC.Port != 0 {
+		StartOSCServer(ctx)
+	}
+	if app.config.MIDI.Port != 0 {
+		NewMIDIServer(&app.config.MIDI).Start(ctx)
+	}
+	if app.config.ProPresenter.URL != "" {
+		NewProPresenterClient(&app.config.ProPresenter).Start(ctx)
+	}
+
 	// Monitor common signals.
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
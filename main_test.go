@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+	"gorm.io/gorm/logger"
+)
+
+// A message posted to a recording notifier.
+type recordedPost struct {
+	Conversation string
+	Message      string
+}
+
+// A notifier which records the messages posted to it.
+type recordingNotifier struct {
+	mu      sync.Mutex
+	posts   []recordedPost
+	invites []string          // Users invited.
+	users   map[uint64]string // Users of people.
+	updates []recordedPost    // Messages updated.
+}
+
+func (n *recordingNotifier) CreateConversation(name string) (string, error) { return name, nil }
+func (n *recordingNotifier) SetTopic(conversationID, topic string) error    { return nil }
+func (n *recordingNotifier) Remove(conversationID, userID string) error     { return nil }
+func (n *recordingNotifier) Archive(conversationID string) error            { return nil }
+
+func (n *recordingNotifier) Invite(conversationID string, userIDs ...string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.invites = append(n.invites, userIDs...)
+	return nil
+}
+
+func (n *recordingNotifier) UserForPerson(personID uint64) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.users[personID]
+}
+
+// Take the users invited since last taken.
+func (n *recordingNotifier) TakeInvites() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	invites := n.invites
+	n.invites = nil
+	return invites
+}
+
+func (n *recordingNotifier) PostMessage(conversationID, message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.posts = append(n.posts, recordedPost{conversationID, message})
+	return nil
+}
+
+// Record a direct message, sent to the conversation D followed by the user ID.
+func (n *recordingNotifier) PostDirectMessage(userID, text string, blocks ...slack.Block) (string, string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.posts = append(n.posts, recordedPost{"D" + userID, text})
+	return "D" + userID, fmt.Sprintf("%d.000100", len(n.posts)), nil
+}
+
+func (n *recordingNotifier) UpdateMessage(conversationID, ts, text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.updates = append(n.updates, recordedPost{conversationID, text})
+	return nil
+}
+
+// Take the messages updated since last taken.
+func (n *recordingNotifier) TakeUpdates() []recordedPost {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	updates := n.updates
+	n.updates = nil
+	return updates
+}
+
+// Wait until at least n messages are posted, returning those posted.
+func (n *recordingNotifier) WaitForPosts(t *testing.T, count int) []recordedPost {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n.mu.Lock()
+		posts := append([]recordedPost(nil), n.posts...)
+		n.mu.Unlock()
+		if len(posts) >= count || time.Now().After(deadline) {
+			return posts
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Setup the app with a migrated temporary database and one organization,
+// which posts to a recording notifier with C_ADMIN as its default conversation.
+func newTestApp(t *testing.T) *recordingNotifier {
+	return newTestAppWithDB(t, DBConfig{
+		Type:       "sqlite3",
+		Connection: filepath.Join(t.TempDir(), "test.db"),
+	}, 0)
+}
+
+// Setup the app like newTestApp with a database, applying a number of migrations or all if 0.
+func newTestAppWithDB(t *testing.T, db DBConfig, steps int) *recordingNotifier {
+	config := &Config{DB: db}
+	config.Slack.DefaultConversation = "C_ADMIN"
+	app = &App{
+		flags:     &Flags{},
+		config:    config,
+		notifiers: make(map[string]Notifier),
+	}
+
+	notifier := &recordingNotifier{}
+	app.orgs = []*Org{{
+		Name:           DefaultOrgName,
+		PlanningCenter: &config.PlanningCenter,
+		Slack:          &config.Slack,
+		Reminders:      &config.Reminders,
+		Email:          &config.Email,
+		notifier:       notifier,
+	}}
+
+	app.OpenDB()
+	app.db.Logger = logger.Discard
+	err := MigrateUp(steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, err := app.db.DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+	return notifier
+}
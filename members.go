@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Reasons a user is a member of a channel.
+const (
+	ChannelMemberSticky   = "sticky"   // Listed in the sticky users of the notifier.
+	ChannelMemberPosition = "position" // Assigned to a position on the plan.
+	ChannelMemberManual   = "manual"   // Added to the channel by someone else.
+)
+
+// Number of failed invites before a user is no longer invited.
+const MaxInviteAttempts = 5
+
+// Invite errors which mean the user is already in the channel.
+var AlreadyInChannelErrors = []string{"already_in_channel"}
+
+// Invite errors which will not succeed if tried again.
+var PermanentInviteErrors = []string{
+	"cant_invite",
+	"cant_invite_self",
+	"user_not_found",
+	"user_is_restricted",
+	"user_is_ultra_restricted",
+	"ura_max_channels",
+	"user_disabled",
+}
+
+// Check if an invite error matches one of a list of error codes.
+func InviteErrorIn(err error, codes []string) bool {
+	if err == nil {
+		return false
+	}
+	for _, code := range codes {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a member is currently in the channel.
+func (m ChannelMembers) Active() bool {
+	return m.InvitedAt.Unix() > 0 && m.RemovedAt.Unix() <= 0
+}
+
+// Get the members of a channel, including those removed or who failed to be invited.
+func ChannelMembersOf(channelID string) (members []ChannelMembers) {
+	app.db.Where("channel_id = ?", channelID).Order("id ASC").Find(&members)
+	return
+}
+
+// Get the users who are currently members of a channel.
+func ChannelActiveUsers(channelID string) (users []string) {
+	for _, member := range ChannelMembersOf(channelID) {
+		if member.Active() {
+			users = append(users, member.UserID)
+		}
+	}
+	return
+}
+
+// Record the result of inviting a user to a channel. The invite failed if inviteErr is not nil.
+func RecordChannelMember(channelID, userID, reason string, inviteErr error) ChannelMembers {
+	var member ChannelMembers
+	app.db.Where("channel_id = ? AND user_id = ?", channelID, userID).First(&member)
+	member.ChannelID = channelID
+	member.UserID = userID
+	member.Reason = reason
+	if inviteErr != nil {
+		// Stop trying if the error is permanent or it keeps failing.
+		member.LastError = inviteErr.Error()
+		member.InviteAttempts++
+		member.PermanentFailure = InviteErrorIn(inviteErr, PermanentInviteErrors) || member.InviteAttempts >= MaxInviteAttempts
+	} else {
+		member.InvitedAt = time.Now().UTC()
+		member.RemovedAt = time.Time{}
+		member.LastError = ""
+		member.InviteAttempts = 0
+		member.PermanentFailure = false
+	}
+	if member.ID == 0 {
+		app.db.Create(&member)
+	} else {
+		app.db.Save(&member)
+	}
+	return member
+}
+
+// Invite users to a channel, recording the result for each user.
+// Returns the users invited and members who can not be invited.
+func InviteChannelMembers(notifier Notifier, channelID string, userIDs []string, reasons map[string]string) (invited []string, failed []ChannelMembers) {
+	// Invite everyone at once, which fails for everyone if any user can not be invited.
+	err := notifier.Invite(channelID, userIDs...)
+	errs := make(map[string]error)
+	if err != nil && len(userIDs) > 1 {
+		log.Println("Failed to invite users to channel, inviting each user:", err)
+		for _, uid := range userIDs {
+			errs[uid] = notifier.Invite(channelID, uid)
+		}
+	} else if err != nil {
+		errs[userIDs[0]] = err
+	}
+
+	// Record the result for each user.
+	for _, uid := range userIDs {
+		err := errs[uid]
+		if InviteErrorIn(err, AlreadyInChannelErrors) {
+			err = nil
+		}
+		if err != nil {
+			log.Println("Failed to invite user to channel:", uid, err)
+		}
+		member := RecordChannelMember(channelID, uid, reasons[uid], err)
+		if err == nil {
+			invited = append(invited, uid)
+		} else if member.PermanentFailure {
+			failed = append(failed, member)
+		}
+	}
+	return
+}
+
+// Let the admin of an organization know about people who could not be added to a channel.
+func NotifyInviteFailures(org *Org, actor, notifierName string, channel SlackChannels, failed []ChannelMembers) {
+	conversation := NotifierDefaultConversation(org, notifierName)
+	if conversation == "" || len(failed) == 0 {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Unable to add people to #%s, they will not be invited again:", channel.Name)
+	for _, member := range failed {
+		name := member.UserID
+		var slackUser SlackUsers
+		app.db.Where("id = ?", member.UserID).First(&slackUser)
+		if slackUser.RealName != "" {
+			name = slackUser.RealName
+		}
+		fmt.Fprintf(&b, "\n• %s - %s", name, member.LastError)
+	}
+	err := NotifierAs(org, notifierName, actor).PostMessage(conversation, b.String())
+	if err != nil {
+		log.Println("Error notifying admin of invite failures:", err)
+	}
+}
+
+// Allow a user who could not be invited to be invited again, such as when their account changes.
+func ResetChannelMemberFailures(userID string) {
+	app.db.Model(&ChannelMembers{}).Where("user_id = ? AND permanent_failure = ?", userID, true).Updates(map[string]interface{}{
+		"invite_attempts":   0,
+		"permanent_failure": false,
+	})
+}
+
+// Record that a user left or was removed from a channel.
+func RemoveChannelMember(channelID, userID string) {
+	var member ChannelMembers
+	app.db.Where("channel_id = ? AND user_id = ?", channelID, userID).First(&member)
+	if member.ID == 0 || !member.Active() {
+		return
+	}
+	member.RemovedAt = time.Now().UTC()
+	app.db.Save(&member)
+}
+
+// Record that a user joined a channel. Users who were members before keep the reason they were invited,
+// otherwise they were added by someone else.
+func JoinChannelMember(channelID, userID string) {
+	var member ChannelMembers
+	app.db.Where("channel_id = ? AND user_id = ?", channelID, userID).First(&member)
+	if member.Active() {
+		return
+	}
+	reason := member.Reason
+	if reason == "" {
+		reason = ChannelMemberManual
+	}
+	RecordChannelMember(channelID, userID, reason, nil)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Store a plan of the default organization with a service time and people on positions.
+func createTestPlan(t *testing.T, planID uint64, people ...uint64) PlanTimes {
+	app.db.FirstOrCreate(&ServiceTypes{ID: 1, Org: DefaultOrgName, Name: "Sunday"})
+	app.db.Create(&Plans{ID: planID, Org: DefaultOrgName, ServiceType: 1, Title: "Easter"})
+	planTime := PlanTimes{
+		ID:       planID,
+		Org:      DefaultOrgName,
+		Plan:     planID,
+		TimeType: "service",
+		StartsAt: time.Now().Add(48 * time.Hour).UTC(),
+		EndsAt:   time.Now().Add(50 * time.Hour).UTC(),
+	}
+	err := app.db.Create(&planTime).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, person := range people {
+		app.db.Create(&PlanPeople{ID: planID*100 + uint64(i), Org: DefaultOrgName, Plan: planID, Person: person, Status: "C"})
+	}
+	return planTime
+}
+
+func TestReconcileMemberLeft(t *testing.T) {
+	notifier := newTestApp(t)
+	notifier.users = map[uint64]string{5: "U5", 6: "U6"}
+	app.config.Slack.StickyUsers = []string{"U_ADMIN"}
+	planTime := createTestPlan(t, 10, 5, 6)
+
+	reconcile := func(want ...string) {
+		t.Helper()
+		err := ReconcilePlanChannel(ActorCron, planTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		invites := notifier.TakeInvites()
+		if !reflect.DeepEqual(invites, want) {
+			t.Errorf("invited %v, want %v", invites, want)
+		}
+	}
+	reconcile("U_ADMIN", "U5", "U6")
+	channel := PlanChannel(10)
+
+	// People who leave are not invited back.
+	RemoveChannelMember(channel.ID, "U5")
+	RemoveChannelMember(channel.ID, "U_ADMIN")
+	reconcile()
+	reconcile()
+
+	// Unless they are invited for another reason.
+	app.config.Slack.StickyUsers = []string{"U_ADMIN", "U5"}
+	reconcile("U5")
+	reconcile()
+
+	// People who join again are members again, and are not invited back after leaving again.
+	JoinChannelMember(channel.ID, "U_ADMIN")
+	RemoveChannelMember(channel.ID, "U_ADMIN")
+	reconcile()
+	if users := ChannelActiveUsers(channel.ID); !reflect.DeepEqual(users, []string{"U5", "U6"}) {
+		t.Errorf("channel members %v, want U5 and U6", users)
+	}
+
+	// People added by someone else are recorded as added manually.
+	JoinChannelMember(channel.ID, "U7")
+	var member ChannelMembers
+	app.db.Where("channel_id = ? AND user_id = ?", channel.ID, "U7").First(&member)
+	if !member.Active() || member.Reason != ChannelMemberManual {
+		t.Errorf("joined member %+v, want an active manual member", member)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// AppleMIDI session commands.
+const (
+	AppleMIDIInvitation = "IN"
+	AppleMIDIAccept     = "OK"
+	AppleMIDIReject     = "NO"
+	AppleMIDIEnd        = "BY"
+	AppleMIDISync       = "CK"
+	AppleMIDIFeedback   = "RS"
+)
+
+// MIDI event types which can be mapped.
+const (
+	MIDINoteOff       = "note_off"
+	MIDINoteOn        = "note_on"
+	MIDIControlChange = "control_change"
+	MIDIProgramChange = "program_change"
+)
+
+// A channel MIDI event.
+type MIDIEvent struct {
+	Type    string
+	Channel int // 1 through 16.
+	Number  int // Note, controller or program number.
+	Value   int // Velocity or controller value.
+}
+
+// Data available to MIDI message templates.
+type MIDIData struct {
+	MIDIEvent
+	Session string // Name of the peer which sent the event.
+}
+
+// An RTP-MIDI server accepting AppleMIDI sessions.
+type MIDIServer struct {
+	config  *MIDIConfig
+	ssrc    uint32
+	start   time.Time
+	control net.PacketConn
+	data    net.PacketConn
+
+	sync.Mutex
+	sessions map[uint32]string // Peer names by SSRC.
+}
+
+// Create an RTP-MIDI server from the configuration.
+func NewMIDIServer(config *MIDIConfig) *MIDIServer {
+	return &MIDIServer{
+		config:   config,
+		ssrc:     rand.Uint32(),
+		start:    time.Now(),
+		sessions: make(map[uint32]string),
+	}
+}
+
+// The current time in the 100 microsecond units used by AppleMIDI clock sync.
+func (s *MIDIServer) Timestamp() uint64 {
+	return uint64(time.Since(s.start) / (time.Microsecond * 100))
+}
+
+// Read a MIDI variable length quantity, used for delta times.
+func midiReadVarLen(data []byte) (int, []byte, error) {
+	value := 0
+	for i := 0; i < 4; i++ {
+		if len(data) == 0 {
+			return 0, nil, fmt.Errorf("delta time exceeds packet")
+		}
+		c := data[0]
+		data = data[1:]
+		value = value<<7 | int(c&0x7F)
+		if c&0x80 == 0 {
+			return value, data, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("delta time too long")
+}
+
+// Number of data bytes following a channel status byte.
+func midiDataLength(status byte) int {
+	switch status & 0xF0 {
+	case 0xC0, 0xD0:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Parse the MIDI command section of an RTP-MIDI packet into channel events.
+func ParseRTPMIDI(packet []byte) ([]MIDIEvent, error) {
+	// Check the RTP header.
+	if len(packet) < 13 || packet[0]>>6 != 2 {
+		return nil, fmt.Errorf("invalid RTP packet")
+	}
+	data := packet[12:]
+
+	// Read the MIDI command section header.
+	flags := data[0]
+	length := int(flags & 0x0F)
+	data = data[1:]
+	if flags&0x80 != 0 {
+		if len(data) < 1 {
+			return nil, fmt.Errorf("invalid RTP-MIDI header")
+		}
+		length = length<<8 | int(data[0])
+		data = data[1:]
+	}
+	if length > len(data) {
+		return nil, fmt.Errorf("MIDI list exceeds packet")
+	}
+	data = data[:length]
+
+	// Read each command in the MIDI list, the first only has a delta time if the Z flag is set.
+	var events []MIDIEvent
+	var status byte
+	var err error
+	first := true
+	for len(data) != 0 {
+		if !first || flags&0x20 != 0 {
+			_, data, err = midiReadVarLen(data)
+			if err != nil {
+				return events, err
+			}
+			if len(data) == 0 {
+				break
+			}
+		}
+		first = false
+
+		// Read the status byte, or continue the running status.
+		if data[0]&0x80 != 0 {
+			status = data[0]
+			data = data[1:]
+		} else if status == 0 {
+			return events, fmt.Errorf("missing running status")
+		}
+
+		// System exclusive messages continue to an end byte.
+		if status == 0xF0 || status == 0xF7 {
+			end := bytes.IndexAny(data, "\xF7\xF0\xF4")
+			if end == -1 {
+				break
+			}
+			data = data[end+1:]
+			status = 0
+			continue
+		}
+
+		// Skip other system messages, which cancel running status.
+		if status >= 0xF0 {
+			n := 0
+			switch status {
+			case 0xF1, 0xF3:
+				n = 1
+			case 0xF2:
+				n = 2
+			}
+			if len(data) < n {
+				return events, fmt.Errorf("MIDI command exceeds packet")
+			}
+			data = data[n:]
+			if status < 0xF8 {
+				status = 0
+			}
+			continue
+		}
+
+		// Read the channel message.
+		n := midiDataLength(status)
+		if len(data) < n {
+			return events, fmt.Errorf("MIDI command exceeds packet")
+		}
+		event := MIDIEvent{
+			Channel: int(status&0x0F) + 1,
+			Number:  int(data[0]),
+		}
+		if n == 2 {
+			event.Value = int(data[1])
+		}
+		data = data[n:]
+		switch status & 0xF0 {
+		case 0x80:
+			event.Type = MIDINoteOff
+		case 0x90:
+			// Note on with no velocity is a note off.
+			event.Type = MIDINoteOn
+			if event.Value == 0 {
+				event.Type = MIDINoteOff
+			}
+		case 0xB0:
+			event.Type = MIDIControlChange
+		case 0xC0:
+			event.Type = MIDIProgramChange
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Check if a mapping matches a MIDI event.
+func (c MIDIMappingConfig) Matches(event MIDIEvent) bool {
+	if c.Type != event.Type {
+		return false
+	}
+	if c.Channel != 0 && c.Channel != event.Channel {
+		return false
+	}
+	if c.Number != nil && *c.Number != event.Number {
+		return false
+	}
+	return event.Value >= c.MinValue
+}
+
+// Build an AppleMIDI session command.
+func (s *MIDIServer) SessionCommand(command string, token uint32) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xFF})
+	b.WriteString(command)
+	binary.Write(&b, binary.BigEndian, uint32(2))
+	binary.Write(&b, binary.BigEndian, token)
+	binary.Write(&b, binary.BigEndian, s.ssrc)
+	b.WriteString(s.config.Name)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// Handle an AppleMIDI command received on either port.
+func (s *MIDIServer) HandleCommand(conn net.PacketConn, addr net.Addr, packet []byte) {
+	if len(packet) < 8 {
+		return
+	}
+	command := string(packet[2:4])
+	switch command {
+	case AppleMIDIInvitation:
+		// Accept all invitations.
+		if len(packet) < 16 {
+			return
+		}
+		token := binary.BigEndian.Uint32(packet[8:12])
+		ssrc := binary.BigEndian.Uint32(packet[12:16])
+		name := string(bytes.TrimRight(packet[16:], "\x00"))
+		s.Lock()
+		if _, ok := s.sessions[ssrc]; !ok {
+			log.Println("MIDI session started:", name, addr)
+		}
+		s.sessions[ssrc] = name
+		s.Unlock()
+		conn.WriteTo(s.SessionCommand(AppleMIDIAccept, token), addr)
+	case AppleMIDIEnd:
+		if len(packet) < 16 {
+			return
+		}
+		ssrc := binary.BigEndian.Uint32(packet[12:16])
+		s.Lock()
+		if name, ok := s.sessions[ssrc]; ok {
+			log.Println("MIDI session ended:", name, addr)
+			delete(s.sessions, ssrc)
+		}
+		s.Unlock()
+	case AppleMIDISync:
+		// Respond to clock sync with our timestamp in the next slot.
+		if len(packet) < 36 {
+			return
+		}
+		count := packet[8]
+		if count > 1 {
+			return
+		}
+		reply := make([]byte, 36)
+		copy(reply, packet)
+		binary.BigEndian.PutUint32(reply[4:8], s.ssrc)
+		reply[8] = count + 1
+		binary.BigEndian.PutUint64(reply[12+8*(count+1):], s.Timestamp())
+		conn.WriteTo(reply, addr)
+	}
+}
+
+// Handle an RTP-MIDI packet by firing mappings that match its events.
+func (s *MIDIServer) HandleRTP(packet []byte) {
+	s.Lock()
+	session := s.sessions[binary.BigEndian.Uint32(packet[8:12])]
+	s.Unlock()
+
+	events, err := ParseRTPMIDI(packet)
+	if err != nil {
+		log.Println("Error parsing RTP-MIDI packet:", err)
+	}
+	for _, event := range events {
+		for _, mapping := range s.config.Mappings {
+			if mapping.Matches(event) {
+				FireTrigger(OrgOrDefault(s.config.Organization), ActorMIDI, mapping.Message, mapping.Priority, mapping.Positions, MIDIData{
+					MIDIEvent: event,
+					Session:   session,
+				})
+			}
+		}
+	}
+}
+
+// Read packets from a port until it is closed.
+func (s *MIDIServer) Serve(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packet := buf[:n]
+		if n >= 4 && packet[0] == 0xFF && packet[1] == 0xFF {
+			s.HandleCommand(conn, addr, packet)
+		} else if n >= 12 && conn == s.data {
+			s.HandleRTP(packet)
+		}
+	}
+}
+
+// Start listening on the control port and the data port which follows it.
+func (s *MIDIServer) Start(ctx context.Context) {
+	var err error
+	controlAddr := net.JoinHostPort(s.config.BindAddr, fmt.Sprint(s.config.Port))
+	dataAddr := net.JoinHostPort(s.config.BindAddr, fmt.Sprint(s.config.Port+1))
+	log.Println("Starting RTP-MIDI server:", controlAddr)
+	s.control, err = net.ListenPacket("udp", controlAddr)
+	if err != nil {
+		log.Fatal("RTP-MIDI Listen: ", err)
+	}
+	s.data, err = net.ListenPacket("udp", dataAddr)
+	if err != nil {
+		log.Fatal("RTP-MIDI Listen: ", err)
+	}
+	go s.Serve(s.control)
+	go s.Serve(s.data)
+
+	// Close the ports when the context is done.
+	go func() {
+		<-ctx.Done()
+		s.control.Close()
+		s.data.Close()
+	}()
+}
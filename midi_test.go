@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Build an RTP-MIDI packet from a peer with a MIDI list.
+func rtpMIDIPacket(ssrc uint32, seq uint16, list ...byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0x80, 0x61})
+	binary.Write(&b, binary.BigEndian, seq)
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, ssrc)
+	b.WriteByte(byte(len(list)))
+	b.Write(list)
+	return b.Bytes()
+}
+
+func TestParseRTPMIDI(t *testing.T) {
+	// Note on, then a running status note on with zero velocity, then a control change after a delta time.
+	events, err := ParseRTPMIDI(rtpMIDIPacket(1, 1, 0x90, 60, 100, 0x00, 60, 0, 0x00, 0xB3, 7, 90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MIDIEvent{
+		{Type: MIDINoteOn, Channel: 1, Number: 60, Value: 100},
+		{Type: MIDINoteOff, Channel: 1, Number: 60},
+		{Type: MIDIControlChange, Channel: 4, Number: 7, Value: 90},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("parsed %+v, want %+v", events, want)
+	}
+
+	// System common messages are skipped.
+	events, err = ParseRTPMIDI(rtpMIDIPacket(1, 1, 0xF2, 1, 2, 0x00, 0xC0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []MIDIEvent{{Type: MIDIProgramChange, Channel: 1, Number: 5}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("parsed %+v, want %+v", events, want)
+	}
+}
+
+func TestParseRTPMIDITruncated(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		list []byte
+	}{
+		{"song position", []byte{0xF2}},
+		{"song position one byte", []byte{0xF2, 1}},
+		{"time code", []byte{0xF1}},
+		{"song select", []byte{0xF3}},
+		{"after event", []byte{0x90, 60, 100, 0x00, 0xF3}},
+		{"note on", []byte{0x90, 60}},
+		{"program change", []byte{0xC0}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ParseRTPMIDI(rtpMIDIPacket(1, 1, c.list...))
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// A simulated AppleMIDI session peer.
+type midiPeer struct {
+	t       *testing.T
+	ssrc    uint32
+	conn    net.PacketConn
+	control net.Addr
+	data    net.Addr
+}
+
+// Send a packet to an address and wait for the reply.
+func (p *midiPeer) exchange(addr net.Addr, packet []byte) []byte {
+	_, err := p.conn.WriteTo(packet, addr)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, _, err := p.conn.ReadFrom(buf)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+// Send an invitation to an address, checking it is accepted.
+func (p *midiPeer) invite(addr net.Addr, token uint32) {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xFF})
+	b.WriteString(AppleMIDIInvitation)
+	binary.Write(&b, binary.BigEndian, uint32(2))
+	binary.Write(&b, binary.BigEndian, token)
+	binary.Write(&b, binary.BigEndian, p.ssrc)
+	b.WriteString("Lighting Desk\x00")
+	reply := p.exchange(addr, b.Bytes())
+	if len(reply) < 16 || string(reply[2:4]) != AppleMIDIAccept || binary.BigEndian.Uint32(reply[8:12]) != token {
+		p.t.Fatalf("invitation reply %q, want accept with token %d", reply, token)
+	}
+}
+
+// Start clock sync, checking the server replies with its timestamp.
+func (p *midiPeer) sync() {
+	packet := make([]byte, 36)
+	copy(packet, []byte{0xFF, 0xFF})
+	copy(packet[2:], AppleMIDISync)
+	binary.BigEndian.PutUint32(packet[4:8], p.ssrc)
+	binary.BigEndian.PutUint64(packet[12:20], 1234)
+	reply := p.exchange(p.data, packet)
+	if len(reply) != 36 || string(reply[2:4]) != AppleMIDISync || reply[8] != 1 {
+		p.t.Fatalf("sync reply %x, want count 1", reply)
+	}
+	if binary.BigEndian.Uint64(reply[12:20]) != 1234 {
+		p.t.Errorf("sync reply changed the peer timestamp")
+	}
+}
+
+func TestMIDISessionPeer(t *testing.T) {
+	notifier := newTestApp(t)
+	note := 60
+	volume := 7
+	config := &MIDIConfig{
+		Name: "Service Notifications",
+		Mappings: []MIDIMappingConfig{
+			{Type: MIDINoteOn, Channel: 1, Number: &note, Message: "Note {{.Number}} from {{.Session}}"},
+			{Type: MIDIControlChange, Number: &volume, MinValue: 64, Message: "Volume {{.Value}} on {{.Channel}}"},
+			{Type: MIDIProgramChange, Message: "Program {{.Number}}"},
+		},
+	}
+
+	// Listen on local ports, as the server would on the control and data ports.
+	s := NewMIDIServer(config)
+	var err error
+	s.control, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.control.Close()
+	s.data, err = net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.data.Close()
+	go s.Serve(s.control)
+	go s.Serve(s.data)
+
+	// Join the session on both ports and sync clocks.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer := &midiPeer{t: t, ssrc: 0x1234, conn: conn, control: s.control.LocalAddr(), data: s.data.LocalAddr()}
+	peer.invite(peer.control, 1)
+	peer.invite(peer.data, 1)
+	peer.sync()
+
+	// Send events, only some of which match mappings.
+	for i, list := range [][]byte{
+		{0x90, 60, 100}, // Note on matching the mapping.
+		{0x91, 60, 100}, // Note on another channel.
+		{0xB2, 7, 10},   // Volume below the minimum.
+		{0xB2, 7, 100},  // Volume above the minimum.
+		{0xC0, 3},       // Program change.
+		{0xF2, 1},       // Truncated, ignored.
+	} {
+		_, err = conn.WriteTo(rtpMIDIPacket(peer.ssrc, uint16(i), list...), peer.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []recordedPost{
+		{"C_ADMIN", "Note 60 from Lighting Desk"},
+		{"C_ADMIN", "Volume 100 on 3"},
+		{"C_ADMIN", "Program 3"},
+	}
+	posts := notifier.WaitForPosts(t, len(want))
+	if !reflect.DeepEqual(posts, want) {
+		t.Errorf("posted %+v, want %+v", posts, want)
+	}
+
+	// The messages are recorded as sent by MIDI.
+	var count int64
+	app.db.Model(&AuditEvents{}).Where("actor = ?", ActorMIDI).Count(&count)
+	if count != int64(len(want)) {
+		t.Errorf("%d audit events, want %d", count, len(want))
+	}
+}
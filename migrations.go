@@ -0,0 +1,645 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations which have been applied to the database.
+type SchemaMigrations struct {
+	Version   uint64    `gorm:"primary_key;autoIncrement:false" json:"version"`
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+// A function which changes the schema, given the database dialect.
+type MigrationFunc func(tx *gorm.DB, dialect string) error
+
+// A versioned change to the database schema.
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      MigrationFunc
+	Down    MigrationFunc
+}
+
+// Run SQL statements for the database dialect, falling back to the statements listed under an empty dialect.
+func MigrationSQL(statements map[string][]string) MigrationFunc {
+	return func(tx *gorm.DB, dialect string) error {
+		sqls, ok := statements[dialect]
+		if !ok {
+			sqls = statements[""]
+		}
+		for _, sql := range sqls {
+			err := tx.Exec(sql).Error
+			if err != nil {
+				return fmt.Errorf("%s: %s", sql, err)
+			}
+		}
+		return nil
+	}
+}
+
+// Tables created by the first migration, in order of creation.
+var migrationInitialModels = []interface{}{
+	&migration1ServiceTypes{},
+	&migration1Plans{},
+	&migration1PlanTimes{},
+	&migration1PlanPeople{},
+	&migration1NeededPositions{},
+	&migration1People{},
+	&migration1SlackUsers{},
+	&migration1SlackChannels{},
+	&migration1ReminderLogs{},
+	&migration1SentNotifications{},
+	&migration1SMSSubscriptions{},
+	&migration1WebhookDeliveries{},
+	&migration1SyncRuns{},
+	&migration1CalendarTokens{},
+}
+
+// Tables with data partitioned by organization.
+var migrationOrgTables = []string{
+	"service_types",
+	"plans",
+	"plan_times",
+	"plan_peoples",
+	"needed_positions",
+	"peoples",
+	"slack_users",
+	"slack_channels",
+	"audit_events",
+}
+
+// All migrations, in order of version. Applied migrations must not be changed, add a new migration instead.
+var Migrations = []Migration{
+	{
+		// Databases created before versioned migrations already have these tables, which are updated to match.
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB, dialect string) error {
+			err := tx.AutoMigrate(migrationInitialModels...)
+			if err != nil {
+				return err
+			}
+			// Unique indexes are created here, as gorm also marks columns with unique index tags unique
+			// once parsed, which would add constraints to tables created again by the same process.
+			for _, column := range []string{"person", "token"} {
+				name := "idx_calendar_tokens_" + column
+				if tx.Migrator().HasIndex(&migration1CalendarTokens{}, name) {
+					continue
+				}
+				err = tx.Exec("CREATE UNIQUE INDEX " + name + " ON calendar_tokens (" + column + ")").Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB, dialect string) error {
+			for i := len(migrationInitialModels) - 1; i >= 0; i-- {
+				err := tx.Migrator().DropTable(migrationInitialModels[i])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_lookup_indexes",
+		Up: MigrationSQL(map[string][]string{
+			"": {
+				"CREATE INDEX idx_plan_times_starts_at ON plan_times (starts_at)",
+				"CREATE INDEX idx_plan_times_plan ON plan_times (plan)",
+				"CREATE INDEX idx_plan_peoples_plan ON plan_peoples (plan)",
+				"CREATE INDEX idx_plan_peoples_person ON plan_peoples (person)",
+				"CREATE INDEX idx_needed_positions_plan ON needed_positions (plan)",
+				"CREATE INDEX idx_slack_users_pc_id ON slack_users (pc_id)",
+				"CREATE INDEX idx_slack_channels_pc_plan ON slack_channels (pc_plan)",
+			},
+		}),
+		Down: MigrationSQL(map[string][]string{
+			// MySQL requires the table when dropping an index.
+			"mysql": {
+				"DROP INDEX idx_plan_times_starts_at ON plan_times",
+				"DROP INDEX idx_plan_times_plan ON plan_times",
+				"DROP INDEX idx_plan_peoples_plan ON plan_peoples",
+				"DROP INDEX idx_plan_peoples_person ON plan_peoples",
+				"DROP INDEX idx_needed_positions_plan ON needed_positions",
+				"DROP INDEX idx_slack_users_pc_id ON slack_users",
+				"DROP INDEX idx_slack_channels_pc_plan ON slack_channels",
+			},
+			"": {
+				"DROP INDEX idx_plan_times_starts_at",
+				"DROP INDEX idx_plan_times_plan",
+				"DROP INDEX idx_plan_peoples_plan",
+				"DROP INDEX idx_plan_peoples_person",
+				"DROP INDEX idx_needed_positions_plan",
+				"DROP INDEX idx_slack_users_pc_id",
+				"DROP INDEX idx_slack_channels_pc_plan",
+			},
+		}),
+	},
+	{
+		// Rows of a plan are removed with it. Rows left from plans removed before now are deleted first.
+		// SQLite cannot add constraints to existing tables, so its tables are rebuilt with them by migration 9.
+		Version: 3,
+		Name:    "add_plan_foreign_keys",
+		Up: MigrationSQL(map[string][]string{
+			"sqlite3": {},
+			"": {
+				"DELETE FROM plan_times WHERE plan NOT IN (SELECT id FROM plans)",
+				"DELETE FROM plan_peoples WHERE plan NOT IN (SELECT id FROM plans)",
+				"DELETE FROM needed_positions WHERE plan NOT IN (SELECT id FROM plans)",
+				"ALTER TABLE plan_times ADD CONSTRAINT fk_plan_times_plan FOREIGN KEY (plan) REFERENCES plans (id) ON DELETE CASCADE",
+				"ALTER TABLE plan_peoples ADD CONSTRAINT fk_plan_peoples_plan FOREIGN KEY (plan) REFERENCES plans (id) ON DELETE CASCADE",
+				"ALTER TABLE needed_positions ADD CONSTRAINT fk_needed_positions_plan FOREIGN KEY (plan) REFERENCES plans (id) ON DELETE CASCADE",
+			},
+		}),
+		Down: MigrationSQL(map[string][]string{
+			"sqlite3": {},
+			"mysql": {
+				"ALTER TABLE plan_times DROP FOREIGN KEY fk_plan_times_plan",
+				"ALTER TABLE plan_peoples DROP FOREIGN KEY fk_plan_peoples_plan",
+				"ALTER TABLE needed_positions DROP FOREIGN KEY fk_needed_positions_plan",
+			},
+			"postgres": {
+				"ALTER TABLE plan_times DROP CONSTRAINT fk_plan_times_plan",
+				"ALTER TABLE plan_peoples DROP CONSTRAINT fk_plan_peoples_plan",
+				"ALTER TABLE needed_positions DROP CONSTRAINT fk_needed_positions_plan",
+			},
+		}),
+	},
+	{
+		// Invited users were previously stored comma separated on the channel.
+		Version: 4,
+		Name:    "create_channel_members",
+		Up: func(tx *gorm.DB, dialect string) error {
+			err := tx.AutoMigrate(&migration4ChannelMembers{})
+			if err != nil {
+				return err
+			}
+			if !tx.Migrator().HasColumn("slack_channels", "users_invited") {
+				return nil
+			}
+
+			// Convert the invited users of each channel.
+			var channels []struct {
+				ID           string
+				Notifier     string
+				PCPlan       uint64
+				UsersInvited string
+				CreatedAt    time.Time
+			}
+			err = tx.Raw("SELECT id, notifier, pc_plan, users_invited, created_at FROM slack_channels").Scan(&channels).Error
+			if err != nil {
+				return err
+			}
+			now := time.Now().UTC()
+			for _, channel := range channels {
+				// When the users were invited is unknown, so use when the channel was created.
+				if channel.CreatedAt.Unix() <= 0 {
+					channel.CreatedAt = now
+				}
+
+				// Slack users matched to people on the plan were invited for their position, and others were sticky.
+				// Users of other notifiers can not be matched from the database, so are recorded as on the plan.
+				var onPlan []string
+				err = tx.Raw("SELECT id FROM slack_users WHERE pc_id IN (SELECT person FROM plan_peoples WHERE plan = ?)", channel.PCPlan).Scan(&onPlan).Error
+				if err != nil {
+					return err
+				}
+				position := make(map[string]bool)
+				for _, uid := range onPlan {
+					position[uid] = true
+				}
+				slackChannel := channel.Notifier == "" || channel.Notifier == "slack"
+
+				seen := make(map[string]bool)
+				for _, uid := range strings.Split(channel.UsersInvited, ",") {
+					if uid == "" || seen[uid] {
+						continue
+					}
+					seen[uid] = true
+					member := migration4ChannelMembers{
+						ChannelID: channel.ID,
+						UserID:    uid,
+						Reason:    "position",
+						InvitedAt: channel.CreatedAt,
+					}
+					if slackChannel && !position[uid] {
+						member.Reason = "sticky"
+					}
+					err = tx.Create(&member).Error
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return tx.Exec("ALTER TABLE slack_channels DROP COLUMN users_invited").Error
+		},
+		Down: func(tx *gorm.DB, dialect string) error {
+			err := tx.Exec("ALTER TABLE slack_channels ADD COLUMN users_invited text").Error
+			if err != nil {
+				return err
+			}
+
+			// Convert current members back to the invited users of each channel.
+			var members []migration4ChannelMembers
+			err = tx.Order("id ASC").Find(&members).Error
+			if err != nil {
+				return err
+			}
+			invited := make(map[string][]string)
+			for _, member := range members {
+				// Members who were invited and have not been removed.
+				if member.InvitedAt.Unix() > 0 && member.RemovedAt.Unix() <= 0 {
+					invited[member.ChannelID] = append(invited[member.ChannelID], member.UserID)
+				}
+			}
+			for channelID, users := range invited {
+				err = tx.Exec("UPDATE slack_channels SET users_invited = ? WHERE id = ?", strings.Join(users, ","), channelID).Error
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(&migration4ChannelMembers{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_channel_member_failures",
+		Up: func(tx *gorm.DB, dialect string) error {
+			columns := [][2]string{{"invite_attempts", "bigint"}, {"permanent_failure", "boolean"}}
+			if dialect == "sqlite3" {
+				columns = [][2]string{{"invite_attempts", "integer"}, {"permanent_failure", "numeric"}}
+			}
+			for _, column := range columns {
+				if tx.Migrator().HasColumn("channel_members", column[0]) {
+					continue
+				}
+				err := tx.Exec("ALTER TABLE channel_members ADD COLUMN " + column[0] + " " + column[1]).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		// Columns are dropped directly, as the migrator rebuilds SQLite tables without their indexes.
+		Down: func(tx *gorm.DB, dialect string) error {
+			for _, column := range []string{"invite_attempts", "permanent_failure"} {
+				err := tx.Exec("ALTER TABLE channel_members DROP COLUMN " + column).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "create_audit_events",
+		Up: func(tx *gorm.DB, dialect string) error {
+			return tx.AutoMigrate(&migration6AuditEvents{})
+		},
+		Down: func(tx *gorm.DB, dialect string) error {
+			return tx.Migrator().DropTable(&migration6AuditEvents{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_slack_user_manual_match",
+		Up: func(tx *gorm.DB, dialect string) error {
+			if tx.Migrator().HasColumn("slack_users", "manual_match") {
+				return nil
+			}
+			columnType := "boolean"
+			if dialect == "sqlite3" {
+				columnType = "numeric"
+			}
+			return tx.Exec("ALTER TABLE slack_users ADD COLUMN manual_match " + columnType).Error
+		},
+		// The column is dropped directly, as the migrator rebuilds SQLite tables without their indexes.
+		Down: func(tx *gorm.DB, dialect string) error {
+			return tx.Exec("ALTER TABLE slack_users DROP COLUMN manual_match").Error
+		},
+	},
+	{
+		// Existing data belongs to the default organization.
+		Version: 8,
+		Name:    "add_organizations",
+		Up: func(tx *gorm.DB, dialect string) error {
+			for _, table := range migrationOrgTables {
+				err := migrationAddOrgColumn(tx, dialect, table)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB, dialect string) error {
+			for _, table := range migrationOrgTables {
+				err := migrationDropOrgColumn(tx, dialect, table)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Migration 3 added these foreign keys on MySQL and Postgres.
+		Version: 9,
+		Name:    "add_sqlite_plan_foreign_keys",
+		Up: func(tx *gorm.DB, dialect string) error {
+			if dialect != "sqlite3" {
+				return nil
+			}
+			for _, table := range migrationPlanRowTables {
+				err := table.Rebuild(tx, true)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB, dialect string) error {
+			if dialect != "sqlite3" {
+				return nil
+			}
+			for _, table := range migrationPlanRowTables {
+				err := table.Rebuild(tx, false)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Reminders sent before belong to the default organization.
+		Version: 10,
+		Name:    "add_reminder_log_organizations",
+		Up: func(tx *gorm.DB, dialect string) error {
+			return migrationAddOrgColumn(tx, dialect, "reminder_logs")
+		},
+		Down: func(tx *gorm.DB, dialect string) error {
+			return migrationDropOrgColumn(tx, dialect, "reminder_logs")
+		},
+	},
+}
+
+// Add an indexed organization column to a table, with existing rows in the default organization.
+func migrationAddOrgColumn(tx *gorm.DB, dialect, table string) error {
+	columnType := "varchar(64)"
+	if dialect == "sqlite3" {
+		columnType = "text"
+	}
+	if !tx.Migrator().HasColumn(table, "org") {
+		err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN org " + columnType + " DEFAULT 'default'").Error
+		if err != nil {
+			return err
+		}
+	}
+	if !tx.Migrator().HasIndex(table, "idx_"+table+"_org") {
+		return tx.Exec("CREATE INDEX idx_" + table + "_org ON " + table + " (org)").Error
+	}
+	return nil
+}
+
+// Drop the organization column of a table.
+// The column is dropped directly, as the migrator rebuilds SQLite tables without their indexes.
+func migrationDropOrgColumn(tx *gorm.DB, dialect, table string) error {
+	// MySQL requires the table when dropping an index.
+	sql := "DROP INDEX idx_" + table + "_org"
+	if dialect == "mysql" {
+		sql += " ON " + table
+	}
+	err := tx.Exec(sql).Error
+	if err != nil {
+		return err
+	}
+	return tx.Exec("ALTER TABLE " + table + " DROP COLUMN org").Error
+}
+
+// A SQLite table holding rows of a plan, as it was at migration 9.
+type migrationPlanRowTable struct {
+	Name    string
+	Columns []string // Column definitions, other than the ID and plan.
+	Indexes []string // Indexed columns.
+}
+
+// Tables with rows removed with their plan.
+var migrationPlanRowTables = []migrationPlanRowTable{
+	{
+		Name:    "plan_times",
+		Columns: []string{"`name` text", "`time_type` text", "`starts_at` datetime", "`ends_at` datetime", "`live_starts_at` datetime", "`live_ends_at` datetime"},
+		Indexes: []string{"org", "starts_at", "plan"},
+	},
+	{
+		Name:    "plan_peoples",
+		Columns: []string{"`status` text", "`team_position_name` text", "`person` integer", "`request_sent_at` datetime", "`request_channel` text", "`request_message_ts` text", "`emailed_at` datetime"},
+		Indexes: []string{"org", "plan", "person"},
+	},
+	{
+		Name:    "needed_positions",
+		Columns: []string{"`team_position_name` text", "`quantity` integer"},
+		Indexes: []string{"org", "plan"},
+	},
+}
+
+// Rebuild the table with or without a foreign key to its plan, as SQLite cannot alter constraints.
+// Rows of plans which no longer exist are not copied.
+func (t migrationPlanRowTable) Rebuild(tx *gorm.DB, foreignKey bool) error {
+	columns := append([]string{"`id` integer", "`org` text DEFAULT 'default'", "`created_at` datetime", "`updated_at` datetime"}, t.Columns...)
+	columns = append(columns, "`plan` integer", "PRIMARY KEY (`id`)")
+	if foreignKey {
+		columns = append(columns, fmt.Sprintf("CONSTRAINT `fk_%s_plan` FOREIGN KEY (`plan`) REFERENCES `plans` (`id`) ON DELETE CASCADE", t.Name))
+	}
+	var names []string
+	for _, column := range columns {
+		if strings.HasPrefix(column, "`") {
+			names = append(names, strings.Fields(column)[0])
+		}
+	}
+
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE `%s_new` (%s)", t.Name, strings.Join(columns, ",")),
+		fmt.Sprintf("INSERT INTO `%s_new` (%s) SELECT %s FROM `%s` WHERE `plan` IN (SELECT `id` FROM `plans`)", t.Name, strings.Join(names, ","), strings.Join(names, ","), t.Name),
+		fmt.Sprintf("DROP TABLE `%s`", t.Name),
+		fmt.Sprintf("ALTER TABLE `%s_new` RENAME TO `%s`", t.Name, t.Name),
+	}
+	for _, index := range t.Indexes {
+		statements = append(statements, fmt.Sprintf("CREATE INDEX `idx_%s_%s` ON `%s` (`%s`)", t.Name, index, t.Name, index))
+	}
+	for _, sql := range statements {
+		err := tx.Exec(sql).Error
+		if err != nil {
+			return fmt.Errorf("%s: %s", sql, err)
+		}
+	}
+	return nil
+}
+
+// Get the migrations which have been applied, by version.
+func AppliedMigrations() (map[uint64]SchemaMigrations, error) {
+	err := app.db.AutoMigrate(&SchemaMigrations{})
+	if err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigrations
+	err = app.db.Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[uint64]SchemaMigrations)
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Apply migrations which have not been applied, up to a number of steps or all if zero.
+func MigrateUp(steps int) error {
+	applied, err := AppliedMigrations()
+	if err != nil {
+		return err
+	}
+	dialect := app.config.DB.Type
+	count := 0
+	for _, m := range Migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if steps > 0 && count >= steps {
+			break
+		}
+		count++
+
+		// Apply the migration and record it together.
+		log.Printf("Applying migration %d %s\n", m.Version, m.Name)
+		err = migrationTransaction(dialect, func(tx *gorm.DB) error {
+			err := m.Up(tx, dialect)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigrations{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s failed: %s", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Run a migration in a transaction. SQLite rebuilds tables to change them, and dropping a table
+// would delete the rows referencing it, so foreign keys are disabled on the connection while it runs.
+func migrationTransaction(dialect string, fc func(tx *gorm.DB) error) error {
+	if dialect != "sqlite3" {
+		return app.db.Transaction(fc)
+	}
+	return app.db.Connection(func(conn *gorm.DB) error {
+		err := conn.Exec("PRAGMA foreign_keys = OFF").Error
+		if err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+		return conn.Transaction(fc)
+	})
+}
+
+// Revert the most recently applied migrations.
+func MigrateDown(steps int) error {
+	applied, err := AppliedMigrations()
+	if err != nil {
+		return err
+	}
+	dialect := app.config.DB.Type
+	for i := len(Migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := Migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		steps--
+
+		// Revert the migration and remove its record together.
+		log.Printf("Reverting migration %d %s\n", m.Version, m.Name)
+		err = migrationTransaction(dialect, func(tx *gorm.DB) error {
+			err := m.Down(tx, dialect)
+			if err != nil {
+				return err
+			}
+			return tx.Where("version = ?", m.Version).Delete(&SchemaMigrations{}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d %s failed: %s", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Print the status of each migration.
+func MigrateStatus() error {
+	applied, err := AppliedMigrations()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, m := range Migrations {
+		status := "pending"
+		if row, ok := applied[m.Version]; ok {
+			status = row.AppliedAt.Local().Format(time.RFC1123)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+	return w.Flush()
+}
+
+// Run the migrate command with its arguments.
+func RunMigrateCommand(args []string) {
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+
+	// Up and down take an optional number of steps.
+	steps := 0
+	if len(args) > 1 {
+		var err error
+		steps, err = strconv.Atoi(args[1])
+		if err != nil || steps < 1 {
+			log.Fatalln("Invalid number of steps:", args[1])
+		}
+	}
+
+	var err error
+	switch args[0] {
+	case "status":
+		err = MigrateStatus()
+	case "up":
+		err = MigrateUp(steps)
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		err = MigrateDown(steps)
+	default:
+		log.Fatalln("Unknown migrate command:", args[0], "(expected status, up or down)")
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
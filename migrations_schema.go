@@ -0,0 +1,225 @@
+package main
+
+import "time"
+
+// The models as they were when the migrations creating their tables were added, so the tables
+// created do not change as the models do. Later changes are made by their own migrations.
+
+type migration1ServiceTypes struct {
+	ID         uint64 `gorm:"primary_key"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ArchivedAt time.Time
+	DeletedAt  time.Time
+	Name       string
+}
+
+func (migration1ServiceTypes) TableName() string { return "service_types" }
+
+type migration1Plans struct {
+	ID          uint64 `gorm:"primary_key"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	SeriesTitle string
+	Title       string
+	FirstTimeAt time.Time
+	LastTimeAt  time.Time
+	MultiDay    bool
+	Dates       string
+	ServiceType uint64
+}
+
+func (migration1Plans) TableName() string { return "plans" }
+
+type migration1PlanTimes struct {
+	ID           uint64 `gorm:"primary_key"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Name         string
+	TimeType     string
+	StartsAt     time.Time
+	EndsAt       time.Time
+	LiveStartsAt time.Time
+	LiveEndsAt   time.Time
+	Plan         uint64
+}
+
+func (migration1PlanTimes) TableName() string { return "plan_times" }
+
+type migration1PlanPeople struct {
+	ID               uint64 `gorm:"primary_key"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Status           string
+	TeamPositionName string
+	Person           uint64
+	Plan             uint64
+	RequestSentAt    time.Time
+	RequestChannel   string
+	RequestMessageTS string
+	EmailedAt        time.Time
+}
+
+func (migration1PlanPeople) TableName() string { return "plan_peoples" }
+
+type migration1NeededPositions struct {
+	ID               uint64 `gorm:"primary_key"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	TeamPositionName string
+	Quantity         uint64
+	Plan             uint64
+}
+
+func (migration1NeededPositions) TableName() string { return "needed_positions" }
+
+type migration1People struct {
+	ID          uint64 `gorm:"primary_key"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ArchivedAt  time.Time
+	Birthdate   time.Time
+	Anniversary time.Time
+	Status      string
+	Permissions string
+	FirstName   string
+	LastName    string
+	FacebookID  uint64
+	Email       string
+}
+
+func (migration1People) TableName() string { return "peoples" }
+
+type migration1SlackUsers struct {
+	ID                string `gorm:"primary_key"`
+	Name              string
+	RealName          string
+	FirstName         string
+	LastName          string
+	Email             string
+	Phone             string
+	Deleted           bool
+	IsBot             bool
+	IsAdmin           bool
+	IsOwner           bool
+	IsPrimaryOwner    bool
+	IsRestricted      bool
+	IsUltraRestricted bool
+	IsStranger        bool
+	IsAppUser         bool
+	IsInvitedUser     bool
+	Updated           time.Time
+	PCID              uint64
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+func (migration1SlackUsers) TableName() string { return "slack_users" }
+
+type migration1SlackChannels struct {
+	ID           string `gorm:"primary_key"`
+	Name         string
+	Description  string
+	PCPlan       uint64
+	StartsAt     time.Time
+	EndsAt       time.Time
+	UsersInvited string
+	Archived     bool
+	Notifier     string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (migration1SlackChannels) TableName() string { return "slack_channels" }
+
+type migration1ReminderLogs struct {
+	ID     uint64 `gorm:"primary_key"`
+	Kind   string
+	Ref    uint64
+	SentAt time.Time
+}
+
+func (migration1ReminderLogs) TableName() string { return "reminder_logs" }
+
+type migration1SentNotifications struct {
+	ID           uint64 `gorm:"primary_key"`
+	PlanTime     uint64
+	Notification string
+	SentAt       time.Time
+}
+
+func (migration1SentNotifications) TableName() string { return "sent_notifications" }
+
+type migration1SMSSubscriptions struct {
+	ID         uint64 `gorm:"primary_key"`
+	Person     uint64
+	Phone      string
+	OptedIn    bool
+	OptedOutAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (migration1SMSSubscriptions) TableName() string { return "sms_subscriptions" }
+
+type migration1WebhookDeliveries struct {
+	ID          uint64 `gorm:"primary_key"`
+	URL         string
+	Event       string
+	Payload     string
+	Attempts    int
+	StatusCode  int
+	LastError   string
+	DeliveredAt time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (migration1WebhookDeliveries) TableName() string { return "webhook_deliveries" }
+
+type migration1SyncRuns struct {
+	ID         uint64 `gorm:"primary_key"`
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+func (migration1SyncRuns) TableName() string { return "sync_runs" }
+
+type migration1CalendarTokens struct {
+	ID        uint64 `gorm:"primary_key"`
+	Person    uint64
+	Token     string `gorm:"size:64"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (migration1CalendarTokens) TableName() string { return "calendar_tokens" }
+
+type migration4ChannelMembers struct {
+	ID        uint64 `gorm:"primary_key"`
+	ChannelID string `gorm:"size:64;uniqueIndex:idx_channel_members_channel_user"`
+	UserID    string `gorm:"size:64;uniqueIndex:idx_channel_members_channel_user"`
+	Reason    string
+	InvitedAt time.Time
+	RemovedAt time.Time
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (migration4ChannelMembers) TableName() string { return "channel_members" }
+
+type migration6AuditEvents struct {
+	ID        uint64    `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+	Actor     string    `gorm:"size:191;index"`
+	Action    string    `gorm:"size:64;index"`
+	Platform  string    `gorm:"size:64"`
+	Target    string    `gorm:"size:191;index"`
+	Request   string    `gorm:"type:text"`
+	Response  string    `gorm:"type:text"`
+	Result    string    `gorm:"size:16"`
+	Error     string    `gorm:"type:text"`
+}
+
+func (migration6AuditEvents) TableName() string { return "audit_events" }
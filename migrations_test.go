@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// List the indexes of the database.
+func sqliteIndexes(t *testing.T) []string {
+	var names []string
+	err := app.db.Raw("SELECT name FROM sqlite_master WHERE type = 'index' ORDER BY name").Scan(&names).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMigrationsRevert(t *testing.T) {
+	newTestApp(t)
+	indexes := sqliteIndexes(t)
+
+	// Reverting each migration and applying it again leaves the same schema.
+	for steps := 1; steps <= len(Migrations); steps++ {
+		err := MigrateDown(steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = MigrateUp(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := sqliteIndexes(t)
+		if !reflect.DeepEqual(got, indexes) {
+			t.Fatalf("after reverting %d migrations, indexes %v, want %v", steps, got, indexes)
+		}
+	}
+}
+
+func TestSQLitePlanForeignKeys(t *testing.T) {
+	newTestApp(t)
+	app.db.Create(&Plans{ID: 1})
+	app.db.Create(&Plans{ID: 2})
+	app.db.Create(&PlanTimes{ID: 1, Plan: 1})
+	app.db.Create(&PlanTimes{ID: 2, Plan: 2})
+	app.db.Create(&PlanPeople{ID: 1, Plan: 1})
+	app.db.Create(&NeededPositions{ID: 1, Plan: 1})
+
+	// Rows must belong to a plan.
+	err := app.db.Create(&PlanTimes{ID: 3, Plan: 3}).Error
+	if err == nil {
+		t.Error("created a plan time without a plan")
+	}
+
+	// Rows of a plan are removed with it.
+	app.db.Delete(&Plans{ID: 1})
+	for _, model := range []interface{}{&PlanTimes{}, &PlanPeople{}, &NeededPositions{}} {
+		var count int64
+		app.db.Model(model).Where("plan = ?", 1).Count(&count)
+		if count != 0 {
+			t.Errorf("%T has %d rows of a removed plan", model, count)
+		}
+	}
+	var count int64
+	app.db.Model(&PlanTimes{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d plan times, want 1", count)
+	}
+}
+
+func TestMigrationChannelMembers(t *testing.T) {
+	newTestAppWithDB(t, DBConfig{Type: "sqlite3", Connection: filepath.Join(t.TempDir(), "test.db")}, 3)
+
+	// Channels with invited users as they were before channel members, where U1 is on the plan.
+	statements := []string{
+		"INSERT INTO plans (id) VALUES (10)",
+		"INSERT INTO plan_peoples (id, plan, person) VALUES (1, 10, 5)",
+		"INSERT INTO slack_users (id, pc_id) VALUES ('U1', 5), ('U2', 6)",
+		"INSERT INTO slack_channels (id, pc_plan, notifier, users_invited) VALUES ('C1', 10, 'slack', 'U1,U2,U1'), ('D1', 10, 'discord', 'd1,d2')",
+	}
+	for _, sql := range statements {
+		err := app.db.Exec(sql).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := MigrateUp(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users not on the plan were sticky, except for other notifiers where they can not be matched.
+	var members []ChannelMembers
+	app.db.Order("id ASC").Find(&members)
+	var got []string
+	for _, member := range members {
+		if !member.Active() {
+			t.Errorf("member %+v is not active", member)
+		}
+		got = append(got, member.ChannelID+" "+member.UserID+" "+member.Reason)
+	}
+	want := []string{"C1 U1 position", "C1 U2 sticky", "D1 d1 position", "D1 d2 position"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("members %v, want %v", got, want)
+	}
+
+	// Reverting converts the members back.
+	err = MigrateDown(len(Migrations) - 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var invited []string
+	app.db.Raw("SELECT users_invited FROM slack_channels ORDER BY id").Scan(&invited)
+	if !reflect.DeepEqual(invited, []string{"U1,U2", "d1,d2"}) {
+		t.Errorf("invited users %v after reverting", invited)
+	}
+}
+
+// The other tests run migrations on SQLite. To also run them on MySQL or Postgres,
+// set TEST_DB_TYPE and TEST_DB_CONNECTION to an empty database, which is left empty.
+func TestMigrationsDialect(t *testing.T) {
+	dbType := os.Getenv("TEST_DB_TYPE")
+	if dbType == "" {
+		t.Skip("TEST_DB_TYPE is not set")
+	}
+	newTestAppWithDB(t, DBConfig{Type: dbType, Connection: os.Getenv("TEST_DB_CONNECTION")}, 0)
+	t.Cleanup(func() {
+		err := MigrateDown(len(Migrations))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	tables, err := app.db.Migrator().GetTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tables)
+
+	// Reverting each migration and applying it again leaves the same tables.
+	for steps := 1; steps <= len(Migrations); steps++ {
+		err := MigrateDown(steps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = MigrateUp(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := app.db.Migrator().GetTables()
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tables) {
+			t.Fatalf("after reverting %d migrations, tables %v, want %v", steps, got, tables)
+		}
+	}
+
+	// Rows must belong to a plan, and are removed with it.
+	app.db.Create(&Plans{ID: 1})
+	app.db.Create(&PlanTimes{ID: 1, Plan: 1})
+	err = app.db.Create(&PlanTimes{ID: 2, Plan: 2}).Error
+	if err == nil {
+		t.Error("created a plan time without a plan")
+	}
+	app.db.Delete(&Plans{ID: 1})
+	var count int64
+	app.db.Model(&PlanTimes{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d plan times left after removing their plan", count)
+	}
+}
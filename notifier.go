@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/slack-go/slack"
+)
+
+// The name of the default notifier, which uses the slack configuration of the organization.
+const SlackNotifierName = "slack"
+
+// A notifier manages conversations and messages on a chat platform.
+type Notifier interface {
+	// Create a private conversation, returning its ID.
+	CreateConversation(name string) (string, error)
+	// Set the topic and purpose of a conversation.
+	SetTopic(conversationID, topic string) error
+	// Invite users to a conversation.
+	Invite(conversationID string, userIDs ...string) error
+	// Remove a user from a conversation.
+	Remove(conversationID, userID string) error
+	// Post a message to a conversation.
+	PostMessage(conversationID, message string) error
+	// Archive a conversation.
+	Archive(conversationID string) error
+	// Get the user ID for a planning center person, or empty if not matched.
+	UserForPerson(personID uint64) string
+}
+
+// A notifier which can send messages with buttons, such as scheduling requests.
+type InteractiveNotifier interface {
+	// Send a direct message with blocks to a user, returning the conversation and timestamp of the message.
+	PostDirectMessage(userID, text string, blocks ...slack.Block) (string, string, error)
+	// Replace the text of a message, removing its blocks.
+	UpdateMessage(conversationID, ts, text string) error
+}
+
+// Returned when a notifier can not send messages with buttons.
+var ErrNotInteractive = errors.New("notifier does not support interactive messages")
+
+// Setup notifiers from the configuration.
+// Notifiers other than slack are shared by organizations, as they are chosen by service type.
+func (a *App) InitNotifiers() {
+	a.notifiers = make(map[string]Notifier)
+
+	// Setup each configured notifier.
+	for i := range a.config.Notifiers {
+		config := &a.config.Notifiers[i]
+		if config.Name == "" || config.Name == SlackNotifierName {
+			log.Fatalln("Notifiers must have a name other than", SlackNotifierName)
+		}
+
+		switch config.Type {
+		case "discord":
+			a.notifiers[config.Name] = NewDiscordNotifier(config)
+		case "mattermost":
+			a.notifiers[config.Name] = NewMattermostNotifier(config)
+		default:
+			log.Fatalln("Unknown notifier type:", config.Type)
+		}
+	}
+}
+
+// Get a notifier by name, defaulting to the slack workspace of the organization.
+func NotifierByName(org *Org, name string) Notifier {
+	n, ok := app.notifiers[name]
+	if !ok {
+		return org.notifier
+	}
+	return n
+}
+
+// Get the configuration for the notifier used by a service type, or nil if slack is used.
+func NotifierConfigForServiceType(serviceTypeID uint64) *NotifierConfig {
+	for i, config := range app.config.Notifiers {
+		for _, id := range config.ServiceTypeIDs {
+			if id == serviceTypeID {
+				return &app.config.Notifiers[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Get the name of the notifier used by a service type.
+func NotifierNameForServiceType(serviceTypeID uint64) string {
+	config := NotifierConfigForServiceType(serviceTypeID)
+	if config == nil {
+		return SlackNotifierName
+	}
+	return config.Name
+}
+
+// Get the users to add to every channel for a notifier.
+func NotifierStickyUsers(org *Org, name string) []string {
+	for _, config := range app.config.Notifiers {
+		if config.Name == name {
+			return config.StickyUsers
+		}
+	}
+	return org.Slack.StickyUsers
+}
+
+// Get the conversation to send messages to when no service is occurring for a notifier.
+func NotifierDefaultConversation(org *Org, name string) string {
+	for _, config := range app.config.Notifiers {
+		if config.Name == name {
+			return config.DefaultConversation
+		}
+	}
+	return org.Slack.DefaultConversation
+}
+
+// Make a JSON request to a notifier's HTTP API.
+func NotifierRequest(method, url, authorization string, body, response interface{}) error {
+	// Encode the body if one was provided.
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// Make the request.
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Perform the request.
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Read the response to provide details on failure.
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s: %s", res.Status, bytes.TrimSpace(data))
+	}
+
+	// Decode the response if requested.
+	if response != nil && len(data) != 0 {
+		return json.Unmarshal(data, response)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Discord permission to view a channel and send messages in it.
+const DiscordViewAndSendPermission = "3072"
+
+// Notifier using the Discord bot API.
+type DiscordNotifier struct {
+	config *NotifierConfig
+}
+
+// Create a notifier for a discord bot.
+func NewDiscordNotifier(config *NotifierConfig) *DiscordNotifier {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://discord.com/api/v10"
+	}
+	return &DiscordNotifier{config: config}
+}
+
+// Make a request to the Discord API.
+func (n *DiscordNotifier) request(method, uri string, body, response interface{}) error {
+	url := strings.TrimSuffix(n.config.BaseURL, "/") + uri
+	return NotifierRequest(method, url, "Bot "+n.config.Token, body, response)
+}
+
+// Create a text channel that only invited users can view.
+func (n *DiscordNotifier) CreateConversation(name string) (string, error) {
+	// The everyone role has the same ID as the guild, deny it from viewing the channel.
+	body := map[string]interface{}{
+		"name": name,
+		"type": 0,
+		"permission_overwrites": []map[string]interface{}{
+			{"id": n.config.GuildID, "type": 0, "deny": "1024"},
+		},
+	}
+	var channel struct {
+		ID string `json:"id"`
+	}
+	err := n.request(http.MethodPost, "/guilds/"+n.config.GuildID+"/channels", body, &channel)
+	return channel.ID, err
+}
+
+// Set the topic of a channel.
+func (n *DiscordNotifier) SetTopic(conversationID, topic string) error {
+	return n.request(http.MethodPatch, "/channels/"+conversationID, map[string]interface{}{"topic": topic}, nil)
+}
+
+// Invite users by allowing them to view the channel.
+func (n *DiscordNotifier) Invite(conversationID string, userIDs ...string) error {
+	for _, userID := range userIDs {
+		body := map[string]interface{}{"type": 1, "allow": DiscordViewAndSendPermission}
+		err := n.request(http.MethodPut, "/channels/"+conversationID+"/permissions/"+userID, body, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove a user by removing their permission to view the channel.
+func (n *DiscordNotifier) Remove(conversationID, userID string) error {
+	return n.request(http.MethodDelete, "/channels/"+conversationID+"/permissions/"+userID, nil, nil)
+}
+
+// Post a message to a channel.
+func (n *DiscordNotifier) PostMessage(conversationID, message string) error {
+	return n.request(http.MethodPost, "/channels/"+conversationID+"/messages", map[string]interface{}{"content": message}, nil)
+}
+
+// Discord does not have archiving, so channels are moved to the archive category if configured.
+func (n *DiscordNotifier) Archive(conversationID string) error {
+	if n.config.ArchiveCategoryID == "" {
+		log.Println("No archive category configured for", n.config.Name, "leaving channel", conversationID)
+		return nil
+	}
+	return n.request(http.MethodPatch, "/channels/"+conversationID, map[string]interface{}{"parent_id": n.config.ArchiveCategoryID}, nil)
+}
+
+// Get the discord user mapped to a planning center person.
+func (n *DiscordNotifier) UserForPerson(personID uint64) string {
+	return n.config.Users[strconv.FormatUint(personID, 10)]
+}
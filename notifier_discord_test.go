@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDiscordNotifier(t *testing.T) {
+	s := newNotifierStandIn(t)
+	n := NewDiscordNotifier(&NotifierConfig{
+		Name:              "discord",
+		Token:             "TOKEN",
+		BaseURL:           s.URL + "/api/",
+		GuildID:           "G1",
+		ArchiveCategoryID: "A1",
+	})
+
+	testNotifier(t, s, n, "Bot TOKEN", []notifierCase{
+		{
+			name: "create",
+			action: func(n Notifier) error {
+				id, err := n.CreateConversation("sunday")
+				if err == nil && id != "C1" {
+					t.Errorf("created %q, want C1", id)
+				}
+				return err
+			},
+			requests: []standInRequest{{
+				Method: http.MethodPost,
+				Path:   "/api/guilds/G1/channels",
+				Body: map[string]interface{}{
+					"name": "sunday",
+					"type": float64(0),
+					"permission_overwrites": []interface{}{
+						map[string]interface{}{"id": "G1", "type": float64(0), "deny": "1024"},
+					},
+				},
+			}},
+		},
+		{
+			name:   "topic",
+			action: func(n Notifier) error { return n.SetTopic("C1", "Sunday service") },
+			requests: []standInRequest{{
+				Method: http.MethodPatch,
+				Path:   "/api/channels/C1",
+				Body:   map[string]interface{}{"topic": "Sunday service"},
+			}},
+		},
+		{
+			name:   "invite",
+			action: func(n Notifier) error { return n.Invite("C1", "U1", "U2") },
+			requests: []standInRequest{
+				{
+					Method: http.MethodPut,
+					Path:   "/api/channels/C1/permissions/U1",
+					Body:   map[string]interface{}{"type": float64(1), "allow": DiscordViewAndSendPermission},
+				},
+				{
+					Method: http.MethodPut,
+					Path:   "/api/channels/C1/permissions/U2",
+					Body:   map[string]interface{}{"type": float64(1), "allow": DiscordViewAndSendPermission},
+				},
+			},
+		},
+		{
+			name:   "remove",
+			action: func(n Notifier) error { return n.Remove("C1", "U1") },
+			requests: []standInRequest{{
+				Method: http.MethodDelete,
+				Path:   "/api/channels/C1/permissions/U1",
+			}},
+		},
+		{
+			name:   "post",
+			action: func(n Notifier) error { return n.PostMessage("C1", "Hello") },
+			requests: []standInRequest{{
+				Method: http.MethodPost,
+				Path:   "/api/channels/C1/messages",
+				Body:   map[string]interface{}{"content": "Hello"},
+			}},
+		},
+		{
+			name:   "archive",
+			action: func(n Notifier) error { return n.Archive("C1") },
+			requests: []standInRequest{{
+				Method: http.MethodPatch,
+				Path:   "/api/channels/C1",
+				Body:   map[string]interface{}{"parent_id": "A1"},
+			}},
+		},
+	})
+}
+
+func TestDiscordNotifierArchiveWithoutCategory(t *testing.T) {
+	s := newNotifierStandIn(t)
+	n := NewDiscordNotifier(&NotifierConfig{Name: "discord", Token: "TOKEN", BaseURL: s.URL})
+	err := n.Archive("C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := s.take(); len(requests) != 0 {
+		t.Errorf("made requests %+v, want none", requests)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Notifier using the Mattermost API.
+type MattermostNotifier struct {
+	config *NotifierConfig
+}
+
+// Create a notifier for a mattermost server.
+func NewMattermostNotifier(config *NotifierConfig) *MattermostNotifier {
+	return &MattermostNotifier{config: config}
+}
+
+// Make a request to the Mattermost API.
+func (n *MattermostNotifier) request(method, uri string, body, response interface{}) error {
+	url := strings.TrimSuffix(n.config.BaseURL, "/") + "/api/v4" + uri
+	return NotifierRequest(method, url, "Bearer "+n.config.Token, body, response)
+}
+
+// Create a private channel.
+func (n *MattermostNotifier) CreateConversation(name string) (string, error) {
+	body := map[string]interface{}{
+		"team_id":      n.config.TeamID,
+		"name":         name,
+		"display_name": name,
+		"type":         "P",
+	}
+	var channel struct {
+		ID string `json:"id"`
+	}
+	err := n.request(http.MethodPost, "/channels", body, &channel)
+	return channel.ID, err
+}
+
+// Set the header and purpose of a channel.
+func (n *MattermostNotifier) SetTopic(conversationID, topic string) error {
+	body := map[string]interface{}{"header": topic, "purpose": topic}
+	return n.request(http.MethodPut, "/channels/"+conversationID+"/patch", body, nil)
+}
+
+// Add users to a channel.
+func (n *MattermostNotifier) Invite(conversationID string, userIDs ...string) error {
+	for _, userID := range userIDs {
+		err := n.request(http.MethodPost, "/channels/"+conversationID+"/members", map[string]interface{}{"user_id": userID}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove a user from a channel.
+func (n *MattermostNotifier) Remove(conversationID, userID string) error {
+	return n.request(http.MethodDelete, "/channels/"+conversationID+"/members/"+userID, nil, nil)
+}
+
+// Post a message to a channel.
+func (n *MattermostNotifier) PostMessage(conversationID, message string) error {
+	body := map[string]interface{}{"channel_id": conversationID, "message": message}
+	return n.request(http.MethodPost, "/posts", body, nil)
+}
+
+// Archive a channel, which mattermost does when deleting.
+func (n *MattermostNotifier) Archive(conversationID string) error {
+	return n.request(http.MethodDelete, "/channels/"+conversationID, nil, nil)
+}
+
+// Get the mattermost user mapped to a planning center person.
+func (n *MattermostNotifier) UserForPerson(personID uint64) string {
+	return n.config.Users[strconv.FormatUint(personID, 10)]
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMattermostNotifier(t *testing.T) {
+	s := newNotifierStandIn(t)
+	n := NewMattermostNotifier(&NotifierConfig{
+		Name:    "mattermost",
+		Token:   "TOKEN",
+		BaseURL: s.URL + "/",
+		TeamID:  "T1",
+	})
+
+	testNotifier(t, s, n, "Bearer TOKEN", []notifierCase{
+		{
+			name: "create",
+			action: func(n Notifier) error {
+				id, err := n.CreateConversation("sunday")
+				if err == nil && id != "C1" {
+					t.Errorf("created %q, want C1", id)
+				}
+				return err
+			},
+			requests: []standInRequest{{
+				Method: http.MethodPost,
+				Path:   "/api/v4/channels",
+				Body: map[string]interface{}{
+					"team_id":      "T1",
+					"name":         "sunday",
+					"display_name": "sunday",
+					"type":         "P",
+				},
+			}},
+		},
+		{
+			name:   "topic",
+			action: func(n Notifier) error { return n.SetTopic("C1", "Sunday service") },
+			requests: []standInRequest{{
+				Method: http.MethodPut,
+				Path:   "/api/v4/channels/C1/patch",
+				Body:   map[string]interface{}{"header": "Sunday service", "purpose": "Sunday service"},
+			}},
+		},
+		{
+			name:   "invite",
+			action: func(n Notifier) error { return n.Invite("C1", "U1", "U2") },
+			requests: []standInRequest{
+				{
+					Method: http.MethodPost,
+					Path:   "/api/v4/channels/C1/members",
+					Body:   map[string]interface{}{"user_id": "U1"},
+				},
+				{
+					Method: http.MethodPost,
+					Path:   "/api/v4/channels/C1/members",
+					Body:   map[string]interface{}{"user_id": "U2"},
+				},
+			},
+		},
+		{
+			name:   "remove",
+			action: func(n Notifier) error { return n.Remove("C1", "U1") },
+			requests: []standInRequest{{
+				Method: http.MethodDelete,
+				Path:   "/api/v4/channels/C1/members/U1",
+			}},
+		},
+		{
+			name:   "post",
+			action: func(n Notifier) error { return n.PostMessage("C1", "Hello") },
+			requests: []standInRequest{{
+				Method: http.MethodPost,
+				Path:   "/api/v4/posts",
+				Body:   map[string]interface{}{"channel_id": "C1", "message": "Hello"},
+			}},
+		},
+		{
+			name:   "archive",
+			action: func(n Notifier) error { return n.Archive("C1") },
+			requests: []standInRequest{{
+				Method: http.MethodDelete,
+				Path:   "/api/v4/channels/C1",
+			}},
+		},
+	})
+}
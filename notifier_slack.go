@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/slack-go/slack"
+)
+
+// Notifier using the Slack API.
+type SlackNotifier struct {
+	client *slack.Client
+}
+
+// Create a notifier for a slack client.
+func NewSlackNotifier(client *slack.Client) *SlackNotifier {
+	return &SlackNotifier{client: client}
+}
+
+// Create a private channel.
+func (n *SlackNotifier) CreateConversation(name string) (string, error) {
+	channelInfo := slack.CreateConversationParams{
+		ChannelName: name,
+		IsPrivate:   true,
+	}
+	schan, err := n.client.CreateConversation(channelInfo)
+	if err != nil {
+		return "", err
+	}
+	return schan.ID, nil
+}
+
+// Set the topic and purpose of a channel.
+func (n *SlackNotifier) SetTopic(conversationID, topic string) error {
+	_, err := n.client.SetTopicOfConversation(conversationID, topic)
+	if err != nil {
+		return err
+	}
+	_, err = n.client.SetPurposeOfConversation(conversationID, topic)
+	return err
+}
+
+// Invite users to a channel.
+func (n *SlackNotifier) Invite(conversationID string, userIDs ...string) error {
+	_, err := n.client.InviteUsersToConversation(conversationID, userIDs...)
+	return err
+}
+
+// Remove a user from a channel.
+func (n *SlackNotifier) Remove(conversationID, userID string) error {
+	return n.client.KickUserFromConversation(conversationID, userID)
+}
+
+// Post a message to a channel.
+func (n *SlackNotifier) PostMessage(conversationID, message string) error {
+	_, _, err := n.client.PostMessage(conversationID, slack.MsgOptionText(message, false))
+	return err
+}
+
+// Send a direct message with blocks to a user.
+func (n *SlackNotifier) PostDirectMessage(userID, text string, blocks ...slack.Block) (string, string, error) {
+	channel, _, _, err := n.client.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+	if err != nil {
+		return "", "", err
+	}
+	_, ts, err := n.client.PostMessage(channel.ID, slack.MsgOptionText(text, false), slack.MsgOptionBlocks(blocks...))
+	return channel.ID, ts, err
+}
+
+// Replace the text of a message, removing its blocks.
+func (n *SlackNotifier) UpdateMessage(conversationID, ts, text string) error {
+	_, _, _, err := n.client.UpdateMessage(conversationID, ts, slack.MsgOptionText(text, false), slack.MsgOptionBlocks())
+	return err
+}
+
+// Archive a channel.
+func (n *SlackNotifier) Archive(conversationID string) error {
+	return n.client.ArchiveConversation(conversationID)
+}
+
+// Get the slack user matched to a planning center person.
+func (n *SlackNotifier) UserForPerson(personID uint64) string {
+	var slackUser SlackUsers
+	app.db.Where("pc_id = ?", personID).First(&slackUser)
+	return slackUser.ID
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A request received by a notifier stand-in.
+type standInRequest struct {
+	Method        string
+	Path          string
+	Authorization string
+	Body          map[string]interface{}
+}
+
+// A local HTTP stand-in for a chat platform API, recording requests it receives.
+type notifierStandIn struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []standInRequest
+	status   int    // Status to respond with, 200 if zero.
+	response string // Body to respond with.
+}
+
+// Start a stand-in which responds to every request with a status and body.
+func newNotifierStandIn(t *testing.T) *notifierStandIn {
+	s := &notifierStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := standInRequest{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			Authorization: r.Header.Get("Authorization"),
+		}
+		data, _ := io.ReadAll(r.Body)
+		if len(data) != 0 {
+			err := json.Unmarshal(data, &req.Body)
+			if err != nil {
+				t.Errorf("%s %s: body is not JSON: %s", r.Method, r.URL.Path, data)
+			}
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		status, response := s.status, s.response
+		s.mu.Unlock()
+
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Set the response to future requests.
+func (s *notifierStandIn) respond(status int, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+	s.response = response
+}
+
+// Get and clear the requests received.
+func (s *notifierStandIn) take() []standInRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+// A notifier action and the requests it is expected to make.
+type notifierCase struct {
+	name     string
+	action   func(n Notifier) error
+	requests []standInRequest
+}
+
+// Run each action against a notifier, checking the requests made,
+// then check each action returns the error when the API responds with one.
+func testNotifier(t *testing.T, s *notifierStandIn, n Notifier, authorization string, cases []notifierCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s.respond(http.StatusOK, `{"id":"C1"}`)
+			err := c.action(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			requests := s.take()
+			for i := range c.requests {
+				c.requests[i].Authorization = authorization
+			}
+			if !reflect.DeepEqual(requests, c.requests) {
+				t.Errorf("requests\n got %+v\nwant %+v", requests, c.requests)
+			}
+		})
+	}
+
+	for _, c := range cases {
+		t.Run(c.name+" error", func(t *testing.T) {
+			s.respond(http.StatusForbidden, `{"message":"Missing Permissions"}`)
+			err := c.action(n)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "Missing Permissions") {
+				t.Errorf("error %q does not include the status and response", err)
+			}
+			s.take()
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Find the plan of an organization with a service occurring at a time.
+func PlanOccurringAt(org *Org, t time.Time) (planTime PlanTimes) {
+	app.db.Where("org = ? AND time_type='service' AND starts_at < ? AND ends_at > ?", org.Name, t, t).First(&planTime)
+	return
+}
+
+// Find the channel for a plan, if one was created for it.
+func PlanChannel(planID uint64) (channel SlackChannels) {
+	app.db.Where("pc_plan = ?", planID).First(&channel)
+	return
+}
+
+// Send a message to the channel for the current service of an organization.
+// Defaults to admin if no service currently occuring.
+func SendMessage(org *Org, actor, message string) error {
+	// Get current time and default conversation.
+	now := time.Now().UTC()
+	notifierName := SlackNotifierName
+	conversation := org.Slack.DefaultConversation
+
+	// Find plan times that are occuring right now.
+	planTime := PlanOccurringAt(org, now)
+	if planTime.Plan != 0 {
+		// If plan found, check for the channel.
+		channel := PlanChannel(planTime.Plan)
+		if channel.ID != "" {
+			// If channel found, update the conversation to the channel ID.
+			notifierName = channel.Notifier
+			conversation = channel.ID
+		} else {
+			// Otherwise use the admin of the notifier for the service type.
+			var plan Plans
+			app.db.Where("id = ?", planTime.Plan).First(&plan)
+			notifierName = NotifierNameForServiceType(plan.ServiceType)
+			conversation = NotifierDefaultConversation(org, notifierName)
+		}
+	}
+
+	// If no conversation found, likely will happen if no admin is configured, return error.
+	if conversation == "" {
+		return fmt.Errorf("no conversation found")
+	}
+
+	// Send the message.
+	err := NotifierAs(org, notifierName, actor).PostMessage(conversation, message)
+	if err != nil {
+		return fmt.Errorf("error sending message: %s", err)
+	}
+
+	// Let webhooks and stream clients know the message was sent.
+	event := MessageEvent{
+		Organization: org.Name,
+		Conversation: conversation,
+		Notifier:     notifierName,
+		Message:      message,
+	}
+	if planTime.Plan != 0 {
+		event.Plan = new(Plans)
+		app.db.Where("id = ?", planTime.Plan).First(event.Plan)
+	}
+	DispatchEvent(EventMessageSent, event)
+	messageStream.Publish(event)
+
+	// Email the message to people on the plan who are not in the channel.
+	if org.Email.Host != "" && planTime.Plan != 0 {
+		go EmailPlanMessage(org, actor, planTime.Plan, message)
+	}
+	return nil
+}
+
+// Send a message to the channel for a plan.
+func PostPlanMessage(actor string, planID uint64, message string) error {
+	// Find the channel for the plan.
+	channel := PlanChannel(planID)
+	if channel.ID == "" {
+		return fmt.Errorf("no channel found for plan %d", planID)
+	}
+	org := OrgByName(channel.Org)
+	if org == nil {
+		return fmt.Errorf("unknown organization %q for plan %d", channel.Org, planID)
+	}
+
+	// Send the message.
+	err := NotifierAs(org, channel.Notifier, actor).PostMessage(channel.ID, message)
+	if err != nil {
+		return fmt.Errorf("error sending message: %s", err)
+	}
+
+	// Let webhooks and stream clients know the message was sent.
+	event := MessageEvent{
+		Organization: org.Name,
+		Conversation: channel.ID,
+		Notifier:     channel.Notifier,
+		Message:      message,
+		Plan:         new(Plans),
+	}
+	app.db.Where("id = ?", planID).First(event.Plan)
+	DispatchEvent(EventMessageSent, event)
+	messageStream.Publish(event)
+	return nil
+}
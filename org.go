@@ -0,0 +1,133 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// Name of the organization used when none are configured, which existing data belongs to.
+const DefaultOrgName = "default"
+
+// An organization, with its own Planning Center account, Slack workspace and channel policy.
+// Synced data is recorded with the name of the organization it belongs to.
+type Org struct {
+	Name           string
+	PlanningCenter *PlanningCenterConfig
+	Slack          *SlackConfig
+	Reminders      *RemindersConfig
+	Email          *EmailConfig // Email is disabled if the host is empty.
+
+	slack    *slack.Client
+	notifier Notifier
+}
+
+// Setup organizations from the configuration.
+// Without organizations configured, the top level configuration is used as the default organization.
+func (a *App) InitOrgs() {
+	a.orgs = nil
+	if len(a.config.Organizations) == 0 {
+		a.orgs = append(a.orgs, &Org{
+			Name:           DefaultOrgName,
+			PlanningCenter: &a.config.PlanningCenter,
+			Slack:          &a.config.Slack,
+			Reminders:      &a.config.Reminders,
+			Email:          &a.config.Email,
+		})
+	}
+	for i := range a.config.Organizations {
+		config := &a.config.Organizations[i]
+		// Organizations without their own email server share the top level one.
+		email := &a.config.Email
+		if config.Email.Host != "" {
+			email = &config.Email
+		}
+		a.orgs = append(a.orgs, &Org{
+			Name:           config.Name,
+			PlanningCenter: &config.PlanningCenter,
+			Slack:          &config.Slack,
+			Reminders:      &config.Reminders,
+			Email:          email,
+		})
+	}
+
+	// Each organization has its own Slack workspace.
+	for _, org := range a.orgs {
+		org.slack = slack.New(org.Slack.APIToken, slack.OptionAPIURL(SlackAPIURL))
+		org.notifier = NewSlackNotifier(org.slack)
+	}
+}
+
+// URL of the Slack API, ending with a slash.
+var SlackAPIURL = slack.APIURL
+
+// Get an organization by name, or nil if it is not configured.
+func OrgByName(name string) *Org {
+	for _, org := range app.orgs {
+		if org.Name == name {
+			return org
+		}
+	}
+	return nil
+}
+
+// Get an organization by name, defaulting to the first organization if the name is empty.
+func OrgOrDefault(name string) *Org {
+	if name == "" {
+		return app.orgs[0]
+	}
+	return OrgByName(name)
+}
+
+// Get the organizations selected with the org flag, or all organizations.
+func SelectedOrgs() []*Org {
+	if app.flags.Org == "" {
+		return app.orgs
+	}
+	org := OrgByName(app.flags.Org)
+	if org == nil {
+		log.Fatalln("Unknown organization:", app.flags.Org)
+	}
+	return []*Org{org}
+}
+
+// Get the organization selected with the org flag, or the first organization.
+func SelectedOrg() *Org {
+	return SelectedOrgs()[0]
+}
+
+// Get the names of organizations.
+func OrgNames(orgs []*Org) []string {
+	var names []string
+	for _, org := range orgs {
+		names = append(names, org.Name)
+	}
+	return names
+}
+
+// Count the organizations configured in the configuration file and environment,
+// so their defaults can be set before loading.
+func ConfigOrganizationCount(configFile string) (n int) {
+	// Count organizations in the file.
+	if organizations, ok := ReadConfigDocument(configFile)["organizations"].([]interface{}); ok {
+		n = len(organizations)
+	}
+
+	// Organizations may also be configured by index in the environment, such as ORGANIZATIONS_1_NAME.
+	prefix := ConfigEnvPrefix + "_ORGANIZATIONS_"
+	for _, env := range os.Environ() {
+		key, _, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		index, _, _ := strings.Cut(strings.TrimPrefix(key, prefix), "_")
+		i, err := strconv.Atoi(index)
+		if err == nil && i >= n {
+			n = i + 1
+		}
+	}
+	return
+}
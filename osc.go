@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"path"
+)
+
+// SLIP framing bytes used by OSC 1.1 over TCP.
+const (
+	SLIPEnd    = 0xC0
+	SLIPEsc    = 0xDB
+	SLIPEscEnd = 0xDC
+	SLIPEscEsc = 0xDD
+)
+
+// An OSC message.
+type OSCMessage struct {
+	Address string
+	Args    []interface{}
+}
+
+// Data available to OSC message templates.
+type OSCData struct {
+	Address string
+	Args    []interface{}
+}
+
+// Read an OSC string, which is null terminated and padded to 4 bytes.
+func oscReadString(data []byte) (string, []byte, error) {
+	end := bytes.IndexByte(data, 0)
+	if end == -1 {
+		return "", nil, fmt.Errorf("unterminated string")
+	}
+	size := (end + 4) &^ 3
+	if size > len(data) {
+		return "", nil, fmt.Errorf("string padding exceeds packet")
+	}
+	return string(data[:end]), data[size:], nil
+}
+
+// Read a fixed number of bytes from OSC data.
+func oscReadBytes(data []byte, n int) ([]byte, []byte, error) {
+	if n < 0 || len(data) < n {
+		return nil, nil, fmt.Errorf("argument exceeds packet")
+	}
+	return data[:n], data[n:], nil
+}
+
+// Parse an OSC packet, which is either a message or a bundle of packets.
+func ParseOSCPacket(data []byte) ([]OSCMessage, error) {
+	// Bundles contain a time tag followed by sized elements.
+	if bytes.HasPrefix(data, []byte("#bundle\x00")) {
+		if len(data) < 16 {
+			return nil, fmt.Errorf("bundle too short")
+		}
+		data = data[16:]
+		var messages []OSCMessage
+		for len(data) != 0 {
+			size, rest, err := oscReadBytes(data, 4)
+			if err != nil {
+				return nil, err
+			}
+			element, rest, err := oscReadBytes(rest, int(int32(binary.BigEndian.Uint32(size))))
+			if err != nil {
+				return nil, err
+			}
+			elementMessages, err := ParseOSCPacket(element)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, elementMessages...)
+			data = rest
+		}
+		return messages, nil
+	}
+
+	// Otherwise, parse a message.
+	message, err := ParseOSCMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return []OSCMessage{message}, nil
+}
+
+// Parse an OSC message.
+func ParseOSCMessage(data []byte) (message OSCMessage, err error) {
+	message.Address, data, err = oscReadString(data)
+	if err != nil {
+		return
+	}
+	if len(message.Address) == 0 || message.Address[0] != '/' {
+		return message, fmt.Errorf("invalid address: %q", message.Address)
+	}
+
+	// Messages without a type tag have no arguments.
+	if len(data) == 0 {
+		return
+	}
+	var tags string
+	tags, data, err = oscReadString(data)
+	if err != nil {
+		return
+	}
+	if len(tags) == 0 || tags[0] != ',' {
+		return message, fmt.Errorf("invalid type tag: %q", tags)
+	}
+
+	// Parse each argument by its type.
+	for _, tag := range tags[1:] {
+		var b []byte
+		switch tag {
+		case 'i', 'c', 'r', 'm':
+			b, data, err = oscReadBytes(data, 4)
+			if err == nil {
+				message.Args = append(message.Args, int32(binary.BigEndian.Uint32(b)))
+			}
+		case 'f':
+			b, data, err = oscReadBytes(data, 4)
+			if err == nil {
+				message.Args = append(message.Args, math.Float32frombits(binary.BigEndian.Uint32(b)))
+			}
+		case 'h', 't':
+			b, data, err = oscReadBytes(data, 8)
+			if err == nil {
+				message.Args = append(message.Args, int64(binary.BigEndian.Uint64(b)))
+			}
+		case 'd':
+			b, data, err = oscReadBytes(data, 8)
+			if err == nil {
+				message.Args = append(message.Args, math.Float64frombits(binary.BigEndian.Uint64(b)))
+			}
+		case 's', 'S':
+			var s string
+			s, data, err = oscReadString(data)
+			if err == nil {
+				message.Args = append(message.Args, s)
+			}
+		case 'b':
+			b, data, err = oscReadBytes(data, 4)
+			if err == nil {
+				// Check the size before padding it, as a negative size would pad to zero.
+				size := int(int32(binary.BigEndian.Uint32(b)))
+				if size < 0 || size > len(data) {
+					return message, fmt.Errorf("invalid blob size: %d", size)
+				}
+				b, data, err = oscReadBytes(data, (size+3)&^3)
+				if err == nil {
+					message.Args = append(message.Args, b[:size])
+				}
+			}
+		case 'T':
+			message.Args = append(message.Args, true)
+		case 'F':
+			message.Args = append(message.Args, false)
+		case 'N', 'I':
+			message.Args = append(message.Args, nil)
+		default:
+			return message, fmt.Errorf("unsupported type tag: %c", tag)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Check if a mapping matches a message.
+func (c OSCMappingConfig) Matches(message OSCMessage) bool {
+	matched, err := path.Match(c.Address, message.Address)
+	if err != nil || !matched {
+		return false
+	}
+
+	// Each configured argument must match the argument sent.
+	for i, arg := range c.Args {
+		if i >= len(message.Args) || fmt.Sprint(message.Args[i]) != arg {
+			return false
+		}
+	}
+	return true
+}
+
+// Handle an OSC packet by firing mappings that match its messages.
+func HandleOSCPacket(data []byte) {
+	messages, err := ParseOSCPacket(data)
+	if err != nil {
+		log.Println("Error parsing OSC packet:", err)
+		return
+	}
+	for _, message := range messages {
+		for _, mapping := range app.config.OSC.Mappings {
+			if mapping.Matches(message) {
+				FireTrigger(OrgOrDefault(app.config.OSC.Organization), ActorOSC, mapping.Message, mapping.Priority, mapping.Positions, OSCData(message))
+			}
+		}
+	}
+}
+
+// Read SLIP encoded packets from a stream.
+func ReadSLIPPackets(r io.Reader, handle func([]byte)) error {
+	br := bufio.NewReader(r)
+	var packet []byte
+	escaped := false
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case c == SLIPEnd:
+			if len(packet) != 0 {
+				handle(packet)
+			}
+			packet = nil
+		case escaped:
+			escaped = false
+			if c == SLIPEscEnd {
+				packet = append(packet, SLIPEnd)
+			} else if c == SLIPEscEsc {
+				packet = append(packet, SLIPEsc)
+			} else {
+				packet = append(packet, c)
+			}
+		case c == SLIPEsc:
+			escaped = true
+		default:
+			packet = append(packet, c)
+		}
+	}
+}
+
+// Read packets prefixed with their size from a stream, used by OSC 1.0 over TCP.
+func ReadSizedPackets(r io.Reader, handle func([]byte)) error {
+	br := bufio.NewReader(r)
+	for {
+		var size int32
+		err := binary.Read(br, binary.BigEndian, &size)
+		if err != nil {
+			return err
+		}
+		if size < 0 || size > 1<<20 {
+			return fmt.Errorf("invalid packet size: %d", size)
+		}
+		packet := make([]byte, size)
+		_, err = io.ReadFull(br, packet)
+		if err != nil {
+			return err
+		}
+		handle(packet)
+	}
+}
+
+// Start the OSC servers, which stop when the context is done.
+func StartOSCServer(ctx context.Context) {
+	config := app.config.OSC
+	addr := net.JoinHostPort(config.BindAddr, fmt.Sprint(config.Port))
+
+	// Listen on UDP.
+	log.Println("Starting OSC server:", addr)
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Fatal("OSC Listen: ", err)
+	}
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packet := make([]byte, n)
+			copy(packet, buf[:n])
+			HandleOSCPacket(packet)
+		}
+	}()
+
+	// Listen on TCP if enabled.
+	if !config.TCP {
+		return
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal("OSC Listen: ", err)
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				if config.Framing == "length" {
+					err = ReadSizedPackets(conn, HandleOSCPacket)
+				} else {
+					err = ReadSLIPPackets(conn, HandleOSCPacket)
+				}
+				if err != nil && err != io.EOF {
+					log.Println("OSC connection error:", err)
+				}
+			}(conn)
+		}
+	}()
+}
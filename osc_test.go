@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// Pad OSC data to a multiple of four bytes.
+func oscPad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// Encode an OSC string.
+func oscString(s string) []byte {
+	return oscPad(append([]byte(s), 0))
+}
+
+// Encode a big endian 32 bit integer.
+func oscInt32(i int32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(i))
+	return b
+}
+
+// Build an OSC message from its address, type tags and encoded arguments.
+func oscMessage(address, tags string, args ...[]byte) []byte {
+	b := append(oscString(address), oscString(tags)...)
+	for _, arg := range args {
+		b = append(b, arg...)
+	}
+	return b
+}
+
+func TestParseOSCMessage(t *testing.T) {
+	data := oscMessage("/cue/go", ",isbTN",
+		oscInt32(3),
+		oscString("walk in"),
+		oscInt32(5), oscPad([]byte("hello")),
+	)
+	message, err := ParseOSCMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OSCMessage{Address: "/cue/go", Args: []interface{}{int32(3), "walk in", []byte("hello"), true, nil}}
+	if !reflect.DeepEqual(message, want) {
+		t.Errorf("parsed %#v, want %#v", message, want)
+	}
+}
+
+func TestParseOSCMessageBlobs(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		data []byte
+		want []byte // Blob parsed, nil if an error is expected.
+	}{
+		{"empty", oscMessage("/b", ",b", oscInt32(0)), []byte{}},
+		{"padded", oscMessage("/b", ",b", oscInt32(2), []byte{1, 2, 0, 0}), []byte{1, 2}},
+		{"negative one", oscMessage("/b", ",b", oscInt32(-1)), nil},
+		{"negative three", oscMessage("/b", ",b", oscInt32(-3), []byte{1, 2, 3, 4}), nil},
+		{"negative min", oscMessage("/b", ",b", oscInt32(-1<<31), []byte{1, 2, 3, 4}), nil},
+		{"truncated", oscMessage("/b", ",b", oscInt32(8), []byte{1, 2, 3, 4}), nil},
+		{"truncated padding", oscMessage("/b", ",b", oscInt32(3), []byte{1, 2, 3}), nil},
+		{"missing size", oscMessage("/b", ",b", []byte{0, 0}), nil},
+		{"huge", oscMessage("/b", ",b", oscInt32(1<<31-1), []byte{1, 2, 3, 4}), nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			message, err := ParseOSCMessage(c.data)
+			if c.want == nil {
+				if err == nil {
+					t.Errorf("parsed %#v, want an error", message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(message.Args) != 1 || !bytes.Equal(message.Args[0].([]byte), c.want) {
+				t.Errorf("parsed %#v, want blob %v", message.Args, c.want)
+			}
+		})
+	}
+}
+
+func TestParseOSCPacketInvalid(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"no address", oscString("cue")},
+		{"unterminated", []byte("/cue")},
+		{"bad type tag", oscMessage("/cue", "i", oscInt32(1))},
+		{"unsupported type", oscMessage("/cue", ",z")},
+		{"missing int", oscMessage("/cue", ",i")},
+		{"short bundle", []byte("#bundle\x00\x00")},
+		{"negative element", append([]byte("#bundle\x00\x00\x00\x00\x00\x00\x00\x00\x01"), oscInt32(-4)...)},
+		{"bundle with negative blob", append(append([]byte("#bundle\x00\x00\x00\x00\x00\x00\x00\x00\x01"), oscInt32(12)...), oscMessage("/b", ",b", oscInt32(-2))...)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			messages, err := ParseOSCPacket(c.data)
+			if err == nil {
+				t.Errorf("parsed %#v, want an error", messages)
+			}
+		})
+	}
+}
+
+func TestParseOSCPacketBundle(t *testing.T) {
+	first := oscMessage("/a", ",i", oscInt32(1))
+	second := oscMessage("/b", ",s", oscString("x"))
+	data := []byte("#bundle\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	for _, element := range [][]byte{first, second} {
+		data = append(data, oscInt32(int32(len(element)))...)
+		data = append(data, element...)
+	}
+	messages, err := ParseOSCPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OSCMessage{
+		{Address: "/a", Args: []interface{}{int32(1)}},
+		{Address: "/b", Args: []interface{}{"x"}},
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("parsed %#v, want %#v", messages, want)
+	}
+}
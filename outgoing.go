@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Events sent to outgoing webhooks.
+const (
+	EventChannelCreated = "channel.created"
+	EventUsersInvited   = "users.invited"
+	EventServiceStarted = "service.started"
+	EventMessageSent    = "message.sent"
+)
+
+// Maximum time to wait between delivery attempts.
+const WebhookMaxBackoff = time.Minute * 10
+
+// Time to wait before the first retry, doubling with each attempt.
+var WebhookBackoff = time.Second
+
+// Payload sent to outgoing webhooks.
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Data sent with channel events.
+type ChannelEvent struct {
+	Channel     SlackChannels `json:"channel"`
+	Plan        Plans         `json:"plan"`
+	ServiceType ServiceTypes  `json:"service_type"`
+	Users       []string      `json:"users,omitempty"`
+}
+
+// Data sent when a service starts.
+type ServiceStartedEvent struct {
+	PlanTime    PlanTimes    `json:"plan_time"`
+	Plan        Plans        `json:"plan"`
+	ServiceType ServiceTypes `json:"service_type"`
+}
+
+// Data sent when a message is sent.
+type MessageEvent struct {
+	Organization string `json:"organization"`
+	Conversation string `json:"conversation"`
+	Notifier     string `json:"notifier"`
+	Message      string `json:"message"`
+	Plan         *Plans `json:"plan,omitempty"`
+}
+
+// Keep track of deliveries in progress, so the update can wait for them before exiting.
+var webhookDeliveries sync.WaitGroup
+
+// Check if a webhook is subscribed to an event.
+func (c OutgoingWebhookConfig) Subscribed(event string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign a payload with the webhook secret.
+func (c OutgoingWebhookConfig) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Find the configuration for a webhook URL.
+func OutgoingWebhookForURL(url string) *OutgoingWebhookConfig {
+	for i, c := range app.config.OutgoingWebhooks {
+		if c.URL == url {
+			return &app.config.OutgoingWebhooks[i]
+		}
+	}
+	return nil
+}
+
+// Send an event to outgoing webhooks subscribed to it.
+func DispatchEvent(event string, data interface{}) {
+	if len(app.config.OutgoingWebhooks) == 0 {
+		return
+	}
+
+	// Build the payload.
+	body, err := json.Marshal(WebhookPayload{
+		Event:     event,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		log.Println("Error encoding webhook payload:", err)
+		return
+	}
+
+	// Save a delivery for each subscribed webhook and deliver it.
+	for _, c := range app.config.OutgoingWebhooks {
+		if !c.Subscribed(event) {
+			continue
+		}
+		delivery := WebhookDeliveries{
+			URL:     c.URL,
+			Event:   event,
+			Payload: string(body),
+		}
+		app.db.Create(&delivery)
+		webhookDeliveries.Add(1)
+		go DeliverWebhook(delivery)
+	}
+}
+
+// Deliver a webhook, retrying with exponential backoff.
+func DeliverWebhook(delivery WebhookDeliveries) {
+	defer webhookDeliveries.Done()
+
+	// The webhook may have been removed from the configuration.
+	c := OutgoingWebhookForURL(delivery.URL)
+	if c == nil {
+		return
+	}
+
+	for delivery.Attempts < c.MaxAttempts {
+		// Wait before retrying.
+		if delivery.Attempts != 0 {
+			backoff := WebhookBackoff << uint(delivery.Attempts-1)
+			if backoff > WebhookMaxBackoff {
+				backoff = WebhookMaxBackoff
+			}
+			time.Sleep(backoff)
+		}
+		delivery.Attempts++
+
+		// Attempt delivery and save the result.
+		status, err := PostWebhook(c, delivery)
+		delivery.StatusCode = status
+		delivery.LastError = ""
+		if err != nil {
+			delivery.LastError = err.Error()
+		} else {
+			delivery.DeliveredAt = time.Now().UTC()
+		}
+		app.db.Save(&delivery)
+		if err == nil {
+			return
+		}
+		log.Println("Error delivering webhook:", delivery.URL, err)
+	}
+}
+
+// Post a webhook delivery to its URL.
+func PostWebhook(c *OutgoingWebhookConfig, delivery WebhookDeliveries) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", serviceName+"/"+serviceVersion)
+	req.Header.Set("X-Service-Notifications-Event", delivery.Event)
+	req.Header.Set("X-Service-Notifications-Delivery", strconv.FormatUint(delivery.ID, 10))
+	if c.Secret != "" {
+		req.Header.Set("X-Service-Notifications-Signature", c.Sign(body))
+	}
+
+	// Perform the request.
+	client := &http.Client{Timeout: time.Second * 30}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Resume deliveries which were not completed, such as when the service was stopped.
+func ResumeWebhookDeliveries() {
+	var pending []WebhookDeliveries
+	app.db.Where("delivered_at IS NULL OR delivered_at < ?", time.Unix(0, 0)).Find(&pending)
+	for _, delivery := range pending {
+		c := OutgoingWebhookForURL(delivery.URL)
+		if c == nil || delivery.Attempts >= c.MaxAttempts {
+			continue
+		}
+		webhookDeliveries.Add(1)
+		go DeliverWebhook(delivery)
+	}
+}
+
+// Wait for webhook deliveries in progress to finish.
+func WaitForWebhooks() {
+	webhookDeliveries.Wait()
+}
+
+// Send service started events for services which have started.
+func DispatchServiceStarted() {
+	now := time.Now().UTC()
+	var planTimes []PlanTimes
+	app.db.Where("time_type='service' AND starts_at > ? AND starts_at <= ?", now.Add(-CountdownGracePeriod), now).Find(&planTimes)
+	for _, planTime := range planTimes {
+		// Skip if already sent.
+		var sent SentNotifications
+		app.db.Where("plan_time = ? AND notification = ?", planTime.ID, EventServiceStarted).First(&sent)
+		if sent.ID != 0 {
+			continue
+		}
+
+		// Send the event.
+		var plan Plans
+		app.db.Where("id = ?", planTime.Plan).First(&plan)
+		var serviceType ServiceTypes
+		app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+		DispatchEvent(EventServiceStarted, ServiceStartedEvent{
+			PlanTime:    planTime,
+			Plan:        plan,
+			ServiceType: serviceType,
+		})
+
+		// Record it was sent so restarts do not send it again.
+		app.db.Create(&SentNotifications{
+			PlanTime:     planTime.ID,
+			Notification: EventServiceStarted,
+			SentAt:       now,
+		})
+	}
+}
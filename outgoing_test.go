@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A webhook receiver failing a number of requests before accepting them.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newWebhookReceiver(t *testing.T, failures int) (*webhookReceiver, string) {
+	receiver := &webhookReceiver{failures: failures}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, string(body))
+		if len(receiver.requests) <= receiver.failures {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(s.Close)
+	backoff := WebhookBackoff
+	WebhookBackoff = time.Millisecond
+	t.Cleanup(func() { WebhookBackoff = backoff })
+	return receiver, s.URL
+}
+
+func TestDeliverWebhookRetries(t *testing.T) {
+	newTestApp(t)
+	receiver, url := newWebhookReceiver(t, 2)
+	app.config.OutgoingWebhooks = []OutgoingWebhookConfig{{URL: url, Secret: "secret", MaxAttempts: 5}}
+
+	// Failed deliveries are retried until accepted.
+	DispatchEvent(EventMessageSent, MessageEvent{Conversation: "C1", Message: "hello"})
+	WaitForWebhooks()
+	var delivery WebhookDeliveries
+	app.db.First(&delivery)
+	if len(receiver.requests) != 3 || delivery.Attempts != 3 || delivery.StatusCode != http.StatusOK || delivery.LastError != "" || delivery.DeliveredAt.IsZero() {
+		t.Fatalf("requested %d times and saved %+v, want delivered on the third attempt", len(receiver.requests), delivery)
+	}
+
+	// Each attempt is signed with the secret and identifies the delivery.
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(delivery.Payload))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	for i, r := range receiver.requests {
+		if r.Header.Get("X-Service-Notifications-Signature") != signature || receiver.bodies[i] != delivery.Payload {
+			t.Errorf("attempt %d signed %q, want %q", i+1, r.Header.Get("X-Service-Notifications-Signature"), signature)
+		}
+		if r.Header.Get("X-Service-Notifications-Event") != EventMessageSent || r.Header.Get("X-Service-Notifications-Delivery") != "1" {
+			t.Errorf("attempt %d sent headers %v", i+1, r.Header)
+		}
+	}
+	var payload WebhookPayload
+	json.Unmarshal([]byte(delivery.Payload), &payload)
+	if payload.Event != EventMessageSent || payload.Data.(map[string]interface{})["message"] != "hello" {
+		t.Errorf("sent payload %s", delivery.Payload)
+	}
+}
+
+func TestDeliverWebhookGivesUp(t *testing.T) {
+	newTestApp(t)
+	receiver, url := newWebhookReceiver(t, 10)
+	app.config.OutgoingWebhooks = []OutgoingWebhookConfig{{URL: url, MaxAttempts: 2}}
+
+	// Deliveries stop after the maximum attempts, recording the last error.
+	DispatchEvent(EventChannelCreated, ChannelEvent{})
+	WaitForWebhooks()
+	var delivery WebhookDeliveries
+	app.db.First(&delivery)
+	if len(receiver.requests) != 2 || delivery.Attempts != 2 || delivery.StatusCode != http.StatusInternalServerError || delivery.LastError == "" || !delivery.DeliveredAt.IsZero() {
+		t.Fatalf("requested %d times and saved %+v, want failed after two attempts", len(receiver.requests), delivery)
+	}
+
+	// Without a secret, deliveries are not signed.
+	if signature := receiver.requests[0].Header.Get("X-Service-Notifications-Signature"); signature != "" {
+		t.Errorf("signed %q without a secret", signature)
+	}
+
+	// They are not resumed once the attempts are used up, but are if more are allowed.
+	ResumeWebhookDeliveries()
+	WaitForWebhooks()
+	app.config.OutgoingWebhooks[0].MaxAttempts = 3
+	ResumeWebhookDeliveries()
+	WaitForWebhooks()
+	app.db.First(&delivery)
+	if len(receiver.requests) != 3 || delivery.Attempts != 3 {
+		t.Errorf("requested %d times and saved %+v after resuming, want one more attempt", len(receiver.requests), delivery)
+	}
+}
+
+func TestDispatchEventSubscriptions(t *testing.T) {
+	newTestApp(t)
+	receiver, url := newWebhookReceiver(t, 0)
+	app.config.OutgoingWebhooks = []OutgoingWebhookConfig{{URL: url, Events: []string{EventUsersInvited}, MaxAttempts: 1}}
+
+	// Only subscribed events are sent.
+	DispatchEvent(EventMessageSent, MessageEvent{})
+	DispatchEvent(EventUsersInvited, ChannelEvent{Users: []string{"U1"}})
+	WaitForWebhooks()
+	if len(receiver.requests) != 1 || receiver.requests[0].Header.Get("X-Service-Notifications-Event") != EventUsersInvited {
+		t.Errorf("requested %d times, want only the subscribed event", len(receiver.requests))
+	}
+}
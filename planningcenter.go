@@ -1,31 +1,45 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Make an API request to Planning Center.
-func NewPCRequest(uri string) (*http.Request, error) {
+// URL of the Planning Center API, which request URIs are relative to.
+var PCBaseURL = "https://api.planningcenteronline.com"
+
+// Make an API request to the Planning Center account of an organization.
+func NewPCRequest(org *Org, uri string) (*http.Request, error) {
+	return NewPCRequestWithBody(org, http.MethodGet, uri, nil)
+}
+
+// Make an API request to the Planning Center account of an organization with a method and body.
+func NewPCRequestWithBody(org *Org, method, uri string, body io.Reader) (*http.Request, error) {
 	url := uri
 	// If request URI doesn't include full URL, prepend the PC API URL.
 	if !strings.HasPrefix(url, "http") {
-		url = "https://api.planningcenteronline.com" + uri
+		url = PCBaseURL + uri
 	}
 	// Make the request.
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	// Append the basic authentication from the configuration.
-	auth := app.config.PlanningCenter.AppID + ":" + app.config.PlanningCenter.Secret
+	auth := org.PlanningCenter.AppID + ":" + org.PlanningCenter.Secret
 	authString := base64.StdEncoding.EncodeToString([]byte(auth))
 	req.Header.Add("Authorization", "Basic "+authString)
 
@@ -88,7 +102,7 @@ func PCParseResponse(body io.Reader) (*PCResponse, error) {
 	}
 	// If an error was provided from the API, return it.
 	if len(res.Errors) != 0 {
-		return nil, fmt.Errorf(res.Errors[0].Detail)
+		return nil, errors.New(res.Errors[0].Detail)
 	}
 	// We expect result to be provided on a valid response.
 	if res.Data == nil {
@@ -99,7 +113,7 @@ func PCParseResponse(body io.Reader) (*PCResponse, error) {
 }
 
 // Query Planning Center API and get data from all pages.
-func PCGetAll(uri string) ([]PCDict, error) {
+func PCGetAll(org *Org, uri string) ([]PCDict, error) {
 	// The data array to store all found data.
 	var data []PCDict
 
@@ -108,7 +122,7 @@ func PCGetAll(uri string) ([]PCDict, error) {
 	// Make requests until the last page was loaded.
 	for {
 		// Make the request.
-		req, err := NewPCRequest(url)
+		req, err := NewPCRequest(org, url)
 		if err != nil {
 			return nil, err
 		}
@@ -142,6 +156,92 @@ func PCGetAll(uri string) ([]PCDict, error) {
 	return data, nil
 }
 
+// Query Planning Center API for a single resource.
+func PCGet(org *Org, uri string) (PCDict, error) {
+	// Make the request.
+	req, err := NewPCRequest(org, uri)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// Parse the response, which has one resource as its data.
+	var response struct {
+		Data   PCDict    `json:"data"`
+		Errors []PCError `json:"errors"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Errors) != 0 {
+		return nil, errors.New(response.Errors[0].Detail)
+	}
+	if response.Data == nil {
+		return nil, fmt.Errorf("no data in response")
+	}
+	return response.Data, nil
+}
+
+// Post data to the Planning Center API.
+func PCPost(org *Org, uri string, data interface{}) error {
+	// Encode the data as JSON.
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// Make the request.
+	req, err := NewPCRequestWithBody(org, http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// Perform the request.
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// If the request was successful, we're done.
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	// Otherwise, try to parse an error from the response.
+	response := new(PCResponse)
+	err = json.NewDecoder(res.Body).Decode(response)
+	if err == nil && len(response.Errors) != 0 {
+		return errors.New(response.Errors[0].Detail)
+	}
+	return fmt.Errorf("unexpected status: %s", res.Status)
+}
+
+// Get the IDs of resources from a planning center link, keyed by the resource name.
+// For example, /services/v2/service_types/1/plans/2 returns service_types: 1 and plans: 2.
+func PCLinkIDs(link string) map[string]uint64 {
+	ids := make(map[string]uint64)
+	u, err := url.Parse(link)
+	if err != nil {
+		return ids
+	}
+
+	// Each ID follows the name of the resource in the path.
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		id, err := strconv.ParseUint(parts[i], 10, 64)
+		if err == nil {
+			ids[parts[i-1]] = id
+		}
+	}
+	return ids
+}
+
 // Below are a bunch of helper functions.
 // I would recommend using a tool like Insomnia to test API requests,
 // then you will know what the data structure is like for an API request.
@@ -173,6 +273,9 @@ func (p PCDict) GetUint64(key string) uint64 {
 	// Try parsing a string if its ok.
 	if ok {
 		i, _ = strconv.ParseUint(s, 10, 64)
+	} else if f, ok := p[key].(float64); ok {
+		// JSON numbers are decoded as floats.
+		i = uint64(f)
 	} else {
 		// Otherwise, try converting to an integer.
 		i, ok = p[key].(uint64)
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Maximum time to wait before reconnecting to ProPresenter.
+const ProPresenterMaxBackoff = time.Minute
+
+// Identifier of a ProPresenter presentation.
+type ProPresenterID struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+// Current slide index response.
+type ProPresenterSlideIndex struct {
+	PresentationIndex *struct {
+		Index          int            `json:"index"`
+		PresentationID ProPresenterID `json:"presentation_id"`
+	} `json:"presentation_index"`
+}
+
+// A slide in a presentation.
+type ProPresenterSlide struct {
+	Enabled bool   `json:"enabled"`
+	Label   string `json:"label"`
+	Text    string `json:"text"`
+	Notes   string `json:"notes"`
+}
+
+// Active presentation response.
+type ProPresenterPresentation struct {
+	Presentation struct {
+		ID     ProPresenterID `json:"id"`
+		Groups []struct {
+			Name   string              `json:"name"`
+			Slides []ProPresenterSlide `json:"slides"`
+		} `json:"groups"`
+	} `json:"presentation"`
+}
+
+// Data available to ProPresenter message templates.
+type ProPresenterData struct {
+	Presentation string
+	SlideIndex   int
+	SlideLabel   string
+	SlideText    string
+	Group        string
+}
+
+// A rule with its compiled expressions.
+type proPresenterRule struct {
+	ProPresenterRuleConfig
+	presentation *regexp.Regexp
+	slideLabel   *regexp.Regexp
+}
+
+// A client watching the current ProPresenter slide.
+type ProPresenterClient struct {
+	config *ProPresenterConfig
+	rules  []proPresenterRule
+	client *http.Client
+
+	// The current presentation, and the last slide seen so rules only fire on change.
+	presentation ProPresenterPresentation
+	lastUUID     string
+	lastIndex    int
+}
+
+// Create a ProPresenter client from the configuration.
+func NewProPresenterClient(config *ProPresenterConfig) *ProPresenterClient {
+	c := &ProPresenterClient{
+		config:    config,
+		client:    &http.Client{},
+		lastIndex: -1,
+	}
+
+	// Compile the rule expressions.
+	for _, rule := range config.Rules {
+		r := proPresenterRule{ProPresenterRuleConfig: rule}
+		var err error
+		if rule.Presentation != "" {
+			r.presentation, err = regexp.Compile(rule.Presentation)
+			if err != nil {
+				log.Fatalln("Invalid ProPresenter presentation expression:", err)
+			}
+		}
+		if rule.SlideLabel != "" {
+			r.slideLabel, err = regexp.Compile(rule.SlideLabel)
+			if err != nil {
+				log.Fatalln("Invalid ProPresenter slide label expression:", err)
+			}
+		}
+		c.rules = append(c.rules, r)
+	}
+	return c
+}
+
+// Make a request to the ProPresenter API.
+func (c *ProPresenterClient) Get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.config.URL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return res, nil
+}
+
+// Get a JSON response from the ProPresenter API.
+func (c *ProPresenterClient) GetJSON(ctx context.Context, path string, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	res, err := c.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Check if a rule matches a slide.
+func (r proPresenterRule) Matches(data ProPresenterData) bool {
+	if r.presentation != nil && !r.presentation.MatchString(data.Presentation) {
+		return false
+	}
+	if r.slideLabel != nil && !r.slideLabel.MatchString(data.SlideLabel) {
+		return false
+	}
+	if r.SlideIndex != nil && *r.SlideIndex != data.SlideIndex {
+		return false
+	}
+	return true
+}
+
+// Handle a change of the current slide by firing rules that match it.
+func (c *ProPresenterClient) HandleSlideIndex(ctx context.Context, index ProPresenterSlideIndex) error {
+	// Nothing is presented.
+	if index.PresentationIndex == nil {
+		c.lastUUID, c.lastIndex = "", -1
+		return nil
+	}
+	id := index.PresentationIndex.PresentationID
+	slideIndex := index.PresentationIndex.Index
+	if id.UUID == c.lastUUID && slideIndex == c.lastIndex {
+		return nil
+	}
+
+	// Get the slides when the presentation changes.
+	if id.UUID != c.presentation.Presentation.ID.UUID {
+		var presentation ProPresenterPresentation
+		err := c.GetJSON(ctx, "/v1/presentation/active", &presentation)
+		if err != nil {
+			return err
+		}
+		c.presentation = presentation
+	}
+	c.lastUUID, c.lastIndex = id.UUID, slideIndex
+
+	// Find the slide, with indexes counting across groups.
+	data := ProPresenterData{
+		Presentation: id.Name,
+		SlideIndex:   slideIndex,
+	}
+	i := 0
+	for _, group := range c.presentation.Presentation.Groups {
+		for _, slide := range group.Slides {
+			if i == slideIndex {
+				data.SlideLabel = slide.Label
+				data.SlideText = slide.Text
+				data.Group = group.Name
+			}
+			i++
+		}
+	}
+
+	// Fire matching rules.
+	for _, rule := range c.rules {
+		if rule.Matches(data) {
+			FireTrigger(OrgOrDefault(c.config.Organization), ActorProPresenter, rule.Message, rule.Priority, rule.Positions, data)
+		}
+	}
+	return nil
+}
+
+// Stream slide changes until the connection fails.
+func (c *ProPresenterClient) Stream(ctx context.Context) error {
+	res, err := c.Get(ctx, "/v1/presentation/slide_index?chunked=true")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Each update is a JSON object, separated by blank lines.
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var index ProPresenterSlideIndex
+		err = decoder.Decode(&index)
+		if err != nil {
+			return err
+		}
+		err = c.HandleSlideIndex(ctx, index)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Poll for slide changes until a request fails.
+func (c *ProPresenterClient) Poll(ctx context.Context) error {
+	ticker := time.NewTicker(c.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		var index ProPresenterSlideIndex
+		err := c.GetJSON(ctx, "/v1/presentation/slide_index", &index)
+		if err != nil {
+			return err
+		}
+		err = c.HandleSlideIndex(ctx, index)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Watch the current slide, reconnecting with backoff until the context is done.
+func (c *ProPresenterClient) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		var err error
+		if c.config.Mode == "poll" {
+			err = c.Poll(ctx)
+		} else {
+			err = c.Stream(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("ProPresenter connection error:", err)
+
+		// Reset the backoff if the connection was up for a while.
+		if time.Since(started) > ProPresenterMaxBackoff {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > ProPresenterMaxBackoff {
+			backoff = ProPresenterMaxBackoff
+		}
+	}
+}
+
+// Start watching ProPresenter in the background.
+func (c *ProPresenterClient) Start(ctx context.Context) {
+	log.Println("Watching ProPresenter:", c.config.URL)
+	go c.Run(ctx)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fake ProPresenter network API serving the current slide by polling or streaming.
+type fakeProPresenter struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	presentation ProPresenterPresentation
+	current      ProPresenterSlideIndex
+	streams      []chan ProPresenterSlideIndex
+	connections  int // Requests for the slide index.
+	activeGets   int // Requests for the active presentation.
+	failPolls    int // Number of polls to fail.
+}
+
+// Start a fake ProPresenter with a presentation of two groups.
+func newFakeProPresenter(t *testing.T) *fakeProPresenter {
+	f := &fakeProPresenter{}
+	f.presentation.Presentation.ID = ProPresenterID{UUID: "P1", Name: "Sunday"}
+	f.presentation.Presentation.Groups = []struct {
+		Name   string              `json:"name"`
+		Slides []ProPresenterSlide `json:"slides"`
+	}{
+		{Name: "Intro", Slides: []ProPresenterSlide{{Label: "Welcome", Text: "Welcome!"}, {Label: "Announcements"}}},
+		{Name: "Message", Slides: []ProPresenterSlide{{Label: "Sermon", Text: "Part one"}}},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/presentation/active", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.activeGets++
+		presentation := f.presentation
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(presentation)
+	})
+	mux.HandleFunc("/v1/presentation/slide_index", f.handleSlideIndex)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		f.Drop()
+		f.Close()
+	})
+	return f
+}
+
+// Serve the current slide index, streaming changes if chunked.
+func (f *fakeProPresenter) handleSlideIndex(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.connections++
+	current := f.current
+	if r.URL.Query().Get("chunked") != "true" {
+		fail := f.failPolls > 0
+		if fail {
+			f.failPolls--
+		}
+		f.mu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(current)
+		return
+	}
+	updates := make(chan ProPresenterSlideIndex, 10)
+	f.streams = append(f.streams, updates)
+	f.mu.Unlock()
+
+	// Send the current slide on connect, then each change.
+	flusher := w.(http.Flusher)
+	write := func(index ProPresenterSlideIndex) {
+		json.NewEncoder(w).Encode(index)
+		w.Write([]byte("\r\n"))
+		flusher.Flush()
+	}
+	write(current)
+	for {
+		select {
+		case index, ok := <-updates:
+			if !ok {
+				return
+			}
+			write(index)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Change the current slide, notifying streams.
+func (f *fakeProPresenter) Show(index int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.current = ProPresenterSlideIndex{}
+	f.current.PresentationIndex = &struct {
+		Index          int            `json:"index"`
+		PresentationID ProPresenterID `json:"presentation_id"`
+	}{Index: index, PresentationID: f.presentation.Presentation.ID}
+	for _, updates := range f.streams {
+		updates <- f.current
+	}
+}
+
+// Drop the streams which are connected.
+func (f *fakeProPresenter) Drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, updates := range f.streams {
+		close(updates)
+	}
+	f.streams = nil
+}
+
+// Wait for a number of slide index requests.
+func (f *fakeProPresenter) WaitForConnections(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		connections := f.connections
+		f.mu.Unlock()
+		if connections >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections, want %d", connections, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Rules used by the tests.
+func proPresenterTestConfig(url, mode string) *ProPresenterConfig {
+	sermon := 2
+	return &ProPresenterConfig{
+		URL:          url,
+		Mode:         mode,
+		PollInterval: 20 * time.Millisecond,
+		Rules: []ProPresenterRuleConfig{
+			{Presentation: "^Sunday$", SlideLabel: "^Welcome$", Message: "{{.Presentation}}: {{.SlideText}}"},
+			{SlideIndex: &sermon, Message: "{{.Group}} started with {{.SlideLabel}}"},
+			{Presentation: "^Wedding$", Message: "Never sent"},
+		},
+	}
+}
+
+// Run a client until the test ends, waiting for it to stop so it does not use the app of later tests.
+func proPresenterTestRun(t *testing.T, config *ProPresenterConfig) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewProPresenterClient(config).Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestProPresenterStream(t *testing.T) {
+	notifier := newTestApp(t)
+	f := newFakeProPresenter(t)
+	proPresenterTestRun(t, proPresenterTestConfig(f.URL, "stream"))
+	f.WaitForConnections(t, 1)
+
+	// Repeated slides only fire once, and slides without matching rules do not fire.
+	f.Show(0)
+	f.Show(0)
+	f.Show(1)
+	f.Show(2)
+	want := []recordedPost{
+		{"C_ADMIN", "Sunday: Welcome!"},
+		{"C_ADMIN", "Message started with Sermon"},
+	}
+	posts := notifier.WaitForPosts(t, len(want))
+	if !reflect.DeepEqual(posts, want) {
+		t.Fatalf("posted %+v, want %+v", posts, want)
+	}
+
+	// After the stream drops the client reconnects, and the current slide sent on connect does not fire again.
+	f.Drop()
+	f.WaitForConnections(t, 2)
+	f.Show(0)
+	want = append(want, recordedPost{"C_ADMIN", "Sunday: Welcome!"})
+	posts = notifier.WaitForPosts(t, len(want))
+	if !reflect.DeepEqual(posts, want) {
+		t.Errorf("posted %+v, want %+v", posts, want)
+	}
+
+	// The presentation is only fetched when it changes.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.activeGets != 1 {
+		t.Errorf("fetched the active presentation %d times, want 1", f.activeGets)
+	}
+}
+
+func TestProPresenterPoll(t *testing.T) {
+	notifier := newTestApp(t)
+	f := newFakeProPresenter(t)
+	proPresenterTestRun(t, proPresenterTestConfig(f.URL, "poll"))
+
+	f.Show(0)
+	notifier.WaitForPosts(t, 1)
+	f.WaitForConnections(t, 3)
+
+	// A failed poll reconnects, and polling continues.
+	f.mu.Lock()
+	f.failPolls = 1
+	connections := f.connections
+	f.mu.Unlock()
+	f.WaitForConnections(t, connections+2)
+	f.Show(2)
+	want := []recordedPost{
+		{"C_ADMIN", "Sunday: Welcome!"},
+		{"C_ADMIN", "Message started with Sermon"},
+	}
+	posts := notifier.WaitForPosts(t, len(want))
+	if !reflect.DeepEqual(posts, want) {
+		t.Errorf("posted %+v, want %+v", posts, want)
+	}
+}
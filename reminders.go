@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Kinds of reminders kept in the reminder log.
+const (
+	ReminderDigest = "digest"
+	ReminderNudge  = "nudge"
+)
+
+// Check if a time is within the quiet hours configured for an organization.
+func InQuietHours(org *Org, t time.Time) bool {
+	start, end := org.Reminders.QuietHoursStart, org.Reminders.QuietHoursEnd
+	if start == end {
+		return false
+	}
+	hour := t.Local().Hour()
+	// Quiet hours within the same day.
+	if start < end {
+		return hour >= start && hour < end
+	}
+	// Quiet hours spanning midnight.
+	return hour >= start || hour < end
+}
+
+// Check if a reminder of an organization is due based on when it was last sent.
+func ReminderDue(org *Org, kind string, ref uint64, interval time.Duration, now time.Time) bool {
+	var last ReminderLogs
+	app.db.Where("org = ? AND kind = ? AND ref = ?", org.Name, kind, ref).Order("sent_at DESC").First(&last)
+	return last.ID == 0 || now.Sub(last.SentAt) >= interval
+}
+
+// Record that a reminder of an organization was sent.
+func ReminderSent(org *Org, kind string, ref uint64, now time.Time) {
+	app.db.Create(&ReminderLogs{
+		Org:    org.Name,
+		Kind:   kind,
+		Ref:    ref,
+		SentAt: now.UTC(),
+	})
+}
+
+// Check if reminders are enabled for any organization.
+func RemindersEnabled() bool {
+	for _, org := range app.orgs {
+		if org.Reminders.CoordinatorChannel != "" || org.Reminders.NudgeUnconfirmed {
+			return true
+		}
+	}
+	return false
+}
+
+// Send reminder digests and nudges that are due for each organization.
+func SendReminders() {
+	now := time.Now()
+	for _, org := range app.orgs {
+		if InQuietHours(org, now) {
+			continue
+		}
+
+		// Digests are only sent on the configured weekdays.
+		if org.Reminders.CoordinatorChannel != "" {
+			sendToday := len(org.Reminders.Weekdays) == 0
+			for _, weekday := range org.Reminders.Weekdays {
+				if time.Weekday(weekday) == now.Local().Weekday() {
+					sendToday = true
+				}
+			}
+			if sendToday {
+				SendReminderDigests(org, now)
+			}
+		}
+
+		// Nudge unconfirmed people.
+		if org.Reminders.NudgeUnconfirmed {
+			NudgeUnconfirmed(org, now)
+		}
+	}
+}
+
+// Get the upcoming plans of an organization within the create channels ahead window.
+func UpcomingPlanIDs(org *Org, now time.Time) []uint64 {
+	var planIDs []uint64
+	app.db.Model(&PlanTimes{}).Where("org = ? AND time_type='service' AND starts_at > ? AND starts_at < ?", org.Name, now.UTC(), now.UTC().Add(org.Slack.CreateChannelsAhead)).Distinct().Pluck("plan", &planIDs)
+	return planIDs
+}
+
+// Build the digest of unconfirmed, declined and unfilled positions for a plan.
+func PlanDigest(plan Plans) string {
+	var b strings.Builder
+
+	// List people who have not confirmed.
+	var people []PlanPeople
+	app.db.Where("plan = ? AND status IN ?", plan.ID, []string{"U", "D"}).Order("team_position_name ASC").Find(&people)
+	for _, person := range people {
+		fmt.Fprintf(&b, "• %s: %s (%s)\n", person.TeamPositionName, PersonDisplayName(plan.Org, person.Person), PlanPeopleStatusNames[person.Status])
+	}
+
+	// List positions that still need filled.
+	var needed []NeededPositions
+	app.db.Where("plan = ?", plan.ID).Order("team_position_name ASC").Find(&needed)
+	for _, position := range needed {
+		fmt.Fprintf(&b, "• %s: %d needed\n", position.TeamPositionName, position.Quantity)
+	}
+	return b.String()
+}
+
+// Post a digest for each service type of an organization to its coordinator channel.
+func SendReminderDigests(org *Org, now time.Time) {
+	// Find upcoming plans.
+	planIDs := UpcomingPlanIDs(org, now)
+	if len(planIDs) == 0 {
+		return
+	}
+	var plans []Plans
+	app.db.Where("id IN ?", planIDs).Order("first_time_at ASC").Find(&plans)
+
+	// Group plans by service type.
+	var serviceTypeIDs []uint64
+	plansByType := make(map[uint64][]Plans)
+	for _, plan := range plans {
+		if _, ok := plansByType[plan.ServiceType]; !ok {
+			serviceTypeIDs = append(serviceTypeIDs, plan.ServiceType)
+		}
+		plansByType[plan.ServiceType] = append(plansByType[plan.ServiceType], plan)
+	}
+
+	// Send a digest for each service type that is due.
+	for _, serviceTypeID := range serviceTypeIDs {
+		if !ReminderDue(org, ReminderDigest, serviceTypeID, org.Reminders.Interval, now) {
+			continue
+		}
+		var serviceType ServiceTypes
+		app.db.Where("id = ?", serviceTypeID).First(&serviceType)
+
+		// Build the digest for each plan.
+		var b strings.Builder
+		fmt.Fprintf(&b, "*Positions needing attention for %s*\n", serviceType.Name)
+		for _, plan := range plansByType[serviceTypeID] {
+			digest := PlanDigest(plan)
+			if digest == "" {
+				digest = "All positions are confirmed.\n"
+			}
+			fmt.Fprintf(&b, "\n*%s* - %s\n%s", PlanTopic(serviceType, plan), SlackDate(plan.FirstTimeAt), digest)
+		}
+
+		// Post the digest.
+		err := NotifierAs(org, SlackNotifierName, ActorScheduler).PostMessage(org.Reminders.CoordinatorChannel, b.String())
+		if err != nil {
+			log.Println("Failed to send reminder digest:", err)
+			continue
+		}
+		ReminderSent(org, ReminderDigest, serviceTypeID, now)
+	}
+}
+
+// Send a direct message to unconfirmed people of an organization reminding them to respond.
+func NudgeUnconfirmed(org *Org, now time.Time) {
+	// Find upcoming plans.
+	planIDs := UpcomingPlanIDs(org, now)
+	if len(planIDs) == 0 {
+		return
+	}
+
+	// Nudges are sent as direct messages, which only some notifiers support.
+	notifier, ok := NotifierAs(org, SlackNotifierName, ActorScheduler).(InteractiveNotifier)
+	if !ok {
+		return
+	}
+
+	// Find people who have not confirmed.
+	var unconfirmed []PlanPeople
+	app.db.Where("plan IN ? AND status = 'U'", planIDs).Find(&unconfirmed)
+	for _, planPerson := range unconfirmed {
+		if !ReminderDue(org, ReminderNudge, planPerson.ID, org.Reminders.NudgeInterval, now) {
+			continue
+		}
+
+		// Only people matched to slack in the organization can be nudged.
+		var slackUser SlackUsers
+		app.db.Where("org = ? AND pc_id = ?", org.Name, planPerson.Person).First(&slackUser)
+		if slackUser.ID == "" {
+			continue
+		}
+
+		// Remind them to respond, pointing to the scheduling request if one was sent.
+		text := "Reminder: " + ScheduleRequestText(planPerson)
+		if planPerson.RequestMessageTS != "" {
+			text += " Please accept or decline using the buttons above."
+		} else {
+			text += " Please accept or decline in Planning Center."
+		}
+		_, _, err := notifier.PostDirectMessage(slackUser.ID, text)
+		if err != nil {
+			log.Println("Failed to send reminder:", err)
+			continue
+		}
+		ReminderSent(org, ReminderNudge, planPerson.ID, now)
+	}
+}
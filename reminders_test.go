@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Setup reminders for a plan with an unconfirmed person, matched to the Slack user U5.
+func remindersTestApp(t *testing.T) (*recordingNotifier, *Org) {
+	notifier := newTestApp(t)
+	app.config.Slack.CreateChannelsAhead = 7 * 24 * time.Hour
+	app.config.Reminders = RemindersConfig{
+		CoordinatorChannel: "C_COORD",
+		Interval:           24 * time.Hour,
+		NudgeUnconfirmed:   true,
+		NudgeInterval:      24 * time.Hour,
+	}
+	createTestPlan(t, 10, 5)
+	app.db.Model(&PlanPeople{}).Where("id = ?", 1000).Update("status", "U")
+	app.db.Create(&SlackUsers{ID: "U5", Org: DefaultOrgName, PCID: 5})
+	return notifier, app.orgs[0]
+}
+
+// Count the posts to each conversation, taking them from the notifier.
+func remindersTestPosts(notifier *recordingNotifier) map[string]int {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	counts := make(map[string]int)
+	for _, post := range notifier.posts {
+		counts[post.Conversation]++
+	}
+	notifier.posts = nil
+	return counts
+}
+
+func TestInQuietHours(t *testing.T) {
+	for _, c := range []struct {
+		start, end int
+		hour       int
+		quiet      bool
+	}{
+		{0, 0, 3, false},
+		{9, 17, 8, false},
+		{9, 17, 9, true},
+		{9, 17, 16, true},
+		{9, 17, 17, false},
+		{22, 6, 21, false},
+		{22, 6, 22, true},
+		{22, 6, 0, true},
+		{22, 6, 5, true},
+		{22, 6, 6, false},
+	} {
+		org := &Org{Reminders: &RemindersConfig{QuietHoursStart: c.start, QuietHoursEnd: c.end}}
+		at := time.Date(2030, 1, 1, c.hour, 30, 0, 0, time.Local)
+		if InQuietHours(org, at) != c.quiet {
+			t.Errorf("quiet hours %d to %d at %d:30 is %v, want %v", c.start, c.end, c.hour, !c.quiet, c.quiet)
+		}
+	}
+}
+
+func TestReminderCadence(t *testing.T) {
+	notifier, org := remindersTestApp(t)
+	now := time.Now()
+
+	// Digests and nudges are sent once per interval.
+	for _, c := range []struct {
+		after time.Duration
+		sent  int
+	}{
+		{0, 1},
+		{time.Hour, 0},
+		{23 * time.Hour, 0},
+		{25 * time.Hour, 1},
+		{26 * time.Hour, 0},
+	} {
+		SendReminderDigests(org, now.Add(c.after))
+		NudgeUnconfirmed(org, now.Add(c.after))
+		posts := remindersTestPosts(notifier)
+		if posts["C_COORD"] != c.sent || posts["DU5"] != c.sent {
+			t.Errorf("after %s posted %v, want %d of each", c.after, posts, c.sent)
+		}
+	}
+
+	// They are recorded as sent by the scheduler.
+	events, _ := QueryAuditEvents(AuditFilter{Actor: ActorScheduler})
+	if len(events) != 4 {
+		t.Errorf("recorded %d events, want 4", len(events))
+	}
+
+	// Reminders of other organizations with the same IDs are not held back.
+	north := &Org{Name: "north", Reminders: org.Reminders}
+	if !ReminderDue(north, ReminderDigest, 1, 24*time.Hour, now) {
+		t.Error("digest of another organization is not due")
+	}
+	if ReminderDue(org, ReminderDigest, 1, 24*time.Hour, now.Add(26*time.Hour)) {
+		t.Error("digest is due again")
+	}
+}
+
+func TestSendRemindersSchedule(t *testing.T) {
+	notifier, org := remindersTestApp(t)
+	now := time.Now()
+
+	// Nothing is sent during quiet hours.
+	org.Reminders.QuietHoursStart = now.Hour()
+	org.Reminders.QuietHoursEnd = (now.Hour() + 1) % 24
+	SendReminders()
+	if posts := remindersTestPosts(notifier); len(posts) != 0 {
+		t.Errorf("posted %v during quiet hours", posts)
+	}
+
+	// Digests are only sent on the listed weekdays, nudges on any day.
+	org.Reminders.QuietHoursStart, org.Reminders.QuietHoursEnd = 0, 0
+	org.Reminders.Weekdays = []int{int(now.Add(24 * time.Hour).Weekday())}
+	SendReminders()
+	if posts := remindersTestPosts(notifier); posts["C_COORD"] != 0 || posts["DU5"] != 1 {
+		t.Errorf("posted %v on another weekday, want only the nudge", posts)
+	}
+	org.Reminders.Weekdays = append(org.Reminders.Weekdays, int(now.Weekday()))
+	SendReminders()
+	if posts := remindersTestPosts(notifier); posts["C_COORD"] != 1 || posts["DU5"] != 0 {
+		t.Errorf("posted %v on a listed weekday, want only the digest", posts)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// A job which runs periodically in the background while the server is running.
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func()
+}
+
+// Get the jobs that are enabled by the configuration.
+func ScheduledJobs() []ScheduledJob {
+	var jobs []ScheduledJob
+
+	// Reminder digests and nudges.
+	if RemindersEnabled() {
+		jobs = append(jobs, ScheduledJob{
+			Name:     "reminders",
+			Interval: time.Minute,
+			Run:      SendReminders,
+		})
+	}
+
+	// Countdown notifications relative to plan times.
+	if len(app.config.Countdowns) != 0 {
+		jobs = append(jobs, ScheduledJob{
+			Name:     "countdowns",
+			Interval: time.Second * 30,
+			Run:      SendCountdowns,
+		})
+	}
+
+	// Service started events for outgoing webhooks.
+	if len(app.config.OutgoingWebhooks) != 0 {
+		jobs = append(jobs, ScheduledJob{
+			Name:     "service started events",
+			Interval: time.Second * 30,
+			Run:      DispatchServiceStarted,
+		})
+	}
+
+	return jobs
+}
+
+// Start running scheduled jobs in the background until the context is done.
+func StartScheduler(ctx context.Context, jobs []ScheduledJob) {
+	for _, job := range jobs {
+		go func(job ScheduledJob) {
+			log.Println("Starting scheduled job:", job.Name)
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					job.Run()
+				}
+			}
+		}(job)
+	}
+}
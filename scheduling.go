@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Identifiers used in the scheduling request messages and modal.
+const (
+	ScheduleAcceptAction  = "schedule_accept"
+	ScheduleDeclineAction = "schedule_decline"
+	ScheduleDeclineView   = "schedule_decline"
+	ScheduleReasonBlock   = "decline_reason"
+	ScheduleReasonAction  = "reason"
+)
+
+// Build the text describing what a person was scheduled for.
+func ScheduleRequestText(planPerson PlanPeople) string {
+	var plan Plans
+	app.db.Where("id = ?", planPerson.Plan).First(&plan)
+	var serviceType ServiceTypes
+	app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+
+	// Find the first service time of the plan.
+	var planTime PlanTimes
+	app.db.Where("plan = ? AND time_type='service'", plan.ID).Order("starts_at ASC").First(&planTime)
+	when := ""
+	if planTime.ID != 0 {
+		when = " on " + SlackDate(planTime.StartsAt)
+	}
+	return fmt.Sprintf("You have been scheduled for *%s* for *%s*%s.", planPerson.TeamPositionName, PlanTopic(serviceType, plan), when)
+}
+
+// Send unconfirmed people of an organization a direct message asking them to accept or decline.
+func SendSchedulingRequests(org *Org) {
+	// Get the time frame channels exist for, we only ask about those services.
+	startDate, lastDate := SlackChannelWindow(org)
+
+	// Find plans with services in the time frame.
+	var planIDs []uint64
+	app.db.Model(&PlanTimes{}).Where("org = ? AND time_type='service' AND starts_at > ? AND starts_at < ?", org.Name, startDate, lastDate).Distinct().Pluck("plan", &planIDs)
+	if len(planIDs) == 0 {
+		return
+	}
+
+	// Requests are sent with buttons, which only some notifiers support.
+	notifier, ok := NotifierAs(org, SlackNotifierName, ActorCron).(InteractiveNotifier)
+	if !ok {
+		return
+	}
+
+	// Find unconfirmed people that were not already asked.
+	var unconfirmed []PlanPeople
+	app.db.Where("plan IN ? AND status = 'U' AND (request_channel IS NULL OR request_channel = '')", planIDs).Find(&unconfirmed)
+	for _, planPerson := range unconfirmed {
+		// Only people matched to slack in the organization can be asked.
+		var slackUser SlackUsers
+		app.db.Where("org = ? AND pc_id = ?", org.Name, planPerson.Person).First(&slackUser)
+		if slackUser.ID == "" {
+			continue
+		}
+
+		// Build the message with accept and decline buttons.
+		id := strconv.FormatUint(planPerson.ID, 10)
+		text := ScheduleRequestText(planPerson)
+		accept := slack.NewButtonBlockElement(ScheduleAcceptAction, id, slack.NewTextBlockObject(slack.PlainTextType, "Accept", false, false))
+		accept.Style = slack.StylePrimary
+		decline := slack.NewButtonBlockElement(ScheduleDeclineAction, id, slack.NewTextBlockObject(slack.PlainTextType, "Decline", false, false))
+		decline.Style = slack.StyleDanger
+		// Send the message as a direct message to the user.
+		channelID, ts, err := notifier.PostDirectMessage(slackUser.ID, text,
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text+" Can you serve?", false, false), nil, nil),
+			slack.NewActionBlock("schedule_"+id, accept, decline),
+		)
+		if err != nil {
+			log.Println("Failed to send scheduling request:", err)
+			continue
+		}
+
+		// Save that the request was sent so we do not ask again.
+		planPerson.RequestSentAt = time.Now().UTC()
+		planPerson.RequestChannel = channelID
+		planPerson.RequestMessageTS = ts
+		app.db.Save(&planPerson)
+	}
+}
+
+// Keep track of responses being sent to Planning Center, so tests can wait for them.
+var scheduleResponses sync.WaitGroup
+
+// Find the scheduling request of an organization a Slack user is responding to.
+func FindScheduleRequest(org *Org, planPersonID uint64, slackUserID string) (PlanPeople, error) {
+	// Find the person on the plan.
+	var planPerson PlanPeople
+	app.db.Where("id = ? AND org = ?", planPersonID, org.Name).First(&planPerson)
+	if planPerson.ID == 0 {
+		return planPerson, fmt.Errorf("unable to find schedule request")
+	}
+
+	// Only the person scheduled can respond.
+	var slackUser SlackUsers
+	app.db.Where("id = ?", slackUserID).First(&slackUser)
+	if slackUser.PCID == 0 || slackUser.PCID != planPerson.Person {
+		return planPerson, fmt.Errorf("this request is not for you")
+	}
+	return planPerson, nil
+}
+
+// Respond to a scheduling request in the background, as Slack expects interactions to be acknowledged within 3 seconds.
+// The person is told in the request conversation if the response could not be sent.
+func RespondToScheduleRequestLater(org *Org, planPerson PlanPeople, slackUserID string, accept bool, reason string) {
+	scheduleResponses.Add(1)
+	go func() {
+		defer scheduleResponses.Done()
+		err := RespondToScheduleRequest(org, planPerson, slackUserID, accept, reason)
+		if err != nil && planPerson.RequestChannel != "" {
+			NotifierAs(org, SlackNotifierName, ActorSlackUser(slackUserID)).PostMessage(planPerson.RequestChannel, err.Error())
+		}
+	}()
+}
+
+// Respond to a scheduling request of an organization in planning center, and update the request message.
+func RespondToScheduleRequest(org *Org, planPerson PlanPeople, slackUserID string, accept bool, reason string) error {
+	// Find the plan so we can build the API path.
+	var plan Plans
+	app.db.Where("id = ?", planPerson.Plan).First(&plan)
+	uri := fmt.Sprintf("/services/v2/service_types/%d/plans/%d/team_members/%d/", plan.ServiceType, plan.ID, planPerson.ID)
+
+	// Send the response to planning center.
+	var err error
+	status := "D"
+	if accept {
+		status = "C"
+		err = PCPost(org, uri+"accept", PCDict{"data": PCDict{"attributes": PCDict{}}})
+	} else {
+		err = PCPost(org, uri+"decline", PCDict{"data": PCDict{"attributes": PCDict{"reason": reason}}})
+	}
+	request := "accept"
+	if !accept {
+		request = "decline reason=" + reason
+	}
+	Audit(org, ActorSlackUser(slackUserID), AuditPCWrite, AuditPlatformPC, uri, request, "", err)
+	if err != nil {
+		log.Println("Failed to respond to schedule request:", err)
+		return fmt.Errorf("unable to update Planning Center, please try again later")
+	}
+
+	// Update our copy of the status.
+	planPerson.Status = status
+	app.db.Save(&planPerson)
+
+	// Replace the buttons with the response.
+	if planPerson.RequestChannel != "" && planPerson.RequestMessageTS != "" {
+		text := ScheduleRequestText(planPerson)
+		if accept {
+			text += " :white_check_mark: You accepted."
+		} else {
+			text += " :x: You declined."
+		}
+		notifier, ok := NotifierAs(org, SlackNotifierName, ActorSlackUser(slackUserID)).(InteractiveNotifier)
+		if ok {
+			err = notifier.UpdateMessage(planPerson.RequestChannel, planPerson.RequestMessageTS, text)
+		}
+		if err != nil {
+			log.Println("Failed to update schedule request:", err)
+		}
+	}
+	return nil
+}
+
+// Open a modal in the workspace of an organization asking for the reason a person is declining.
+func OpenDeclineModal(org *Org, triggerID, planPersonID string) error {
+	reason := slack.NewPlainTextInputBlockElement(slack.NewTextBlockObject(slack.PlainTextType, "Let your coordinator know why", false, false), ScheduleReasonAction)
+	reason.Multiline = true
+	input := slack.NewInputBlock(ScheduleReasonBlock, slack.NewTextBlockObject(slack.PlainTextType, "Reason", false, false), nil, reason)
+	input.Optional = true
+
+	view := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      ScheduleDeclineView,
+		PrivateMetadata: planPersonID,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "Decline", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Decline", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks:          slack.Blocks{BlockSet: []slack.Block{input}},
+	}
+	_, err := org.slack.OpenView(triggerID, view)
+	return err
+}
+
+// Handle interactivity from Slack messages and modals.
+func (s *HTTPServer) SlackInteractivityHandler(w http.ResponseWriter, r *http.Request) {
+	// The interaction is sent as JSON in the payload form field, from the workspace of an organization.
+	org := SlackOrg(r)
+	var callback slack.InteractionCallback
+	err := json.Unmarshal([]byte(r.FormValue("payload")), &callback)
+	if err != nil {
+		log.Println("Error decoding interaction:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Responses are checked before acknowledging the interaction, and sent to Planning Center after.
+	switch callback.Type {
+	// Buttons pressed on a scheduling request.
+	case slack.InteractionTypeBlockActions:
+		for _, action := range callback.ActionCallback.BlockActions {
+			var planPerson PlanPeople
+			switch action.ActionID {
+			case ScheduleAcceptAction:
+				id, _ := strconv.ParseUint(action.Value, 10, 64)
+				planPerson, err = FindScheduleRequest(org, id, callback.User.ID)
+				if err == nil {
+					RespondToScheduleRequestLater(org, planPerson, callback.User.ID, true, "")
+				}
+			case ScheduleDeclineAction:
+				err = OpenDeclineModal(org, callback.TriggerID, action.Value)
+			default:
+				continue
+			}
+			if err != nil {
+				log.Println("Error handling schedule request:", err)
+				NotifierAs(org, SlackNotifierName, ActorSlackUser(callback.User.ID)).PostMessage(callback.Channel.ID, err.Error())
+			}
+		}
+
+	// The decline reason was submitted.
+	case slack.InteractionTypeViewSubmission:
+		if callback.View.CallbackID != ScheduleDeclineView {
+			break
+		}
+		id, _ := strconv.ParseUint(callback.View.PrivateMetadata, 10, 64)
+		reason := ""
+		if callback.View.State != nil {
+			reason = strings.TrimSpace(callback.View.State.Values[ScheduleReasonBlock][ScheduleReasonAction].Value)
+		}
+		planPerson, err := FindScheduleRequest(org, id, callback.User.ID)
+		if err != nil {
+			// Show the error on the modal.
+			s.JSONResponse(w, slack.NewErrorsViewSubmissionResponse(map[string]string{ScheduleReasonBlock: err.Error()}))
+			return
+		}
+		RespondToScheduleRequestLater(org, planPerson, callback.User.ID, false, reason)
+	}
+
+	// Acknowledge the interaction.
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Planning Center stand-in recording the responses to scheduling requests.
+type pcResponseStandIn struct {
+	mu       sync.Mutex
+	requests []string // Paths and bodies posted.
+	status   int      // Status to respond with.
+	release  chan struct{}
+}
+
+func newPCResponseStandIn(t *testing.T) *pcResponseStandIn {
+	pc := &pcResponseStandIn{status: http.StatusNoContent, release: make(chan struct{})}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-pc.release
+		body, _ := io.ReadAll(r.Body)
+		pc.mu.Lock()
+		defer pc.mu.Unlock()
+		pc.requests = append(pc.requests, r.Method+" "+r.URL.Path+" "+string(body))
+		w.WriteHeader(pc.status)
+		if pc.status >= 300 {
+			w.Write([]byte(`{"errors":[{"detail":"Plan is locked"}]}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	baseURL := PCBaseURL
+	PCBaseURL = s.URL
+	t.Cleanup(func() { PCBaseURL = baseURL })
+	return pc
+}
+
+// Take the requests made since last taken.
+func (pc *pcResponseStandIn) Take() []string {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	requests := pc.requests
+	pc.requests = nil
+	return requests
+}
+
+// Setup a plan with an unconfirmed person, matched to the Slack user U5.
+func schedulingTestApp(t *testing.T) (*recordingNotifier, PlanPeople) {
+	notifier := newTestApp(t)
+	app.config.Slack.CreateChannelsAhead = 7 * 24 * time.Hour
+	createTestPlan(t, 10, 5)
+	app.db.Model(&PlanPeople{}).Where("id = ?", 1000).Updates(map[string]interface{}{"status": "U", "team_position_name": "Vocals"})
+	app.db.Create(&SlackUsers{ID: "U5", Org: DefaultOrgName, PCID: 5})
+	app.db.Create(&SlackUsers{ID: "U6", Org: DefaultOrgName, PCID: 6})
+	SendSchedulingRequests(app.orgs[0])
+	var planPerson PlanPeople
+	app.db.Where("id = ?", 1000).First(&planPerson)
+	return notifier, planPerson
+}
+
+// Send an interaction payload from the default organization, returning the response.
+func schedulingTestInteraction(t *testing.T, payload string) *httptest.ResponseRecorder {
+	form := url.Values{"payload": {payload}}
+	req := httptest.NewRequest(http.MethodPost, "/slack/interactivity", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), slackOrgKey{}, app.orgs[0]))
+	w := httptest.NewRecorder()
+	s := &HTTPServer{config: &app.config.HTTP}
+	s.SlackInteractivityHandler(w, req)
+	return w
+}
+
+// A button pressed on a scheduling request by a user.
+func schedulingTestButton(userID, actionID string) string {
+	return `{"type":"block_actions","user":{"id":"` + userID + `"},"channel":{"id":"D` + userID + `"},"trigger_id":"T1",` +
+		`"actions":[{"type":"button","block_id":"schedule_1000","action_id":"` + actionID + `","value":"1000"}]}`
+}
+
+// The decline modal submitted by a user with a reason.
+func schedulingTestDecline(userID, reason string) string {
+	return `{"type":"view_submission","user":{"id":"` + userID + `"},"view":{"callback_id":"schedule_decline","private_metadata":"1000",` +
+		`"state":{"values":{"decline_reason":{"reason":{"type":"plain_text_input","value":"` + reason + `"}}}}}}`
+}
+
+func TestSendSchedulingRequests(t *testing.T) {
+	notifier, planPerson := schedulingTestApp(t)
+
+	// The unconfirmed person is asked in a direct message, which is recorded so they are not asked again.
+	posts := notifier.WaitForPosts(t, 1)
+	if len(posts) != 1 || posts[0].Conversation != "DU5" || !strings.Contains(posts[0].Message, "*Vocals*") {
+		t.Fatalf("posted %+v, want a request to U5", posts)
+	}
+	if planPerson.RequestChannel != "DU5" || planPerson.RequestMessageTS == "" {
+		t.Errorf("saved request %+v", planPerson)
+	}
+	events, _ := QueryAuditEvents(AuditFilter{Action: AuditMessagePost})
+	if len(events) != 1 || events[0].Actor != ActorCron || events[0].Target != "DU5" {
+		t.Errorf("audit events %+v, want the request recorded", events)
+	}
+	SendSchedulingRequests(app.orgs[0])
+	if posts := notifier.WaitForPosts(t, 0); len(posts) != 1 {
+		t.Errorf("asked again: %+v", posts)
+	}
+}
+
+func TestScheduleAccept(t *testing.T) {
+	notifier, _ := schedulingTestApp(t)
+	pc := newPCResponseStandIn(t)
+
+	// The interaction is acknowledged before Planning Center responds.
+	w := schedulingTestInteraction(t, schedulingTestButton("U5", ScheduleAcceptAction))
+	if w.Code != http.StatusOK {
+		t.Fatalf("responded %d", w.Code)
+	}
+	close(pc.release)
+	scheduleResponses.Wait()
+
+	requests := pc.Take()
+	want := `POST /services/v2/service_types/1/plans/10/team_members/1000/accept {"data":{"attributes":{}}}`
+	if len(requests) != 1 || requests[0] != want {
+		t.Errorf("requested %q, want %q", requests, want)
+	}
+	var planPerson PlanPeople
+	app.db.Where("id = ?", 1000).First(&planPerson)
+	if planPerson.Status != "C" {
+		t.Errorf("status %q, want C", planPerson.Status)
+	}
+	updates := notifier.TakeUpdates()
+	if len(updates) != 1 || updates[0].Conversation != "DU5" || !strings.Contains(updates[0].Message, "You accepted") {
+		t.Errorf("updated %+v, want the request marked accepted", updates)
+	}
+	events, _ := QueryAuditEvents(AuditFilter{Actor: ActorSlackUser("U5")})
+	if len(events) != 2 || events[0].Action != AuditMessageUpdate || events[1].Action != AuditPCWrite {
+		t.Errorf("audit events %+v, want the Planning Center write and message update", events)
+	}
+
+	// Others can not respond to the request.
+	w = schedulingTestInteraction(t, schedulingTestButton("U6", ScheduleAcceptAction))
+	scheduleResponses.Wait()
+	if w.Code != http.StatusOK || len(pc.Take()) != 0 {
+		t.Errorf("responded to a request of someone else")
+	}
+	posts := notifier.WaitForPosts(t, 2)
+	if len(posts) != 2 || posts[1].Conversation != "DU6" || posts[1].Message != "this request is not for you" {
+		t.Errorf("posted %+v, want an error to U6", posts)
+	}
+}
+
+func TestScheduleDecline(t *testing.T) {
+	notifier, _ := schedulingTestApp(t)
+	pc := newPCResponseStandIn(t)
+	close(pc.release)
+
+	// The reason is sent to Planning Center.
+	w := schedulingTestInteraction(t, schedulingTestDecline("U5", " Out of town "))
+	scheduleResponses.Wait()
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Fatalf("responded %d %s", w.Code, w.Body)
+	}
+	requests := pc.Take()
+	want := `POST /services/v2/service_types/1/plans/10/team_members/1000/decline {"data":{"attributes":{"reason":"Out of town"}}}`
+	if len(requests) != 1 || requests[0] != want {
+		t.Errorf("requested %q, want %q", requests, want)
+	}
+	updates := notifier.TakeUpdates()
+	if len(updates) != 1 || !strings.Contains(updates[0].Message, "You declined") {
+		t.Errorf("updated %+v, want the request marked declined", updates)
+	}
+
+	// Others are shown an error on the modal.
+	w = schedulingTestInteraction(t, schedulingTestDecline("U6", ""))
+	var resp struct {
+		ResponseAction string            `json:"response_action"`
+		Errors         map[string]string `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.ResponseAction != "errors" || resp.Errors[ScheduleReasonBlock] == "" {
+		t.Errorf("responded %s, want an error on the modal", w.Body)
+	}
+
+	// Responses Planning Center rejects are reported in the request conversation.
+	pc.mu.Lock()
+	pc.status = http.StatusUnprocessableEntity
+	pc.mu.Unlock()
+	schedulingTestInteraction(t, schedulingTestDecline("U5", ""))
+	scheduleResponses.Wait()
+	posts := notifier.WaitForPosts(t, 2)
+	if len(posts) != 2 || posts[1].Conversation != "DU5" || !strings.Contains(posts[1].Message, "unable to update Planning Center") {
+		t.Errorf("posted %+v, want an error to U5", posts)
+	}
+	var planPerson PlanPeople
+	app.db.Where("id = ?", 1000).First(&planPerson)
+	if planPerson.Status != "D" {
+		t.Errorf("status %q, want D from the first decline", planPerson.Status)
+	}
+}
+
+func TestScheduleInvalidPayload(t *testing.T) {
+	newTestApp(t)
+	w := schedulingTestInteraction(t, "{")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("responded %d to an invalid payload, want 400", w.Code)
+	}
+}
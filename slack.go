@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/slack-go/slack"
+)
+
+// Slack events API outer event structure.
+type SlackEventCallback struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	TeamID    string          `json:"team_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// Slack events API inner event structure, containing the fields used by the events we handle.
+type SlackEvent struct {
+	Type    string          `json:"type"`
+	User    json.RawMessage `json:"user"`
+	Channel json.RawMessage `json:"channel"`
+}
+
+// Context key for the organization a Slack request is from.
+type slackOrgKey struct{}
+
+// Get the organization a Slack request is from, as verified by its signing secret.
+func SlackOrg(r *http.Request) *Org {
+	org, _ := r.Context().Value(slackOrgKey{}).(*Org)
+	return org
+}
+
+// Check if a request is signed with a signing secret.
+func SlackVerify(header http.Header, body []byte, signingSecret string) bool {
+	if signingSecret == "" {
+		return false
+	}
+	verifier, err := slack.NewSecretsVerifier(header, signingSecret)
+	if err != nil {
+		return false
+	}
+	verifier.Write(body)
+	return verifier.Ensure() == nil
+}
+
+// Verifies that requests are signed by Slack using the signing secret,
+// finding the organization by whose signing secret matches.
+func (s *HTTPServer) SlackVerificationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Read the body to verify.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			s.APISendGeneralResp(w, APIERR, "Error reading body")
+			return
+		}
+
+		// Ensure the signature matches an organization.
+		var org *Org
+		for _, o := range app.orgs {
+			if SlackVerify(r.Header, body, o.Slack.SigningSecret) {
+				org = o
+				break
+			}
+		}
+		if org == nil {
+			log.Println("Slack request failed verification")
+			w.WriteHeader(http.StatusForbidden)
+			s.APISendGeneralResp(w, APIERR, APIForbidden)
+			return
+		}
+
+		// Replace the body so the handler can read it.
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), slackOrgKey{}, org)))
+	})
+}
+
+// Handle an event from the Slack events API for an organization, returning an error if it could not be decoded.
+func SlackHandleEvent(org *Org, event SlackEvent) error {
+	switch event.Type {
+	// Save new or changed users, which also matches them to planning center.
+	case "team_join", "user_change":
+		var user slack.User
+		err := json.Unmarshal(event.User, &user)
+		if err != nil {
+			return fmt.Errorf("decoding user in %s event: %s", event.Type, err)
+		}
+		if user.ID == "" {
+			return fmt.Errorf("no user in %s event", event.Type)
+		}
+
+		// If the account type changed, invites which failed may now succeed.
+		var existing SlackUsers
+		app.db.Where("id = ?", user.ID).First(&existing)
+		if existing.ID != "" && (existing.Deleted != user.Deleted || existing.IsRestricted != user.IsRestricted || existing.IsUltraRestricted != user.IsUltraRestricted) {
+			ResetChannelMemberFailures(user.ID)
+		}
+		SaveSlackUser(org, user)
+
+	// Update archive state of channels we created.
+	case "channel_archive", "group_archive", "channel_unarchive", "group_unarchive":
+		var channelID string
+		err := json.Unmarshal(event.Channel, &channelID)
+		if err != nil {
+			return fmt.Errorf("decoding channel in %s event: %s", event.Type, err)
+		}
+		var channel SlackChannels
+		app.db.Where("id = ?", channelID).First(&channel)
+		if channel.ID == "" {
+			return nil
+		}
+		channel.Archived = strings.HasSuffix(event.Type, "_archive")
+		app.db.Save(&channel)
+
+	// Update the name of channels we created.
+	case "channel_rename", "group_rename":
+		var info struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		err := json.Unmarshal(event.Channel, &info)
+		if err != nil {
+			return fmt.Errorf("decoding channel in %s event: %s", event.Type, err)
+		}
+		var channel SlackChannels
+		app.db.Where("id = ?", info.ID).First(&channel)
+		if channel.ID == "" {
+			return nil
+		}
+		channel.Name = info.Name
+		app.db.Save(&channel)
+
+	// Record users who left a channel, so they are invited again if still on the plan.
+	case "member_left_channel":
+		userID, channelID, err := SlackEventMember(event)
+		if err != nil {
+			return err
+		}
+		RemoveChannelMember(channelID, userID)
+
+	// Record users who were added to a channel we created by someone else.
+	case "member_joined_channel":
+		userID, channelID, err := SlackEventMember(event)
+		if err != nil {
+			return err
+		}
+		var channel SlackChannels
+		app.db.Where("id = ?", channelID).First(&channel)
+		if channel.ID == "" {
+			return nil
+		}
+		JoinChannelMember(channelID, userID)
+	}
+	return nil
+}
+
+// Decode the user and channel IDs of a member event.
+func SlackEventMember(event SlackEvent) (userID, channelID string, err error) {
+	err = json.Unmarshal(event.User, &userID)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding user in %s event: %s", event.Type, err)
+	}
+	err = json.Unmarshal(event.Channel, &channelID)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding channel in %s event: %s", event.Type, err)
+	}
+	return
+}
+
+// Setup HTTP router with routes for Slack apps.
+func (s *HTTPServer) RegisterSlackRoutes(r *mux.Router) {
+	sr := r.PathPrefix("/slack").Subrouter()
+
+	// Requires requests to be signed by Slack.
+	sr.Use(s.SlackVerificationMiddleware)
+
+	// Receive events from the Slack events API.
+	sr.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		// Decode the event.
+		var callback SlackEventCallback
+		err := json.NewDecoder(r.Body).Decode(&callback)
+		if err != nil {
+			log.Println("Error decoding slack event:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			s.APISendGeneralResp(w, APIERR, "Error decoding event")
+			return
+		}
+
+		switch callback.Type {
+		// Slack verifies the URL when configuring the app by sending a challenge.
+		case "url_verification":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, callback.Challenge)
+			return
+
+		// Handle events.
+		case "event_callback":
+			var event SlackEvent
+			err = json.Unmarshal(callback.Event, &event)
+			if err != nil {
+				log.Println("Error decoding slack event:", err)
+				w.WriteHeader(http.StatusBadRequest)
+				s.APISendGeneralResp(w, APIERR, "Error decoding event")
+				return
+			}
+			err = SlackHandleEvent(SlackOrg(r), event)
+			if err != nil {
+				log.Println("Error handling slack event:", err)
+				w.WriteHeader(http.StatusBadRequest)
+				s.APISendGeneralResp(w, APIERR, "Error decoding event")
+				return
+			}
+		}
+
+		// Return a success.
+		s.APISendGeneralResp(w, APIOK, "")
+	}).Methods(http.MethodPost)
+
+	// Receive slash commands.
+	sr.HandleFunc("/commands", s.SlackCommandHandler).Methods(http.MethodPost)
+
+	// Receive interactions with messages and modals.
+	sr.HandleFunc("/interactivity", s.SlackInteractivityHandler).Methods(http.MethodPost)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Setup the app to receive Slack requests signed with signing for the default organization and north-signing for north.
+func slackTestRouter(t *testing.T) *mux.Router {
+	newTestApp(t)
+	app.config.Slack.SigningSecret = "signing"
+	app.orgs = append(app.orgs, &Org{
+		Name:           "north",
+		PlanningCenter: &PlanningCenterConfig{},
+		Slack:          &SlackConfig{SigningSecret: "north-signing"},
+		Reminders:      &RemindersConfig{},
+		notifier:       &recordingNotifier{},
+	})
+	s := &HTTPServer{config: &app.config.HTTP}
+	r := mux.NewRouter()
+	s.RegisterSlackRoutes(r)
+	return r
+}
+
+// Send a request to the Slack events API signed with a secret at a time, returning the response.
+func slackTestSendAt(r *mux.Router, secret string, at time.Time, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	timestamp := fmt.Sprint(at.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, "v0:"+timestamp+":"+body)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// Send an event to the Slack events API signed with a secret, returning the response status.
+func slackTestEvent(r *mux.Router, secret, event string) int {
+	return slackTestSendAt(r, secret, time.Now(), `{"type":"event_callback","team_id":"T1","event":`+event+`}`).Code
+}
+
+func TestSlackVerification(t *testing.T) {
+	r := slackTestRouter(t)
+	challenge := `{"type":"url_verification","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P"}`
+
+	for _, c := range []struct {
+		name   string
+		secret string
+		at     time.Time
+		status int
+	}{
+		{"first organization", "signing", time.Now(), http.StatusOK},
+		{"second organization", "north-signing", time.Now(), http.StatusOK},
+		{"unknown secret", "guess", time.Now(), http.StatusForbidden},
+		{"no secret", "", time.Now(), http.StatusForbidden},
+		{"replayed", "signing", time.Now().Add(-time.Hour), http.StatusForbidden},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := slackTestSendAt(r, c.secret, c.at, challenge)
+			if w.Code != c.status {
+				t.Errorf("responded %d, want %d", w.Code, c.status)
+			}
+		})
+	}
+
+	// Slack verifies the URL with a challenge, which is echoed back.
+	w := slackTestSendAt(r, "signing", time.Now(), challenge)
+	if w.Body.String() != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("responded %q to the challenge", w.Body)
+	}
+
+	// Unsigned requests are rejected.
+	req := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(challenge))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("responded %d to an unsigned request", w.Code)
+	}
+}
+
+func TestSlackEvents(t *testing.T) {
+	r := slackTestRouter(t)
+	app.db.Create(&SlackChannels{ID: "C1", Org: DefaultOrgName, Name: "sunday", PCPlan: 10})
+	channel := func() (c SlackChannels) {
+		app.db.Where("id = ?", "C1").First(&c)
+		return
+	}
+
+	// Users who join are saved to the organization of the workspace.
+	status := slackTestEvent(r, "north-signing", `{"type":"team_join","user":{"id":"U1","name":"ann","is_restricted":true,"profile":{"first_name":"Ann"}}}`)
+	var user SlackUsers
+	app.db.Where("id = ?", "U1").First(&user)
+	if status != http.StatusOK || user.Org != "north" || user.FirstName != "Ann" || !user.IsRestricted {
+		t.Errorf("responded %d and saved %+v", status, user)
+	}
+
+	// Changes to their account type allow failed invites to be tried again.
+	app.db.Create(&ChannelMembers{ChannelID: "C1", UserID: "U1", Reason: ChannelMemberPosition, InviteAttempts: 3, PermanentFailure: true})
+	status = slackTestEvent(r, "north-signing", `{"type":"user_change","user":{"id":"U1","name":"ann","profile":{"first_name":"Annie"}}}`)
+	app.db.Where("id = ?", "U1").First(&user)
+	var member ChannelMembers
+	app.db.Where("user_id = ?", "U1").First(&member)
+	if status != http.StatusOK || user.FirstName != "Annie" || user.IsRestricted || member.PermanentFailure || member.InviteAttempts != 0 {
+		t.Errorf("responded %d and saved %+v with member %+v", status, user, member)
+	}
+
+	// Archiving channels is recorded.
+	status = slackTestEvent(r, "signing", `{"type":"group_archive","channel":"C1"}`)
+	if status != http.StatusOK || !channel().Archived {
+		t.Errorf("responded %d and saved %+v after archiving", status, channel())
+	}
+	status = slackTestEvent(r, "signing", `{"type":"channel_unarchive","channel":"C1"}`)
+	if status != http.StatusOK || channel().Archived {
+		t.Errorf("responded %d and saved %+v after unarchiving", status, channel())
+	}
+
+	// So is renaming them.
+	status = slackTestEvent(r, "signing", `{"type":"group_rename","channel":{"id":"C1","name":"easter"}}`)
+	if status != http.StatusOK || channel().Name != "easter" {
+		t.Errorf("responded %d and saved %+v after renaming", status, channel())
+	}
+
+	// Members joining and leaving channels are recorded.
+	status = slackTestEvent(r, "signing", `{"type":"member_joined_channel","user":"U2","channel":"C1"}`)
+	if status != http.StatusOK || !reflect.DeepEqual(ChannelActiveUsers("C1"), []string{"U2"}) {
+		t.Errorf("responded %d with members %v after joining", status, ChannelActiveUsers("C1"))
+	}
+	status = slackTestEvent(r, "signing", `{"type":"member_left_channel","user":"U2","channel":"C1"}`)
+	if status != http.StatusOK || len(ChannelActiveUsers("C1")) != 0 {
+		t.Errorf("responded %d with members %v after leaving", status, ChannelActiveUsers("C1"))
+	}
+
+	// Channels not created by the service are ignored.
+	status = slackTestEvent(r, "signing", `{"type":"member_joined_channel","user":"U2","channel":"C2"}`)
+	if status != http.StatusOK || len(ChannelActiveUsers("C2")) != 0 {
+		t.Errorf("responded %d with members %v of another channel", status, ChannelActiveUsers("C2"))
+	}
+}
+
+func TestSlackEventsInvalid(t *testing.T) {
+	r := slackTestRouter(t)
+	for _, c := range []struct {
+		name string
+		body string
+	}{
+		{"callback", `{"type":`},
+		{"event", `{"type":"event_callback","event":"team_join"}`},
+		{"user", `{"type":"event_callback","event":{"type":"team_join","user":"U1"}}`},
+		{"user without id", `{"type":"event_callback","event":{"type":"user_change","user":{"name":"ann"}}}`},
+		{"archived channel", `{"type":"event_callback","event":{"type":"channel_archive","channel":{"id":"C1"}}}`},
+		{"renamed channel", `{"type":"event_callback","event":{"type":"channel_rename","channel":"C1"}}`},
+		{"member", `{"type":"event_callback","event":{"type":"member_left_channel","user":{"id":"U1"},"channel":"C1"}}`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			w := slackTestSendAt(r, "signing", time.Now(), c.body)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("responded %d, want 400", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Keywords people can reply with to stop or start receiving texts.
+var (
+	SMSStopKeywords  = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT"}
+	SMSStartKeywords = []string{"START", "YES", "UNSTOP"}
+)
+
+// A provider which can send text messages.
+type SMSProvider interface {
+	Send(to, body string) error
+}
+
+// SMS provider using the Twilio REST API, or a compatible API.
+type TwilioProvider struct {
+	config *SMSConfig
+}
+
+// Create a twilio provider from the configuration.
+func NewTwilioProvider(config *SMSConfig) *TwilioProvider {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://api.twilio.com"
+	}
+	return &TwilioProvider{config: config}
+}
+
+// Send a text message.
+func (p *TwilioProvider) Send(to, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", p.config.From)
+	form.Set("Body", body)
+
+	// Make the request.
+	uri := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimSuffix(p.config.BaseURL, "/"), p.config.AccountSID)
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.config.AccountSID, p.config.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Perform the request.
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// Verify a request was signed by twilio with the auth token.
+func (p *TwilioProvider) Verify(r *http.Request) bool {
+	// The signature is of the URL followed by each sorted parameter and value.
+	var keys []string
+	for key := range r.PostForm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := p.config.WebhookURL
+	for _, key := range keys {
+		data += key + r.PostForm.Get(key)
+	}
+
+	// Compute the expected signature and compare.
+	mac := hmac.New(sha1.New, []byte(p.config.AuthToken))
+	mac.Write([]byte(data))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Twilio-Signature")))
+}
+
+// Setup the SMS provider from the configuration.
+func (a *App) InitSMS() {
+	switch a.config.SMS.Provider {
+	case "":
+	case "twilio":
+		a.sms = NewTwilioProvider(&a.config.SMS)
+	default:
+		log.Fatalln("Unknown SMS provider:", a.config.SMS.Provider)
+	}
+}
+
+// Get only the digits of a phone number.
+func PhoneDigits(phone string) string {
+	var b strings.Builder
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// Check if two phone numbers match, allowing for a missing country code.
+func PhonesMatch(a, b string) bool {
+	a, b = PhoneDigits(a), PhoneDigits(b)
+	if len(a) < 7 || len(b) < 7 {
+		return false
+	}
+	return strings.HasSuffix(a, b) || strings.HasSuffix(b, a)
+}
+
+// Opt a person in or out of text messages.
+func SMSSetOptIn(personID uint64, phone string, optIn bool) {
+	var sub SMSSubscriptions
+	app.db.Where("person = ?", personID).First(&sub)
+	sub.Person = personID
+	if phone != "" {
+		sub.Phone = phone
+	}
+	sub.OptedIn = optIn
+	if !optIn {
+		sub.OptedOutAt = time.Now().UTC()
+	}
+	if sub.ID == 0 {
+		app.db.Create(&sub)
+	} else {
+		app.db.Save(&sub)
+	}
+}
+
+// Text an urgent message to opted in people on the current service of an organization in the listed positions.
+func SendUrgentSMS(org *Org, actor, message string, positions []string) {
+	if app.sms == nil {
+		return
+	}
+
+	// Default to the configured positions.
+	if len(positions) == 0 {
+		positions = app.config.SMS.UrgentPositions
+	}
+
+	// Find the service occurring now.
+	planTime := PlanOccurringAt(org, time.Now().UTC())
+	if planTime.Plan == 0 {
+		log.Println("No service occurring to send urgent text to")
+		return
+	}
+
+	// Find people on the plan in the positions.
+	var peopleOnPlan []PlanPeople
+	app.db.Where("plan = ? AND status != 'D'", planTime.Plan).Find(&peopleOnPlan)
+	sent := make(map[uint64]bool)
+	for _, planPerson := range peopleOnPlan {
+		if sent[planPerson.Person] {
+			continue
+		}
+
+		// Check that the position is one we should text.
+		matched := len(positions) == 0
+		for _, position := range positions {
+			if strings.EqualFold(strings.TrimSpace(position), planPerson.TeamPositionName) {
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		// Only text people who opted in.
+		var sub SMSSubscriptions
+		app.db.Where("person = ?", planPerson.Person).First(&sub)
+		if !sub.OptedIn || sub.Phone == "" {
+			continue
+		}
+
+		// Send the text.
+		sent[planPerson.Person] = true
+		err := app.sms.Send(sub.Phone, message)
+		Audit(org, actor, AuditSMSSend, "sms", fmt.Sprintf("person:%d", planPerson.Person), message, "", err)
+		if err != nil {
+			log.Println("Error sending text:", err)
+		}
+	}
+}
+
+// Setup HTTP router with routes for incoming text messages.
+func (s *HTTPServer) RegisterSMSRoutes(r *mux.Router) {
+	// Receive replies from twilio.
+	r.HandleFunc("/webhooks/sms", func(w http.ResponseWriter, r *http.Request) {
+		twilio, ok := app.sms.(*TwilioProvider)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Verify the request came from twilio.
+		r.ParseForm()
+		if !twilio.Verify(r) {
+			log.Println("SMS webhook failed verification")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// Check the reply for keywords.
+		from := r.PostForm.Get("From")
+		keyword := strings.ToUpper(strings.TrimSpace(r.PostForm.Get("Body")))
+		optIn, handled := false, false
+		for _, k := range SMSStopKeywords {
+			if keyword == k {
+				handled = true
+			}
+		}
+		for _, k := range SMSStartKeywords {
+			if keyword == k {
+				optIn, handled = true, true
+			}
+		}
+
+		// Update subscriptions for this phone number.
+		if handled {
+			var subs []SMSSubscriptions
+			app.db.Find(&subs)
+			for _, sub := range subs {
+				if PhonesMatch(sub.Phone, from) {
+					SMSSetOptIn(sub.Person, "", optIn)
+				}
+			}
+		}
+
+		// Respond with an empty TwiML response.
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?><Response></Response>")
+	}).Methods(http.MethodPost)
+}
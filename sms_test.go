@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// An SMS provider recording the texts sent.
+type recordingSMS struct {
+	mu   sync.Mutex
+	sent []string // Phone numbers and bodies sent.
+}
+
+func (p *recordingSMS) Send(to, body string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, to+" "+body)
+	return nil
+}
+
+// Setup the app to receive replies signed with the auth token.
+func smsTestRouter(t *testing.T) *mux.Router {
+	newTestApp(t)
+	app.config.SMS = SMSConfig{Provider: "twilio", AuthToken: "token", WebhookURL: "https://notify.example.com/webhooks/sms"}
+	app.sms = NewTwilioProvider(&app.config.SMS)
+	t.Cleanup(func() { app.sms = nil })
+	s := &HTTPServer{config: &app.config.HTTP}
+	r := mux.NewRouter()
+	s.RegisterSMSRoutes(r)
+	return r
+}
+
+// Send a reply signed with a token, returning the response status.
+func smsTestReply(r *mux.Router, token, from, body string) int {
+	form := url.Values{"From": {from}, "Body": {body}}
+	var keys []string
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := "https://notify.example.com/webhooks/sms"
+	for _, key := range keys {
+		data += key + form.Get(key)
+	}
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(data))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/sms", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestSMSStop(t *testing.T) {
+	r := smsTestRouter(t)
+	SMSSetOptIn(5, "(555) 123-4567", true)
+	SMSSetOptIn(6, "+1 555 765 4321", true)
+	optedIn := func(personID uint64) bool {
+		var sub SMSSubscriptions
+		app.db.Where("person = ?", personID).First(&sub)
+		return sub.OptedIn
+	}
+
+	// Replies which are not signed by twilio are ignored.
+	if status := smsTestReply(r, "guess", "+15551234567", "STOP"); status != http.StatusForbidden || !optedIn(5) {
+		t.Errorf("responded %d to an unsigned reply, opted in %v", status, optedIn(5))
+	}
+
+	// Other replies do not change subscriptions.
+	if status := smsTestReply(r, "token", "+15551234567", "Thanks!"); status != http.StatusOK || !optedIn(5) {
+		t.Errorf("responded %d to a reply, opted in %v", status, optedIn(5))
+	}
+
+	// Stop keywords opt out the matching phone number only, in any case and with a country code.
+	if status := smsTestReply(r, "token", "+15551234567", " stop "); status != http.StatusOK || optedIn(5) || !optedIn(6) {
+		t.Errorf("responded %d to stop, opted in %v and %v", status, optedIn(5), optedIn(6))
+	}
+	var sub SMSSubscriptions
+	app.db.Where("person = ?", 5).First(&sub)
+	if sub.OptedOutAt.IsZero() || sub.Phone != "(555) 123-4567" {
+		t.Errorf("saved %+v after stop", sub)
+	}
+
+	// Start keywords opt them back in.
+	if status := smsTestReply(r, "token", "5551234567", "UNSTOP"); status != http.StatusOK || !optedIn(5) {
+		t.Errorf("responded %d to unstop, opted in %v", status, optedIn(5))
+	}
+}
+
+func TestSendUrgentSMS(t *testing.T) {
+	newTestApp(t)
+	sms := &recordingSMS{}
+	app.sms = sms
+	t.Cleanup(func() { app.sms = nil })
+	createTestPlan(t, 10, 5, 6, 7)
+	app.db.Model(&PlanTimes{}).Where("id = ?", 10).Update("starts_at", time.Now().Add(-time.Minute).UTC())
+	for id, position := range map[uint64]string{1000: "Sound", 1001: "Sound", 1002: "Vocals"} {
+		app.db.Model(&PlanPeople{}).Where("id = ?", id).Update("team_position_name", position)
+	}
+	SMSSetOptIn(5, "5551234567", true)
+	SMSSetOptIn(6, "5557654321", false)
+	SMSSetOptIn(7, "5550000000", true)
+
+	// Only opted in people in the positions are texted.
+	SendUrgentSMS(app.orgs[0], ActorCron, "Fire!", []string{" sound "})
+	if len(sms.sent) != 1 || sms.sent[0] != "5551234567 Fire!" {
+		t.Errorf("sent %q, want a text to 5551234567", sms.sent)
+	}
+	events, _ := QueryAuditEvents(AuditFilter{Action: AuditSMSSend})
+	if len(events) != 1 || events[0].Target != "person:5" {
+		t.Errorf("audit events %+v, want the text recorded", events)
+	}
+}
+
+func TestPhonesMatch(t *testing.T) {
+	for _, c := range []struct {
+		a, b  string
+		match bool
+	}{
+		{"+1 (555) 123-4567", "5551234567", true},
+		{"555-123-4567", "1234567", true},
+		{"5551234567", "5551234568", false},
+		{"123", "123", false},
+		{"", "", false},
+	} {
+		if PhonesMatch(c.a, c.b) != c.match {
+			t.Errorf("%q and %q matched %v, want %v", c.a, c.b, !c.match, c.match)
+		}
+	}
+}
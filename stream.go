@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// How often to send keep alives to stream clients.
+const StreamKeepAlive = time.Second * 30
+
+// A message sent to stream clients.
+type StreamMessage struct {
+	ID           uint64        `json:"id"`
+	Time         time.Time     `json:"time"`
+	Organization string        `json:"organization"`
+	Conversation string        `json:"conversation"`
+	Notifier     string        `json:"notifier"`
+	Message      string        `json:"message"`
+	Plan         *Plans        `json:"plan,omitempty"`
+	ServiceType  *ServiceTypes `json:"service_type,omitempty"`
+}
+
+// Broadcasts messages to stream clients, keeping recent messages to replay.
+type MessageStream struct {
+	sync.Mutex
+	lastID  uint64
+	recent  []StreamMessage
+	clients map[chan StreamMessage]bool
+}
+
+// The stream of messages sent.
+var messageStream = &MessageStream{
+	clients: make(map[chan StreamMessage]bool),
+}
+
+// Upgrades stream requests to websockets.
+var streamUpgrader = websocket.Upgrader{
+	CheckOrigin: StreamCheckOrigin,
+}
+
+// Browsers send the dashboard session with websockets from any page, so only pages served by
+// this service, at the host requested or the public URL, may connect. Clients which are not
+// browsers do not send an origin.
+func StreamCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if app.config.ICal.PublicURL != "" {
+		public, err := url.Parse(app.config.ICal.PublicURL)
+		if err == nil && strings.EqualFold(u.Host, public.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// Broadcast a message to stream clients.
+func (s *MessageStream) Publish(event MessageEvent) {
+	message := StreamMessage{
+		Time:         time.Now().UTC(),
+		Organization: event.Organization,
+		Conversation: event.Conversation,
+		Notifier:     event.Notifier,
+		Message:      event.Message,
+		Plan:         event.Plan,
+	}
+	if event.Plan != nil {
+		message.ServiceType = new(ServiceTypes)
+		app.db.Where("id = ?", event.Plan.ServiceType).First(message.ServiceType)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.lastID++
+	message.ID = s.lastID
+
+	// Keep recent messages for replay.
+	s.recent = append(s.recent, message)
+	if len(s.recent) > app.config.HTTP.StreamReplay {
+		s.recent = s.recent[len(s.recent)-app.config.HTTP.StreamReplay:]
+	}
+
+	// Send to clients, dropping the message for clients which are not keeping up.
+	for c := range s.clients {
+		select {
+		case c <- message:
+		default:
+		}
+	}
+}
+
+// Subscribe to messages, returning recent messages after an ID to replay.
+func (s *MessageStream) Subscribe(after uint64) (chan StreamMessage, []StreamMessage) {
+	s.Lock()
+	defer s.Unlock()
+	c := make(chan StreamMessage, 16)
+	s.clients[c] = true
+	var replay []StreamMessage
+	for _, message := range s.recent {
+		if message.ID > after {
+			replay = append(replay, message)
+		}
+	}
+	return c, replay
+}
+
+// Stop sending messages to a client.
+func (s *MessageStream) Unsubscribe(c chan StreamMessage) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.clients, c)
+}
+
+// Filters for the messages sent to a stream client.
+type StreamFilter struct {
+	Org          string          // Only messages sent to this organization, all if empty.
+	ServiceTypes map[uint64]bool // Only messages for these service types, all if nil.
+}
+
+// Parse the filter of a stream request. Keys limited to an organization only receive its messages.
+func StreamRequestFilter(r *http.Request) (filter StreamFilter) {
+	filter.Org = r.URL.Query().Get("organization")
+	if org := APIOrg(r); org != nil {
+		filter.Org = org.Name
+	}
+
+	param := r.URL.Query().Get("service_type")
+	if param == "" {
+		return
+	}
+	filter.ServiceTypes = make(map[uint64]bool)
+	for _, id := range strings.Split(param, ",") {
+		serviceTypeID, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+		if err == nil {
+			filter.ServiceTypes[serviceTypeID] = true
+		}
+	}
+	return
+}
+
+// Check if a message passes the filter.
+func (m StreamMessage) Matches(filter StreamFilter) bool {
+	if filter.Org != "" && m.Organization != filter.Org {
+		return false
+	}
+	if filter.ServiceTypes == nil {
+		return true
+	}
+	return m.ServiceType != nil && filter.ServiceTypes[m.ServiceType.ID]
+}
+
+// Stream messages to a client with server-sent events or a websocket.
+func (s *HTTPServer) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	filter := StreamRequestFilter(r)
+	if websocket.IsWebSocketUpgrade(r) {
+		s.StreamWebSocket(w, r, filter)
+		return
+	}
+
+	// Server-sent events require flushing each event.
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Reconnecting clients only replay messages they did not receive.
+	after, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	c, replay := messageStream.Subscribe(after)
+	defer messageStream.Unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Write an event for a message.
+	send := func(message StreamMessage) {
+		if !message.Matches(filter) {
+			return
+		}
+		data, err := json.Marshal(message)
+		if err != nil {
+			log.Println("Error encoding stream message:", err)
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", message.ID, data)
+	}
+	for _, message := range replay {
+		send(message)
+	}
+	flusher.Flush()
+
+	// Send messages until the client disconnects.
+	ticker := time.NewTicker(StreamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case message := <-c:
+			send(message)
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// Stream messages to a websocket client.
+func (s *HTTPServer) StreamWebSocket(w http.ResponseWriter, r *http.Request, filter StreamFilter) {
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error upgrading stream to websocket:", err)
+		return
+	}
+	defer conn.Close()
+
+	c, replay := messageStream.Subscribe(0)
+	defer messageStream.Unsubscribe(c)
+
+	// Read from the client to process control messages and notice when it disconnects.
+	closed := make(chan bool)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	// Write a message to the client.
+	send := func(message StreamMessage) error {
+		if !message.Matches(filter) {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+		return conn.WriteJSON(message)
+	}
+	for _, message := range replay {
+		if send(message) != nil {
+			return
+		}
+	}
+
+	// Send messages until the client disconnects.
+	ticker := time.NewTicker(StreamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case message := <-c:
+			err = send(message)
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second*10))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Serve the API with the key k, and a fresh message stream with a message for each of two service types.
+func streamTestServer(t *testing.T) *httptest.Server {
+	newTestApp(t)
+	app.config.HTTP.APIKeys = []APIKeyConfig{{Name: "stage", Key: "k"}}
+	app.config.HTTP.StreamReplay = 50
+	app.db.Create(&ServiceTypes{ID: 1, Org: DefaultOrgName, Name: "Sunday"})
+	app.db.Create(&ServiceTypes{ID: 2, Org: DefaultOrgName, Name: "Youth"})
+
+	stream := messageStream
+	messageStream = &MessageStream{clients: make(map[chan StreamMessage]bool)}
+	t.Cleanup(func() { messageStream = stream })
+	streamTestPublish(1, "Sunday 1")
+	streamTestPublish(2, "Youth 1")
+	streamTestPublish(1, "Sunday 2")
+
+	s := &HTTPServer{config: &app.config.HTTP}
+	r := mux.NewRouter()
+	s.RegisterAPIRoutes(r)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Publish a message sent to a plan of a service type.
+func streamTestPublish(serviceTypeID uint64, message string) {
+	messageStream.Publish(MessageEvent{
+		Organization: DefaultOrgName,
+		Conversation: "C1",
+		Message:      message,
+		Plan:         &Plans{ID: 10, ServiceType: serviceTypeID},
+	})
+}
+
+// Wait until a number of clients are subscribed to the stream.
+func streamTestWaitForClients(t *testing.T, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		messageStream.Lock()
+		clients := len(messageStream.clients)
+		messageStream.Unlock()
+		if clients >= count {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("stream clients did not subscribe")
+}
+
+func TestStreamAuthentication(t *testing.T) {
+	server := streamTestServer(t)
+
+	for _, c := range []struct {
+		name   string
+		url    string
+		header string
+		ok     bool
+	}{
+		{"no key", "/api/stream", "", false},
+		{"wrong key", "/api/stream?api_key=wrong", "", false},
+		{"query key", "/api/stream?api_key=k", "", true},
+		{"header key", "/api/stream", "k", true},
+		{"query key on other calls", "/api/ping?api_key=k", "", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+c.url, nil)
+			if c.header != "" {
+				req.Header.Set("X-API-Key", c.header)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			streaming := res.Header.Get("Content-Type") == "text/event-stream"
+			if streaming != c.ok {
+				t.Errorf("responded with %s, want streaming %v", res.Header.Get("Content-Type"), c.ok)
+			}
+		})
+	}
+
+	// Without API keys, the stream is still not open.
+	app.config.HTTP.APIKeys = nil
+	res, err := http.Get(server.URL + "/api/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") == "text/event-stream" {
+		t.Error("streamed without a key or session")
+	}
+}
+
+func TestStreamServerSentEvents(t *testing.T) {
+	server := streamTestServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/stream?api_key=k&service_type=1", nil)
+	// Reconnecting after the first message only replays those after it.
+	req.Header.Set("Last-Event-ID", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	events := bufio.NewReader(res.Body)
+
+	// Read the next event, returning its ID and message.
+	next := func() (string, StreamMessage) {
+		t.Helper()
+		var id string
+		var message StreamMessage
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return id, message
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &message)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	// Only messages of the service type are replayed.
+	id, message := next()
+	if id != "3" || message.Message != "Sunday 2" || message.ServiceType == nil || message.ServiceType.Name != "Sunday" {
+		t.Errorf("replayed event %s %+v, want Sunday 2", id, message)
+	}
+
+	// And streamed as they are sent.
+	streamTestWaitForClients(t, 1)
+	streamTestPublish(2, "Youth 2")
+	streamTestPublish(1, "Sunday 3")
+	id, message = next()
+	if id != "5" || message.Message != "Sunday 3" {
+		t.Errorf("streamed event %s %+v, want Sunday 3", id, message)
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	server := streamTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/stream?service_type=2"
+	header := http.Header{"X-Api-Key": {"k"}}
+
+	// Pages of other sites may not connect.
+	header.Set("Origin", "https://evil.example.com")
+	_, res, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil || res == nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("connected from another origin: %v", err)
+	}
+
+	// Pages served at the public URL may.
+	app.config.ICal.PublicURL = "https://notify.example.com/"
+	header.Set("Origin", "https://notify.example.com")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Messages of the service type are replayed, then streamed as they are sent.
+	var message StreamMessage
+	err = conn.ReadJSON(&message)
+	if err != nil || message.Message != "Youth 1" {
+		t.Errorf("replayed %+v %v, want Youth 1", message, err)
+	}
+	streamTestWaitForClients(t, 1)
+	streamTestPublish(1, "Sunday 3")
+	streamTestPublish(2, "Youth 2")
+	err = conn.ReadJSON(&message)
+	if err != nil || message.Message != "Youth 2" || message.ID != 5 {
+		t.Errorf("streamed %+v %v, want Youth 2", message, err)
+	}
+}
+
+func TestStreamCheckOrigin(t *testing.T) {
+	newTestApp(t)
+	for _, c := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://notify.local:34935", true},
+		{"http://NOTIFY.local:34935", true},
+		{"http://notify.local", false},
+		{"https://evil.example.com", false},
+		{"://", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://notify.local:34935/api/stream", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if StreamCheckOrigin(r) != c.ok {
+			t.Errorf("origin %q allowed %v, want %v", c.origin, !c.ok, c.ok)
+		}
+	}
+}
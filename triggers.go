@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"text/template"
+)
+
+// Render a trigger message template and send it to an organization using the same routing as send_message.
+// The source is recorded as the actor in the audit log.
+func FireTrigger(org *Org, source, message, priority string, positions []string, data interface{}) {
+	// Render the message.
+	tmpl, err := template.New(source).Parse(message)
+	if err != nil {
+		log.Println("Error parsing", source, "message:", err)
+		return
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		log.Println("Error rendering", source, "message:", err)
+		return
+	}
+
+	// Send the message to the current service.
+	err = SendMessage(org, source, b.String())
+	if err != nil {
+		log.Println("Error sending", source, "message:", err)
+		return
+	}
+
+	// Urgent messages are also sent as text messages.
+	if priority == "urgent" {
+		SendUrgentSMS(org, source, b.String(), positions)
+	}
+}
@@ -4,15 +4,15 @@ import (
 	"fmt"
 	"log"
 	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/agnivade/levenshtein"
 	"github.com/slack-go/slack"
 )
 
-// Update planning center database tables with data from PC API.
-func UpdatePCData() {
+// Update planning center database tables with data from the PC API of an organization.
+func UpdatePCData(org *Org) {
 	// We don't need to update the archive if we already have data from the past,
 	// as such we get the current time and see if we already have an entry in the future.
 	// Its possible that some people only schedule services once a week, so we check with
@@ -20,14 +20,14 @@ func UpdatePCData() {
 	var updateFrom time.Time
 	now := time.Now().UTC()
 	var futurePlan Plans
-	app.db.Where("first_time_at >= ?", now.Add(time.Hour*24*14*-1)).Order("first_time_at ASC").First(&futurePlan)
+	app.db.Where("org = ? AND first_time_at >= ?", org.Name, now.Add(time.Hour*24*14*-1)).Order("first_time_at ASC").First(&futurePlan)
 	if futurePlan.ID != 0 {
 		// If a future plan exists, we update from past 30 days.
 		updateFrom = now.Add(time.Hour * 24 * 30 * -1)
 	}
 
 	// Get all people.
-	allPeople, err := PCGetAll("/services/v2/people")
+	allPeople, err := PCGetAll(org, "/services/v2/people")
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -42,6 +42,7 @@ func UpdatePCData() {
 		app.db.Where("id = ?", id).First(&p)
 
 		// Update all fields with new data.
+		p.Org = org.Name
 		p.UpdatedAt = attributes.GetDate("updated_at")
 		p.ArchivedAt = attributes.GetDate("archived_at")
 		p.Birthdate = attributes.GetDate("birthdate")
@@ -64,7 +65,7 @@ func UpdatePCData() {
 	}
 
 	// Get service types.
-	allServiceTypes, err := PCGetAll("/services/v2/service_types")
+	allServiceTypes, err := PCGetAll(org, "/services/v2/service_types")
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -82,6 +83,7 @@ func UpdatePCData() {
 		app.db.Where("id = ?", id).First(&s)
 
 		// Update fields with new data.
+		s.Org = org.Name
 		s.UpdatedAt = attributes.GetDate("updated_at")
 		s.ArchivedAt = attributes.GetDate("archived_at")
 		s.DeletedAt = attributes.GetDate("deleted_at")
@@ -99,7 +101,7 @@ func UpdatePCData() {
 	}
 
 	// Get service type filter from the config.
-	servicesTypesToPull := app.config.PlanningCenter.ServiceTypeIDs
+	servicesTypesToPull := org.PlanningCenter.ServiceTypeIDs
 	// If no filter, use the found service types above.
 	if len(servicesTypesToPull) == 0 {
 		servicesTypesToPull = allServiceTypeIDs
@@ -108,7 +110,7 @@ func UpdatePCData() {
 	// For each service type, pull plans and plan info.
 	for _, serviceTypeID := range servicesTypesToPull {
 		// Get the plans for this service type.
-		allPlans, err := PCGetAll(fmt.Sprintf("/services/v2/service_types/%d/plans", serviceTypeID))
+		allPlans, err := PCGetAll(org, fmt.Sprintf("/services/v2/service_types/%d/plans", serviceTypeID))
 		if err != nil {
 			log.Fatalln(err)
 		}
@@ -118,113 +120,177 @@ func UpdatePCData() {
 			planID := data.GetUint64("id")
 			attributes := data.GetDict("attributes")
 
-			// Check if plan was already in the database.
-			var p Plans
-			app.db.Where("id = ?", planID).First(&p)
-
-			// Update with new data.
-			p.UpdatedAt = attributes.GetDate("updated_at")
-			p.SeriesTitle = attributes.GetString("series_title")
-			p.Title = attributes.GetString("title")
-			p.FirstTimeAt = attributes.GetDate("sort_date")
-			p.LastTimeAt = attributes.GetDate("last_time_at")
-			p.MultiDay = attributes.GetBool("multi_day")
-			p.Dates = attributes.GetString("dates")
-
 			// If either updated at or first time at for the plan is before the update from date,
 			// we can process the update of data. Otherwise, we ignore this service as we do not care
 			// about updating historic data. Updating historic data causes more API traffic than needed.
-			if p.UpdatedAt.Before(updateFrom) && p.FirstTimeAt.Before(updateFrom) {
+			updatedAt := attributes.GetDate("updated_at")
+			firstTimeAt := attributes.GetDate("sort_date")
+			if updatedAt.Before(updateFrom) && firstTimeAt.Before(updateFrom) {
 				continue
 			}
 
-			// If plan wasn't already created, create it.
-			if p.ID == 0 {
-				p.ID = planID
-				p.CreatedAt = attributes.GetDate("created_at")
-				p.ServiceType = serviceTypeID
-				app.db.Create(&p)
-			} else {
-				// Save plan if already existing.
-				app.db.Save(&p)
+			// Save the plan to the database, skipping its times and people if it could not be saved.
+			err = SavePCPlan(org, serviceTypeID, data)
+			if err != nil {
+				log.Println("Error saving plan:", planID, err)
+				continue
 			}
 
 			// Get all times for this plan.
-			allPlanTimes, err := PCGetAll(fmt.Sprintf("/services/v2/service_types/%d/plans/%d/plan_times", serviceTypeID, planID))
+			allPlanTimes, err := PCGetAll(org, fmt.Sprintf("/services/v2/service_types/%d/plans/%d/plan_times", serviceTypeID, planID))
 			if err != nil {
 				log.Fatalln(err)
 			}
 			// With each time, save it to the database.
 			for _, data := range allPlanTimes {
-				// Get the plan time ID and attributes.
-				id := data.GetUint64("id")
-				attributes := data.GetDict("attributes")
-
-				// Get from database if already existing.
-				var p PlanTimes
-				app.db.Where("id = ?", id).First(&p)
-
-				// Update data.
-				p.UpdatedAt = attributes.GetDate("updated_at")
-				p.Name = attributes.GetString("name")
-				p.TimeType = attributes.GetString("time_type")
-				p.StartsAt = attributes.GetDate("starts_at")
-				p.EndsAt = attributes.GetDate("ends_at")
-				p.LiveStartsAt = attributes.GetDate("live_starts_at")
-				p.LiveEndsAt = attributes.GetDate("live_ends_at")
-
-				// If not already existing, create it.
-				if p.ID == 0 {
-					p.ID = id
-					p.CreatedAt = attributes.GetDate("created_at")
-					p.Plan = planID
-					app.db.Create(&p)
-				} else {
-					// If already existing, save it.
-					app.db.Save(&p)
+				err = SavePCPlanTime(org, planID, data)
+				if err != nil {
+					log.Println("Error saving plan time:", data.GetUint64("id"), err)
 				}
 			}
 
 			// Get all members of the plan.
-			allTeamMembers, err := PCGetAll(fmt.Sprintf("/services/v2/service_types/%d/plans/%d/team_members", serviceTypeID, planID))
+			allTeamMembers, err := PCGetAll(org, fmt.Sprintf("/services/v2/service_types/%d/plans/%d/team_members", serviceTypeID, planID))
 			if err != nil {
 				log.Fatalln(err)
 			}
 			// With each member, update the database.
 			for _, data := range allTeamMembers {
-				// Get the member ID and attributes.
-				id := data.GetUint64("id")
-				attributes := data.GetDict("attributes")
-
-				// Get person data from the database.
-				var p PlanPeople
-				app.db.Where("id = ?", id).First(&p)
-
-				// Update data.
-				p.UpdatedAt = attributes.GetDate("updated_at")
-				p.Status = attributes.GetString("status")
-				p.TeamPositionName = attributes.GetString("team_position_name")
-
-				// If person wasn't existing, create them.
-				if p.ID == 0 {
-					p.ID = id
-					p.CreatedAt = attributes.GetDate("created_at")
-					p.Person = data.GetDict("relationships").GetDict("person").GetDict("data").GetUint64("id")
-					p.Plan = planID
-					app.db.Create(&p)
-				} else {
-					// Otherwise save new info.
-					app.db.Save(&p)
+				err = SavePCPlanPerson(org, planID, data)
+				if err != nil {
+					log.Println("Error saving team member:", data.GetUint64("id"), err)
+				}
+			}
+
+			// Get positions that still need to be filled.
+			allNeededPositions, err := PCGetAll(org, fmt.Sprintf("/services/v2/service_types/%d/plans/%d/needed_positions", serviceTypeID, planID))
+			if err != nil {
+				log.Fatalln(err)
+			}
+			// Needed positions are removed once filled, so replace what we have.
+			app.db.Where("plan = ?", planID).Delete(&NeededPositions{})
+			for _, data := range allNeededPositions {
+				err = SavePCNeededPosition(org, planID, data)
+				if err != nil {
+					log.Println("Error saving needed position:", data.GetUint64("id"), err)
 				}
 			}
 		}
 	}
 }
 
-// Update slack information.
-func UpdateSlackData() {
+// Save a planning center plan of an organization to the database.
+func SavePCPlan(org *Org, serviceTypeID uint64, data PCDict) error {
+	// Get the plan ID and attributes.
+	planID := data.GetUint64("id")
+	attributes := data.GetDict("attributes")
+
+	// Check if plan was already in the database.
+	var p Plans
+	app.db.Where("id = ?", planID).First(&p)
+
+	// Update with new data.
+	p.Org = org.Name
+	p.UpdatedAt = attributes.GetDate("updated_at")
+	p.SeriesTitle = attributes.GetString("series_title")
+	p.Title = attributes.GetString("title")
+	p.FirstTimeAt = attributes.GetDate("sort_date")
+	p.LastTimeAt = attributes.GetDate("last_time_at")
+	p.MultiDay = attributes.GetBool("multi_day")
+	p.Dates = attributes.GetString("dates")
+
+	// If plan wasn't already created, create it.
+	if p.ID == 0 {
+		p.ID = planID
+		p.CreatedAt = attributes.GetDate("created_at")
+		p.ServiceType = serviceTypeID
+		return app.db.Create(&p).Error
+	}
+	// Save plan if already existing.
+	return app.db.Save(&p).Error
+}
+
+// Save a planning center plan time of an organization to the database.
+func SavePCPlanTime(org *Org, planID uint64, data PCDict) error {
+	// Get the plan time ID and attributes.
+	id := data.GetUint64("id")
+	attributes := data.GetDict("attributes")
+
+	// Get from database if already existing.
+	var p PlanTimes
+	app.db.Where("id = ?", id).First(&p)
+
+	// Update data.
+	p.Org = org.Name
+	p.UpdatedAt = attributes.GetDate("updated_at")
+	p.Name = attributes.GetString("name")
+	p.TimeType = attributes.GetString("time_type")
+	p.StartsAt = attributes.GetDate("starts_at")
+	p.EndsAt = attributes.GetDate("ends_at")
+	p.LiveStartsAt = attributes.GetDate("live_starts_at")
+	p.LiveEndsAt = attributes.GetDate("live_ends_at")
+
+	// If not already existing, create it.
+	if p.ID == 0 {
+		p.ID = id
+		p.CreatedAt = attributes.GetDate("created_at")
+		p.Plan = planID
+		return app.db.Create(&p).Error
+	}
+	// If already existing, save it.
+	return app.db.Save(&p).Error
+}
+
+// Save a planning center team member of a plan of an organization to the database.
+func SavePCPlanPerson(org *Org, planID uint64, data PCDict) error {
+	// Get the member ID and attributes.
+	id := data.GetUint64("id")
+	attributes := data.GetDict("attributes")
+
+	// Get person data from the database.
+	var p PlanPeople
+	app.db.Where("id = ?", id).First(&p)
+
+	// Update data.
+	p.Org = org.Name
+	p.UpdatedAt = attributes.GetDate("updated_at")
+	p.Status = attributes.GetString("status")
+	p.TeamPositionName = attributes.GetString("team_position_name")
+
+	// If person wasn't existing, create them.
+	if p.ID == 0 {
+		p.ID = id
+		p.CreatedAt = attributes.GetDate("created_at")
+		p.Person = data.GetDict("relationships").GetDict("person").GetDict("data").GetUint64("id")
+		p.Plan = planID
+		return app.db.Create(&p).Error
+	}
+	// Otherwise save new info.
+	return app.db.Save(&p).Error
+}
+
+// Save a planning center needed position of a plan of an organization to the database.
+func SavePCNeededPosition(org *Org, planID uint64, data PCDict) error {
+	// Get the needed position ID and attributes.
+	attributes := data.GetDict("attributes")
+
+	// Needed positions are replaced on each update, so always create.
+	p := NeededPositions{
+		ID:               data.GetUint64("id"),
+		Org:              org.Name,
+		CreatedAt:        attributes.GetDate("created_at"),
+		UpdatedAt:        attributes.GetDate("updated_at"),
+		TeamPositionName: attributes.GetString("team_position_name"),
+		Quantity:         attributes.GetUint64("quantity"),
+		Plan:             planID,
+	}
+	return app.db.Create(&p).Error
+}
+
+// Update slack information from the workspace of an organization.
+func UpdateSlackData(org *Org) {
 	// Get all users from Slack.
-	users, err := app.slack.GetUsers()
+	users, err := org.slack.GetUsers()
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -234,81 +300,90 @@ func UpdateSlackData() {
 	}
 	// With each user, update the database.
 	for _, user := range users {
-		// Check if user already is in database.
-		var u SlackUsers
-		app.db.Where("id = ?", user.ID).First(&u)
-
-		// Update data.
-		u.Name = user.Name
-		u.RealName = user.RealName
-		u.FirstName = user.Profile.FirstName
-		u.LastName = user.Profile.LastName
-		u.Email = user.Profile.Email
-		u.Phone = user.Profile.Phone
-		u.Deleted = user.Deleted
-		u.IsBot = user.IsBot
-		u.IsAdmin = user.IsAdmin
-		u.IsOwner = user.IsOwner
-		u.IsPrimaryOwner = user.IsPrimaryOwner
-		u.IsRestricted = user.IsRestricted
-		u.IsUltraRestricted = user.IsUltraRestricted
-		u.IsStranger = user.IsStranger
-		u.IsAppUser = user.IsAppUser
-		u.IsInvitedUser = user.IsInvitedUser
-		u.Updated = user.Updated.Time()
-
-		// Try and find a match for this Slack user to the Planning Center people.
-		var people []People
-		// Get all people from Planning Center.
-		app.db.Find(&people)
-		if len(people) != 0 {
-			// For each person, compute how close of a match they are to the Slack user.
-			for i, person := range people {
-				distance := levenshtein.ComputeDistance(u.Name, person.FirstName+" "+person.LastName)
-				newDistance := levenshtein.ComputeDistance(u.RealName, person.FirstName+" "+person.LastName)
-				// The lowest score of the first+lastname match is used.
-				if newDistance < distance {
-					distance = newDistance
-				}
-				// Compute a score of first+last name.
-				newDistance = levenshtein.ComputeDistance(u.FirstName, person.FirstName)
-				newDistance += levenshtein.ComputeDistance(u.LastName, person.LastName)
-				// If this score is lower than the last score, return it.
-				if newDistance < distance {
-					distance = newDistance
-				}
-				// Update the distance on the user for sorting.
-				people[i].Distance = uint64(distance)
-			}
-
-			// Sort all Planning Center people by the score computed.
-			sort.Slice(people, func(i, j int) bool {
-				return people[i].Distance < people[j].Distance
-			})
+		SaveSlackUser(org, user)
+	}
+}
 
-			// Debug output for comparing scores.
-			// for _, person := range people {
-			// 	fmt.Printf("%d %s (%s) %s\n", person.Distance, u.Name, u.RealName, person.FirstName+" "+person.LastName)
-			// }
-
-			// Set the planning center ID to nothing at first.
-			u.PCID = 0
-			// If score of the first person is less than 7,
-			// consider them a match and assign thier ID to the slack user.
-			if people[0].Distance < 7 {
-				u.PCID = people[0].ID
+// Save a slack user of an organization to the database, matching them to a planning center person of the organization.
+func SaveSlackUser(org *Org, user slack.User) {
+	// Check if user already is in database.
+	var u SlackUsers
+	app.db.Where("id = ?", user.ID).First(&u)
+
+	// Update data.
+	u.Org = org.Name
+	u.Name = user.Name
+	u.RealName = user.RealName
+	u.FirstName = user.Profile.FirstName
+	u.LastName = user.Profile.LastName
+	u.Email = user.Profile.Email
+	u.Phone = user.Profile.Phone
+	u.Deleted = user.Deleted
+	u.IsBot = user.IsBot
+	u.IsAdmin = user.IsAdmin
+	u.IsOwner = user.IsOwner
+	u.IsPrimaryOwner = user.IsPrimaryOwner
+	u.IsRestricted = user.IsRestricted
+	u.IsUltraRestricted = user.IsUltraRestricted
+	u.IsStranger = user.IsStranger
+	u.IsAppUser = user.IsAppUser
+	u.IsInvitedUser = user.IsInvitedUser
+	u.Updated = user.Updated.Time()
+
+	// Try and find a match for this Slack user to the Planning Center people,
+	// unless they were matched with the match command.
+	var people []People
+	// Get all people from Planning Center for the organization.
+	if !u.ManualMatch {
+		app.db.Where("org = ?", org.Name).Find(&people)
+	}
+	if len(people) != 0 {
+		// For each person, compute how close of a match they are to the Slack user.
+		for i, person := range people {
+			distance := levenshtein.ComputeDistance(u.Name, person.FirstName+" "+person.LastName)
+			newDistance := levenshtein.ComputeDistance(u.RealName, person.FirstName+" "+person.LastName)
+			// The lowest score of the first+lastname match is used.
+			if newDistance < distance {
+				distance = newDistance
 			}
+			// Compute a score of first+last name.
+			newDistance = levenshtein.ComputeDistance(u.FirstName, person.FirstName)
+			newDistance += levenshtein.ComputeDistance(u.LastName, person.LastName)
+			// If this score is lower than the last score, return it.
+			if newDistance < distance {
+				distance = newDistance
+			}
+			// Update the distance on the user for sorting.
+			people[i].Distance = uint64(distance)
 		}
 
-		// If not already existing in the database, create them.
-		if u.ID == "" {
-			u.ID = user.ID
-			app.db.Create(&u)
-		} else {
-			// if already existing, update the user.
-			app.db.Save(&u)
+		// Sort all Planning Center people by the score computed.
+		sort.Slice(people, func(i, j int) bool {
+			return people[i].Distance < people[j].Distance
+		})
+
+		// Debug output for comparing scores.
+		// for _, person := range people {
+		// 	fmt.Printf("%d %s (%s) %s\n", person.Distance, u.Name, u.RealName, person.FirstName+" "+person.LastName)
+		// }
+
+		// Set the planning center ID to nothing at first.
+		u.PCID = 0
+		// If score of the first person is less than 7,
+		// consider them a match and assign thier ID to the slack user.
+		if people[0].Distance < 7 {
+			u.PCID = people[0].ID
 		}
 	}
+
+	// If not already existing in the database, create them.
+	if u.ID == "" {
+		u.ID = user.ID
+		app.db.Create(&u)
+	} else {
+		// if already existing, update the user.
+		app.db.Save(&u)
+	}
 }
 
 /*
@@ -317,17 +392,17 @@ Delay on channel descript/topic may not be long enough.
 
 */
 
-// Create slack channels for upcoming services.
-func CreateSlackChannels() {
+// Get the time frame in which services of an organization should have slack channels.
+func SlackChannelWindow(org *Org) (startDate, lastDate time.Time) {
 	// Start at now.
 	now := time.Now().UTC()
-	startDate := now
+	startDate = now
 	// If create from weekday is a valid weekday, attempt to turn back the clock to the
 	// most recently past weekday. Use that day as the stating point so we do not
 	// create channels in the future past the date we expect to have channels.
 	// This is useful if you want to run the cron every day to keep channel title
 	// and members up to date, but only want so many channels ahead of a certain weekday.
-	if app.config.Slack.CreateFromWeekday != -1 && app.config.Slack.CreateFromWeekday <= 6 {
+	if org.Slack.CreateFromWeekday != -1 && org.Slack.CreateFromWeekday <= 6 {
 		// Get the current weekday and set the days to subtract to 0.
 		thisWeekday := int(now.Weekday())
 		var daysSub int = 0
@@ -335,224 +410,255 @@ func CreateSlackChannels() {
 		// If this weekday is the day we intend to create from, or if the weekday is
 		// after. We want to just subtract this weekday from create form weekday which
 		// should get us back to the most recent weekday.
-		if thisWeekday >= app.config.Slack.CreateFromWeekday {
-			daysSub = app.config.Slack.CreateFromWeekday - thisWeekday
+		if thisWeekday >= org.Slack.CreateFromWeekday {
+			daysSub = org.Slack.CreateFromWeekday - thisWeekday
 		} else {
 			// Otherwise, we have started a new week from that weekday and we need to
 			// add 7 days to the current weekday in our subtraction. This will bring us
 			// not to the next weekday, but the past weekday.
-			daysSub = app.config.Slack.CreateFromWeekday - (thisWeekday + 7)
+			daysSub = org.Slack.CreateFromWeekday - (thisWeekday + 7)
 		}
 		// Subtract the number of days calculated to bring us to the weekday to create form.
 		startDate = now.Add(time.Hour * 24 * time.Duration(daysSub))
 	}
 	// Last date is start date plus duration of create channels ahead.
-	lastDate := startDate.Add(app.config.Slack.CreateChannelsAhead)
+	lastDate = startDate.Add(org.Slack.CreateChannelsAhead)
+	return
+}
+
+// Create slack channels for upcoming services of an organization, and archive channels for services which have passed.
+func CreateSlackChannels(org *Org) {
+	startDate := ReconcileUpcomingChannels(org, ActorCron)
+	ArchiveSlackChannels(org, ActorCron, startDate)
+}
+
+// Create or update channels for services of an organization in the channel time frame, returning the start of the time frame.
+func ReconcileUpcomingChannels(org *Org, actor string) time.Time {
+	// Get the time frame to create channels for.
+	startDate, lastDate := SlackChannelWindow(org)
 
 	// Get plan times that match.
 	var planTimes []PlanTimes
-	app.db.Where("time_type='service' AND starts_at > ? AND starts_at < ?", startDate, lastDate).Find(&planTimes)
-	// If no plan times matched, exit here.
+	app.db.Where("org = ? AND time_type='service' AND starts_at > ? AND starts_at < ?", org.Name, startDate, lastDate).Find(&planTimes)
+	// If no plan times matched, other organizations may still have services.
 	if len(planTimes) == 0 {
-		log.Fatalln("No services found for this time frame.")
+		log.Println("No services found for this time frame:", org.Name)
+		return startDate
 	}
 
 	// With each plan time found, create a slack channel.
 	for _, planTime := range planTimes {
-		// Get the plan associated with the plan time.
-		var plan Plans
-		app.db.Where("id = ?", planTime.Plan).First(&plan)
-		if plan.ID == 0 {
-			log.Println("Unable to find plan:", planTime.Plan)
-			continue
+		err := ReconcilePlanChannel(actor, planTime)
+		if err != nil {
+			log.Println("Error reconciling channel for plan:", planTime.Plan, err)
 		}
+	}
+	return startDate
+}
 
-		// Get the service type associated with the plan.
-		var serviceType ServiceTypes
-		app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
-		if serviceType.ID == 0 {
-			log.Println("Unable to find service type:", planTime.Plan)
-			continue
-		}
+// Get the topic for a plan based on servie type, and title/series title.
+func PlanTopic(serviceType ServiceTypes, plan Plans) string {
+	topic := serviceType.Name
+	if plan.SeriesTitle == "" && plan.Title != "" {
+		topic = topic + " - " + plan.Title
+	} else if plan.SeriesTitle != "" && plan.Title != "" {
+		topic = topic + " - " + plan.SeriesTitle + " (" + plan.Title + ")"
+	} else if plan.SeriesTitle != "" {
+		topic = topic + " - " + plan.SeriesTitle
+	}
+	return topic
+}
 
-		// Find people assigned to the plan.
-		var peopleOnPlan []PlanPeople
-		app.db.Where("plan = ?", plan.ID).Find(&peopleOnPlan)
-		if len(peopleOnPlan) == 0 {
-			log.Println("No people assigned to plan:", planTime.Plan)
-			continue
-		}
+// Channel reconciliation may be triggered by both the update and webhooks,
+// this lock prevents duplicate channels from being created.
+var reconcileMutex sync.Mutex
+
+// Create or update the channel for a plan time, and invite people assigned to the plan.
+// Actions taken are recorded in the audit log as performed by the actor.
+func ReconcilePlanChannel(actor string, planTime PlanTimes) error {
+	reconcileMutex.Lock()
+	defer reconcileMutex.Unlock()
+
+	// Get the plan associated with the plan time.
+	var plan Plans
+	app.db.Where("id = ?", planTime.Plan).First(&plan)
+	if plan.ID == 0 {
+		log.Println("Unable to find plan:", planTime.Plan)
+		return nil
+	}
 
-		// Check if a channel was already created for this plan.
-		var channel SlackChannels
-		app.db.Where("pc_plan = ?", plan.ID).First(&channel)
-
-		// Set the topic/description based on servie type, and title/series title.
-		topic := serviceType.Name
-		if plan.SeriesTitle == "" && plan.Title != "" {
-			topic = topic + " - " + plan.Title
-		} else if plan.SeriesTitle != "" && plan.Title != "" {
-			topic = topic + " - " + plan.SeriesTitle + " (" + plan.Title + ")"
-		} else if plan.SeriesTitle != "" {
-			topic = topic + " - " + plan.SeriesTitle
-		}
+	// Channels are created in the workspace of the organization of the plan.
+	org := OrgByName(plan.Org)
+	if org == nil {
+		return fmt.Errorf("unknown organization %q for plan %d", plan.Org, plan.ID)
+	}
 
-		// If the channel already exists, we do not need to create it...
-		// However, we should check if the description is changed
-		// and we should check if people were added.
-		if channel.ID != "" {
-			if channel.Description != topic {
-				app.slack.SetTopicOfConversation(channel.ID, topic)
-				app.slack.SetPurposeOfConversation(channel.ID, topic)
-				channel.Description = topic
-				app.db.Save(&channel)
-			}
-		} else {
-			// If the channel is being created, set the name to the starts at date.
-			channel.Name = planTime.StartsAt.Format("2006-01-02")
-			// Its possible that a duplicate channel already exists, if so we should append
-			// a channel number. Duplicate channels typically happen if multiple plans
-			// exists on the same day.
-			startingID := 1
-			for {
-				var duplicateChannel SlackChannels
-				app.db.Where("name = ?", channel.Name).First(&duplicateChannel)
-				if duplicateChannel.ID == "" {
-					break
-				}
-				startingID++
-				channel.Name = fmt.Sprintf("%s_%d", planTime.StartsAt.Format("2006-01-02"), startingID)
-			}
+	// Get the service type associated with the plan.
+	var serviceType ServiceTypes
+	app.db.Where("id = ?", plan.ServiceType).First(&serviceType)
+	if serviceType.ID == 0 {
+		log.Println("Unable to find service type:", planTime.Plan)
+		return nil
+	}
 
-			// Create the channel.
-			channelInfo := slack.CreateConversationParams{
-				ChannelName: channel.Name,
-				IsPrivate:   true,
-			}
-			log.Println("Creating channel:", channel.Name)
-			schan, err := app.slack.CreateConversation(channelInfo)
-			if err != nil {
-				log.Fatalln("Failed to create channel:", err)
-			}
+	// Find people assigned to the plan.
+	var peopleOnPlan []PlanPeople
+	app.db.Where("plan = ?", plan.ID).Find(&peopleOnPlan)
+	if len(peopleOnPlan) == 0 {
+		log.Println("No people assigned to plan:", planTime.Plan)
+		return nil
+	}
 
-			// If topic is defined, set the topic and purpose.
-			if topic != "" {
-				// Keep count of failures so we can try again.
-				failed := 0
-				_, err = app.slack.SetTopicOfConversation(schan.ID, topic)
-				if err != nil {
-					failed++
-					log.Println("Failed to set topic:", err)
-				}
-				_, err = app.slack.SetPurposeOfConversation(schan.ID, topic)
-				if err != nil {
-					failed++
-					log.Println("Failed to set purpose:", err)
-				}
-				// If it failed, make topic empty so we can try again next run.
-				if failed != 0 {
-					topic = ""
-				}
-			}
+	// Check if a channel was already created for this plan.
+	var channel SlackChannels
+	app.db.Where("pc_plan = ?", plan.ID).First(&channel)
+
+	// Use the notifier the channel was created with, otherwise the one for the service type.
+	notifierName := channel.Notifier
+	if channel.ID == "" || notifierName == "" {
+		notifierName = NotifierNameForServiceType(plan.ServiceType)
+	}
+	notifier := NotifierAs(org, notifierName, actor)
 
-			// Save the channel to the database.
-			channel.ID = schan.ID
-			channel.PCPlan = planTime.Plan
-			channel.StartsAt = planTime.StartsAt
-			channel.EndsAt = planTime.EndsAt
+	// Set the topic/description based on servie type, and title/series title.
+	topic := PlanTopic(serviceType, plan)
+
+	// If the channel already exists, we do not need to create it...
+	// However, we should check if the description is changed
+	// and we should check if people were added.
+	if channel.ID != "" {
+		if channel.Description != topic {
+			err := notifier.SetTopic(channel.ID, topic)
+			if err != nil {
+				log.Println("Failed to set topic:", err)
+			}
 			channel.Description = topic
-			app.db.Create(&channel)
+			app.db.Save(&channel)
+		}
+	} else {
+		// If the channel is being created, set the name to the starts at date.
+		channel.Name = planTime.StartsAt.Format("2006-01-02")
+		// Its possible that a duplicate channel already exists, if so we should append
+		// a channel number. Duplicate channels typically happen if multiple plans
+		// exists on the same day. Other organizations have their own workspace.
+		startingID := 1
+		for {
+			var duplicateChannel SlackChannels
+			app.db.Where("org = ? AND name = ?", org.Name, channel.Name).First(&duplicateChannel)
+			if duplicateChannel.ID == "" {
+				break
+			}
+			startingID++
+			channel.Name = fmt.Sprintf("%s_%d", planTime.StartsAt.Format("2006-01-02"), startingID)
 		}
 
-		// Get the previous users that were invited to the channel.
-		invited := strings.Split(channel.UsersInvited, ",")
-		// If nothing is previous, reset the slice to nil.
-		if len(invited) == 1 && invited[0] == "" {
-			invited = nil
+		// Create the channel.
+		log.Println("Creating channel:", channel.Name)
+		channelID, err := notifier.CreateConversation(channel.Name)
+		if err != nil {
+			return fmt.Errorf("failed to create channel: %s", err)
 		}
 
-		// Keep a list of users we need to invite as they are new.
-		var usersToInvite []string
-
-		// For each sticky user, invite them.
-		for _, stickyUser := range app.config.Slack.StickyUsers {
-			// Check if they were already invited.
-			alreadyInvited := false
-			for _, uid := range invited {
-				if uid == stickyUser {
-					alreadyInvited = true
-					break
-				}
+		// If topic is defined, set the topic and purpose.
+		if topic != "" {
+			err = notifier.SetTopic(channelID, topic)
+			// If it failed, make topic empty so we can try again next run.
+			if err != nil {
+				log.Println("Failed to set topic:", err)
+				topic = ""
 			}
+		}
 
-			// Make sure they were not already added to the list of users.
-			for _, uid := range usersToInvite {
-				if uid == stickyUser {
-					alreadyInvited = true
-					break
-				}
-			}
+		// Save the channel to the database.
+		channel.ID = channelID
+		channel.Org = org.Name
+		channel.Notifier = notifierName
+		channel.PCPlan = planTime.Plan
+		channel.StartsAt = planTime.StartsAt
+		channel.EndsAt = planTime.EndsAt
+		channel.Description = topic
+		app.db.Create(&channel)
+		DispatchEvent(EventChannelCreated, ChannelEvent{
+			Channel:     channel,
+			Plan:        plan,
+			ServiceType: serviceType,
+		})
+	}
 
-			// If not already invited, add to the list of users to invite.
-			if !alreadyInvited {
-				usersToInvite = append(usersToInvite, stickyUser)
-			}
-		}
+	// Get the users who have been members of the channel.
+	members := make(map[string]ChannelMembers)
+	for _, member := range ChannelMembersOf(channel.ID) {
+		members[member.UserID] = member
+	}
 
-		// For each person on the plan, see if we need to invite them.
-		for _, personOnPlan := range peopleOnPlan {
-			// Find the slack user for the planning center person.
-			var slackUser SlackUsers
-			app.db.Where("pc_id = ?", personOnPlan.Person).First(&slackUser)
-			if slackUser.ID == "" {
-				continue
+	// Keep a list of users we need to invite as they are new, with the reason for inviting them.
+	var usersToInvite []string
+	reasons := make(map[string]string)
+	addUser := func(userID, reason string) {
+		// Skip users already added to the list, as a person can be assigned to multiple teams on a plan.
+		if reasons[userID] != "" {
+			return
+		}
+		// Skip users already members or who can not be invited. Users who left the channel
+		// are not invited back unless they are now invited for another reason.
+		if member, ok := members[userID]; ok {
+			left := member.RemovedAt.Unix() > 0 && member.Reason == reason
+			if member.Active() || member.PermanentFailure || left {
+				return
 			}
+		}
+		usersToInvite = append(usersToInvite, userID)
+		reasons[userID] = reason
+	}
 
-			// Check if they were already invited.
-			alreadyInvited := false
-			for _, uid := range invited {
-				if uid == slackUser.ID {
-					alreadyInvited = true
-					break
-				}
-			}
+	// For each sticky user, invite them.
+	for _, stickyUser := range NotifierStickyUsers(org, notifierName) {
+		addUser(stickyUser, ChannelMemberSticky)
+	}
 
-			// Make sure they were not already added to the list of users.
-			// A person can be assigned to multiple teams on a plan.
-			for _, uid := range usersToInvite {
-				if uid == slackUser.ID {
-					alreadyInvited = true
-					break
-				}
-			}
+	// People who are not matched to a user, which we will email instead.
+	var unmatched []PlanPeople
 
-			// If not already invited, add to the list of users to invite.
-			if !alreadyInvited {
-				usersToInvite = append(usersToInvite, slackUser.ID)
-			}
+	// For each person on the plan, see if we need to invite them.
+	for _, personOnPlan := range peopleOnPlan {
+		// Find the user for the planning center person.
+		userID := notifier.UserForPerson(personOnPlan.Person)
+		if userID == "" {
+			unmatched = append(unmatched, personOnPlan)
+			continue
 		}
+		addUser(userID, ChannelMemberPosition)
+	}
 
-		// If there are users to invite, invite them.
-		if len(usersToInvite) != 0 {
-			// Update the invited users list.
-			invited = append(invited, usersToInvite...)
-			channel.UsersInvited = strings.Join(invited, ",")
-			// Invite the users.
-			_, err := app.slack.InviteUsersToConversation(channel.ID, usersToInvite...)
-			if err != nil {
-				log.Println("Failed to invite users to channel:", err)
-			}
-			// Update the channel on database with the new list of users invited.
-			app.db.Save(&channel)
+	// If there are users to invite, invite them.
+	if len(usersToInvite) != 0 {
+		invited, failed := InviteChannelMembers(notifier, channel.ID, usersToInvite, reasons)
+		if len(invited) != 0 {
+			DispatchEvent(EventUsersInvited, ChannelEvent{
+				Channel:     channel,
+				Plan:        plan,
+				ServiceType: serviceType,
+				Users:       invited,
+			})
 		}
+		NotifyInviteFailures(org, actor, notifierName, channel, failed)
 	}
 
+	// Email people who could not be invited, if email is configured.
+	if org.Email.Host != "" && len(unmatched) != 0 {
+		EmailAnnouncements(org, actor, planTime, unmatched)
+	}
+	return nil
+}
+
+// Archive slack channels of an organization for services which started before the start date.
+func ArchiveSlackChannels(org *Org, actor string, startDate time.Time) {
 	// Find old channels to archive. Any channel which start at date is before the start date.
 	var channelsToArchive []SlackChannels
-	app.db.Where("starts_at < ? AND archived != 1", startDate).Find(&channelsToArchive)
+	app.db.Where("org = ? AND starts_at < ? AND archived != 1", org.Name, startDate).Find(&channelsToArchive)
 	// Archive channels which are old.
 	for _, channel := range channelsToArchive {
-		err := app.slack.ArchiveConversation(channel.ID)
+		err := NotifierAs(org, channel.Notifier, actor).Archive(channel.ID)
 		if err != nil {
 			log.Println("Error closing old channel:", err)
 		}
@@ -561,3 +667,28 @@ func CreateSlackChannels() {
 		app.db.Save(&channel)
 	}
 }
+
+// Reconcile the slack channel for a single plan, if the plan has a service within the channel time frame.
+func ReconcilePlan(actor string, planID uint64) error {
+	// Get the time frame channels should exist for in the organization of the plan.
+	var plan Plans
+	app.db.Where("id = ?", planID).First(&plan)
+	if plan.ID == 0 {
+		return nil
+	}
+	org := OrgByName(plan.Org)
+	if org == nil {
+		return fmt.Errorf("unknown organization %q for plan %d", plan.Org, planID)
+	}
+	startDate, lastDate := SlackChannelWindow(org)
+
+	// Find the first service time for this plan within the time frame.
+	var planTime PlanTimes
+	app.db.Where("plan = ? AND time_type='service' AND starts_at > ? AND starts_at < ?", planID, startDate, lastDate).Order("starts_at ASC").First(&planTime)
+	if planTime.ID == 0 {
+		return nil
+	}
+
+	// Reconcile the channel for the service time found.
+	return ReconcilePlanChannel(actor, planTime)
+}
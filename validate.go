@@ -0,0 +1,487 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Configuration keys which are deprecated, with what to use instead.
+var DeprecatedConfigKeys = map[string]string{
+	"http.api_key": "use http.api_keys so actions are recorded with the name of the key",
+}
+
+// A problem with the configuration, with the path to the field such as notifiers[0].token.
+type ConfigProblem struct {
+	Path    string
+	Message string
+	Warning bool
+}
+
+// Format the problem for logging.
+func (p ConfigProblem) String() string {
+	if p.Warning {
+		return "warning: " + p.Path + ": " + p.Message
+	}
+	return "error: " + p.Path + ": " + p.Message
+}
+
+// Problems found while validating the configuration.
+type ConfigProblems []ConfigProblem
+
+// Add an error for a field.
+func (p *ConfigProblems) Errorf(path, format string, args ...interface{}) {
+	*p = append(*p, ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Add a warning for a field.
+func (p *ConfigProblems) Warnf(path, format string, args ...interface{}) {
+	*p = append(*p, ConfigProblem{Path: path, Message: fmt.Sprintf(format, args...), Warning: true})
+}
+
+// Check if any of the problems are errors.
+func (p ConfigProblems) HasErrors() bool {
+	for _, problem := range p {
+		if !problem.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a required value is set.
+func (p *ConfigProblems) Required(path, value string) {
+	if value == "" {
+		p.Errorf(path, "is required")
+	}
+}
+
+// Check a value is one of the allowed values.
+func (p *ConfigProblems) OneOf(path, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var quoted []string
+	for _, a := range allowed {
+		if a != "" {
+			quoted = append(quoted, fmt.Sprintf("%q", a))
+		}
+	}
+	p.Errorf(path, "unknown value %q, expected %s", value, strings.Join(quoted, ", "))
+}
+
+// Check a number is within a range.
+func (p *ConfigProblems) Range(path string, value, min, max int) {
+	if value < min || value > max {
+		p.Errorf(path, "%d is out of range, expected %d to %d", value, min, max)
+	}
+}
+
+// Check a URL is absolute, if set.
+func (p *ConfigProblems) URL(path, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		p.Errorf(path, "%q is not an absolute URL", value)
+	}
+}
+
+// Check a message template parses, with the functions available to it.
+func (p *ConfigProblems) Template(path, value string, funcs template.FuncMap) {
+	_, err := template.New(path).Funcs(funcs).Parse(value)
+	if err != nil {
+		p.Errorf(path, "invalid template: %s", err)
+	}
+}
+
+// Check a regular expression compiles, if set.
+func (p *ConfigProblems) Regexp(path, value string) {
+	if value == "" {
+		return
+	}
+	_, err := regexp.Compile(value)
+	if err != nil {
+		p.Errorf(path, "invalid regular expression: %s", err)
+	}
+}
+
+// Validate the configuration, returning errors and warnings.
+func (c *Config) Validate() (p ConfigProblems) {
+	// HTTP.
+	if c.HTTP.Port == 0 || c.HTTP.Port > 65535 {
+		p.Errorf("http.port", "%d is not a valid port", c.HTTP.Port)
+	}
+	if c.HTTP.StreamReplay < 0 {
+		p.Errorf("http.stream_replay", "must not be negative")
+	}
+	apiKeyNames := make(map[string]bool)
+	for i, apiKey := range c.HTTP.APIKeys {
+		path := fmt.Sprintf("http.api_keys[%d]", i)
+		p.Required(path+".name", apiKey.Name)
+		p.Required(path+".key", apiKey.Key)
+		if apiKeyNames[apiKey.Name] {
+			p.Errorf(path+".name", "%q is used by another key", apiKey.Name)
+		}
+		apiKeyNames[apiKey.Name] = true
+	}
+
+	// Database.
+	p.OneOf("database.type", c.DB.Type, "sqlite3", "mysql", "postgres")
+	p.Required("database.connection", c.DB.Connection)
+
+	// Organizations, or the top level configuration as the only organization.
+	orgNames := map[string]bool{}
+	orgAppIDs := map[string]string{}
+	orgTokens := map[string]string{}
+	if len(c.Organizations) == 0 {
+		orgNames[DefaultOrgName] = true
+		p.organization("", &c.PlanningCenter, &c.Slack, &c.Reminders)
+	} else {
+		// The top level configuration is not used with organizations, so would be silently ignored.
+		if !reflect.DeepEqual(c.PlanningCenter, PlanningCenterConfig{}) {
+			p.Errorf("planning_center", "is not used with organizations, configure it in each organization")
+		}
+		if !reflect.DeepEqual(c.Slack, DefaultSlackConfig) {
+			p.Errorf("slack", "is not used with organizations, configure it in each organization")
+		}
+		if !reflect.DeepEqual(c.Reminders, DefaultRemindersConfig) {
+			p.Errorf("reminders", "is not used with organizations, configure it in each organization")
+		}
+	}
+	for i := range c.Organizations {
+		org := &c.Organizations[i]
+		path := fmt.Sprintf("organizations[%d]", i)
+		p.Required(path+".name", org.Name)
+		if org.Name != "" && orgNames[org.Name] {
+			p.Errorf(path+".name", "%q is used by another organization", org.Name)
+		}
+		orgNames[org.Name] = true
+		p.organization(path+".", &org.PlanningCenter, &org.Slack, &org.Reminders)
+		p.email(path+".", &org.Email)
+
+		// Synced data is keyed by the IDs of Planning Center and Slack,
+		// so organizations sharing an account would overwrite each other's data.
+		if other, ok := orgAppIDs[org.PlanningCenter.AppID]; ok && org.PlanningCenter.AppID != "" {
+			p.Errorf(path+".planning_center.app_id", "used by organization %q", other)
+		} else {
+			orgAppIDs[org.PlanningCenter.AppID] = org.Name
+		}
+		if other, ok := orgTokens[org.Slack.APIToken]; ok && org.Slack.APIToken != "" {
+			p.Errorf(path+".slack.api_token", "used by organization %q", other)
+		} else {
+			orgTokens[org.Slack.APIToken] = org.Name
+		}
+	}
+	knownOrg := func(path, name string) {
+		if name != "" && !orgNames[name] {
+			p.Errorf(path, "unknown organization %q", name)
+		}
+	}
+	for i, apiKey := range c.HTTP.APIKeys {
+		knownOrg(fmt.Sprintf("http.api_keys[%d].organization", i), apiKey.Organization)
+	}
+	knownOrg("osc.organization", c.OSC.Organization)
+	knownOrg("midi.organization", c.MIDI.Organization)
+	knownOrg("propresenter.organization", c.ProPresenter.Organization)
+
+	// Countdowns.
+	for i, countdown := range c.Countdowns {
+		path := fmt.Sprintf("countdowns[%d]", i)
+		p.Required(path+".time_type", countdown.TimeType)
+		p.Required(path+".message", countdown.Message)
+		p.Template(path+".message", countdown.Message, nil)
+	}
+
+	// Notifiers.
+	notifierNames := map[string]bool{SlackNotifierName: true}
+	for i, notifier := range c.Notifiers {
+		path := fmt.Sprintf("notifiers[%d]", i)
+		p.Required(path+".name", notifier.Name)
+		if notifier.Name != "" && notifierNames[notifier.Name] {
+			p.Errorf(path+".name", "%q is used by another notifier", notifier.Name)
+		}
+		notifierNames[notifier.Name] = true
+		p.OneOf(path+".type", notifier.Type, "discord", "mattermost")
+		p.Required(path+".token", notifier.Token)
+		p.URL(path+".base_url", notifier.BaseURL)
+		switch notifier.Type {
+		case "discord":
+			p.Required(path+".guild_id", notifier.GuildID)
+		case "mattermost":
+			p.Required(path+".base_url", notifier.BaseURL)
+			p.Required(path+".team_id", notifier.TeamID)
+		}
+	}
+
+	// Email.
+	p.email("", &c.Email)
+
+	// Text messages.
+	p.OneOf("sms.provider", c.SMS.Provider, "", "twilio")
+	if c.SMS.Provider != "" {
+		p.Required("sms.account_sid", c.SMS.AccountSID)
+		p.Required("sms.auth_token", c.SMS.AuthToken)
+		p.Required("sms.from", c.SMS.From)
+		p.URL("sms.base_url", c.SMS.BaseURL)
+		p.URL("sms.webhook_url", c.SMS.WebhookURL)
+	}
+
+	// Outgoing webhooks.
+	for i, webhook := range c.OutgoingWebhooks {
+		path := fmt.Sprintf("outgoing_webhooks[%d]", i)
+		p.Required(path+".url", webhook.URL)
+		p.URL(path+".url", webhook.URL)
+		for j, event := range webhook.Events {
+			p.OneOf(fmt.Sprintf("%s.events[%d]", path, j), event, EventChannelCreated, EventUsersInvited, EventServiceStarted, EventMessageSent)
+		}
+	}
+
+	// OSC.
+	p.OneOf("osc.framing", c.OSC.Framing, "", "slip", "length")
+	if c.OSC.Port > 65535 {
+		p.Errorf("osc.port", "%d is not a valid port", c.OSC.Port)
+	}
+	for i, mapping := range c.OSC.Mappings {
+		path := fmt.Sprintf("osc.mappings[%d]", i)
+		if !strings.HasPrefix(mapping.Address, "/") {
+			p.Errorf(path+".address", "%q must start with /", mapping.Address)
+		}
+		p.Required(path+".message", mapping.Message)
+		p.Template(path+".message", mapping.Message, nil)
+		p.OneOf(path+".priority", mapping.Priority, "", "urgent")
+	}
+
+	// MIDI.
+	if c.MIDI.Port > 65534 {
+		p.Errorf("midi.port", "%d is not a valid port, the data port follows it", c.MIDI.Port)
+	}
+	for i, mapping := range c.MIDI.Mappings {
+		path := fmt.Sprintf("midi.mappings[%d]", i)
+		p.OneOf(path+".type", mapping.Type, MIDINoteOn, MIDINoteOff, MIDIControlChange, MIDIProgramChange)
+		p.Range(path+".channel", mapping.Channel, 0, 16)
+		if mapping.Number != nil {
+			p.Range(path+".number", *mapping.Number, 0, 127)
+		}
+		p.Range(path+".min_value", mapping.MinValue, 0, 127)
+		p.Required(path+".message", mapping.Message)
+		p.Template(path+".message", mapping.Message, nil)
+		p.OneOf(path+".priority", mapping.Priority, "", "urgent")
+	}
+
+	// ProPresenter.
+	p.URL("propresenter.url", c.ProPresenter.URL)
+	p.OneOf("propresenter.mode", c.ProPresenter.Mode, "", "stream", "poll")
+	if c.ProPresenter.URL != "" && c.ProPresenter.PollInterval <= 0 {
+		p.Errorf("propresenter.poll_interval", "must be greater than zero")
+	}
+	for i, rule := range c.ProPresenter.Rules {
+		path := fmt.Sprintf("propresenter.rules[%d]", i)
+		p.Regexp(path+".presentation", rule.Presentation)
+		p.Regexp(path+".slide_label", rule.SlideLabel)
+		if rule.SlideIndex != nil && *rule.SlideIndex < 0 {
+			p.Errorf(path+".slide_index", "must not be negative")
+		}
+		p.Required(path+".message", rule.Message)
+		p.Template(path+".message", rule.Message, nil)
+		p.OneOf(path+".priority", rule.Priority, "", "urgent")
+	}
+
+	// Dashboard.
+	if c.Dashboard.Enabled {
+		if !c.HTTP.HasAPIKeys() && c.Dashboard.SlackClientID == "" {
+			p.Errorf("dashboard", "requires http.api_keys or dashboard.slack_client_id to sign in")
+		}
+		if c.Dashboard.SlackClientID != "" {
+			p.Required("dashboard.slack_client_secret", c.Dashboard.SlackClientSecret)
+			p.Required("dashboard.slack_redirect_url", c.Dashboard.SlackRedirectURL)
+			p.URL("dashboard.slack_redirect_url", c.Dashboard.SlackRedirectURL)
+		}
+		if c.Dashboard.SessionLifetime <= 0 {
+			p.Errorf("dashboard.session_lifetime", "must be greater than zero")
+		}
+		if c.Dashboard.SessionSecret == "" {
+			p.Warnf("dashboard.session_secret", "not set, people are signed out when the service restarts")
+		}
+	}
+
+	// Calendar feeds.
+	if c.ICal.Enabled {
+		p.Required("ical.public_url", c.ICal.PublicURL)
+		p.URL("ical.public_url", c.ICal.PublicURL)
+		_, err := time.LoadLocation(c.ICal.TimeZone)
+		if err != nil {
+			p.Errorf("ical.time_zone", "unknown time zone %q", c.ICal.TimeZone)
+		}
+	}
+	return
+}
+
+// Validate an email configuration if a host is set, with paths prefixed by where it is configured.
+func (p *ConfigProblems) email(prefix string, email *EmailConfig) {
+	if email.Host == "" {
+		return
+	}
+	p.Required(prefix+"email.from", email.From)
+	if email.Port == 0 || email.Port > 65535 {
+		p.Errorf(prefix+"email.port", "%d is not a valid port", email.Port)
+	}
+	if email.BatchSize <= 0 {
+		p.Errorf(prefix+"email.batch_size", "must be greater than zero")
+	}
+	p.Template(prefix+"email.announcement_subject", email.AnnouncementSubject, EmailTemplateFuncs)
+	p.Template(prefix+"email.announcement_text", email.AnnouncementText, EmailTemplateFuncs)
+	p.Template(prefix+"email.announcement_html", email.AnnouncementHTML, EmailTemplateFuncs)
+	p.Template(prefix+"email.message_subject", email.MessageSubject, EmailTemplateFuncs)
+	p.Template(prefix+"email.message_text", email.MessageText, EmailTemplateFuncs)
+	p.Template(prefix+"email.message_html", email.MessageHTML, EmailTemplateFuncs)
+}
+
+// Validate the Planning Center, Slack and reminders configuration of an organization,
+// with paths prefixed by where the organization is configured.
+func (p *ConfigProblems) organization(prefix string, pc *PlanningCenterConfig, sc *SlackConfig, reminders *RemindersConfig) {
+	// Planning Center.
+	p.Required(prefix+"planning_center.app_id", pc.AppID)
+	p.Required(prefix+"planning_center.secret", pc.Secret)
+
+	// Slack.
+	p.Required(prefix+"slack.api_token", sc.APIToken)
+	p.Range(prefix+"slack.create_from_weekday", sc.CreateFromWeekday, -1, 6)
+	if sc.CreateChannelsAhead <= 0 {
+		p.Errorf(prefix+"slack.create_channels_ahead", "must be greater than zero")
+	}
+	if sc.SigningSecret == "" {
+		p.Warnf(prefix+"slack.signing_secret", "not set, slash commands, events and interactions are rejected")
+	}
+
+	// Reminders.
+	if reminders.CoordinatorChannel != "" && reminders.Interval <= 0 {
+		p.Errorf(prefix+"reminders.interval", "must be greater than zero")
+	}
+	if reminders.NudgeUnconfirmed && reminders.NudgeInterval <= 0 {
+		p.Errorf(prefix+"reminders.nudge_interval", "must be greater than zero")
+	}
+	for i, weekday := range reminders.Weekdays {
+		p.Range(fmt.Sprintf("%sreminders.weekdays[%d]", prefix, i), weekday, 0, 6)
+	}
+	p.Range(prefix+"reminders.quiet_hours_start", reminders.QuietHoursStart, 0, 23)
+	p.Range(prefix+"reminders.quiet_hours_end", reminders.QuietHoursEnd, 0, 23)
+}
+
+// Find keys in a configuration file which are unknown or deprecated.
+func ConfigKeyProblems(configFile string) (p ConfigProblems) {
+	doc := ReadConfigDocument(configFile)
+	if doc == nil {
+		return
+	}
+	configKeyProblems(&p, "", doc, reflect.TypeOf(Config{}))
+	return
+}
+
+// Check the keys of a value against the fields of a type.
+func configKeyProblems(p *ConfigProblems, path string, value interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+			return
+		}
+		// Sort keys so problems are in a consistent order.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			field, ok := configField(t, key)
+			if !ok {
+				p.Warnf(keyPath, "unknown key")
+				continue
+			}
+			if reason, ok := DeprecatedConfigKeys[keyPath]; ok {
+				p.Warnf(keyPath, "deprecated, %s", reason)
+			}
+			configKeyProblems(p, keyPath, v[key], field.Type)
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return
+		}
+		for i, item := range v {
+			configKeyProblems(p, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())
+		}
+	}
+}
+
+// Find the field of a configuration struct with a key.
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("fig"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Check the database can be connected to.
+func CheckDatabase() error {
+	db, err := OpenDatabase(app.config.DB)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	return sqlDB.Ping()
+}
+
+// Client used to check connectivity, so an unresponsive service fails the check instead of waiting.
+var CheckClient = &http.Client{Timeout: 15 * time.Second}
+
+// Check the Planning Center API accepts the credentials of an organization.
+func CheckPlanningCenter(org *Org) error {
+	req, err := NewPCRequest(org, "/services/v2")
+	if err != nil {
+		return err
+	}
+	res, err := CheckClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// Check the Slack API accepts the token of an organization.
+func CheckSlack(org *Org) error {
+	api := slack.New(org.Slack.APIToken, slack.OptionAPIURL(SlackAPIURL), slack.OptionHTTPClient(CheckClient))
+	_, err := api.AuthTest()
+	return err
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A configuration without problems.
+func validTestConfig() *Config {
+	c := &Config{}
+	c.HTTP.Port = 34935
+	c.HTTP.APIKeys = []APIKeyConfig{{Name: "stage", Key: "k"}}
+	c.DB = DBConfig{Type: "sqlite3", Connection: "test.db"}
+	c.PlanningCenter = PlanningCenterConfig{AppID: "app", Secret: "secret"}
+	c.Slack.APIToken = "xoxb-token"
+	c.Slack.SigningSecret = "signing"
+	c.Slack.CreateChannelsAhead = 7 * 24 * time.Hour
+	return c
+}
+
+// An organization without problems.
+func validTestOrg(name, appID, token string) OrganizationConfig {
+	org := OrganizationConfig{Name: name}
+	org.PlanningCenter = PlanningCenterConfig{AppID: appID, Secret: "secret"}
+	org.Slack.APIToken = token
+	org.Slack.SigningSecret = "signing"
+	org.Slack.CreateChannelsAhead = 7 * 24 * time.Hour
+	return org
+}
+
+func TestValidate(t *testing.T) {
+	problems := validTestConfig().Validate()
+	if len(problems) != 0 {
+		t.Fatalf("valid configuration has problems %v", problems)
+	}
+
+	for _, c := range []struct {
+		name   string
+		change func(c *Config)
+		path   string // Path of the error expected.
+	}{
+		{"port", func(c *Config) { c.HTTP.Port = 0 }, "http.port"},
+		{"api key name", func(c *Config) { c.HTTP.APIKeys[0].Name = "" }, "http.api_keys[0].name"},
+		{"api key duplicate", func(c *Config) {
+			c.HTTP.APIKeys = append(c.HTTP.APIKeys, APIKeyConfig{Name: "stage", Key: "k2"})
+		}, "http.api_keys[1].name"},
+		{"api key organization", func(c *Config) { c.HTTP.APIKeys[0].Organization = "north" }, "http.api_keys[0].organization"},
+		{"database type", func(c *Config) { c.DB.Type = "oracle" }, "database.type"},
+		{"app id", func(c *Config) { c.PlanningCenter.AppID = "" }, "planning_center.app_id"},
+		{"slack token", func(c *Config) { c.Slack.APIToken = "" }, "slack.api_token"},
+		{"weekday", func(c *Config) { c.Slack.CreateFromWeekday = 7 }, "slack.create_from_weekday"},
+		{"organization name", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("", "app", "xoxb-token")}
+		}, "organizations[0].name"},
+		{"organization duplicate", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app1", "xoxb-1"), validTestOrg("north", "app2", "xoxb-2")}
+		}, "organizations[1].name"},
+		{"organization shared account", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app", "xoxb-1"), validTestOrg("south", "app", "xoxb-2")}
+		}, "organizations[1].planning_center.app_id"},
+		{"organization shared workspace", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app1", "xoxb-1"), validTestOrg("south", "app2", "xoxb-1")}
+		}, "organizations[1].slack.api_token"},
+		{"organization secret", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app", "xoxb-token")}
+			c.Organizations[0].PlanningCenter.Secret = ""
+		}, "organizations[0].planning_center.secret"},
+		{"organization reminders", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app", "xoxb-token")}
+			c.Organizations[0].Reminders.Weekdays = []int{1, 9}
+		}, "organizations[0].reminders.weekdays[1]"},
+		{"top level with organizations", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app", "xoxb-token")}
+		}, "planning_center"},
+		{"unknown organization", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app", "xoxb-token")}
+			c.OSC.Organization = "south"
+		}, "osc.organization"},
+		{"notifier type", func(c *Config) {
+			c.Notifiers = []NotifierConfig{{Name: "chat", Type: "irc", Token: "t"}}
+		}, "notifiers[0].type"},
+		{"notifier reserved name", func(c *Config) {
+			c.Notifiers = []NotifierConfig{{Name: SlackNotifierName, Type: "discord", Token: "t", GuildID: "G"}}
+		}, "notifiers[0].name"},
+		{"mattermost team", func(c *Config) {
+			c.Notifiers = []NotifierConfig{{Name: "mm", Type: "mattermost", Token: "t", BaseURL: "https://mm.example.com"}}
+		}, "notifiers[0].team_id"},
+		{"email template", func(c *Config) {
+			c.Email = EmailConfig{Host: "smtp.example.com", Port: 25, From: "a@example.com", BatchSize: 1, MessageHTML: "{{.Message"}
+		}, "email.message_html"},
+		{"organization email", func(c *Config) {
+			c.Organizations = []OrganizationConfig{validTestOrg("north", "app", "xoxb-token")}
+			c.Organizations[0].Email = DefaultEmailConfig
+			c.Organizations[0].Email.Host = "smtp.north.example.com"
+		}, "organizations[0].email.from"},
+		{"webhook event", func(c *Config) {
+			c.OutgoingWebhooks = []OutgoingWebhookConfig{{URL: "https://example.com", Events: []string{"plan.deleted"}}}
+		}, "outgoing_webhooks[0].events[0]"},
+		{"osc address", func(c *Config) {
+			c.OSC.Mappings = []OSCMappingConfig{{Address: "cue", Message: "Go"}}
+		}, "osc.mappings[0].address"},
+		{"midi channel", func(c *Config) {
+			c.MIDI.Mappings = []MIDIMappingConfig{{Type: MIDINoteOn, Channel: 17, Message: "Go"}}
+		}, "midi.mappings[0].channel"},
+		{"propresenter regexp", func(c *Config) {
+			c.ProPresenter = ProPresenterConfig{URL: "http://pp:1025", PollInterval: time.Second, Rules: []ProPresenterRuleConfig{{SlideLabel: "(", Message: "Go"}}}
+		}, "propresenter.rules[0].slide_label"},
+		{"dashboard sign in", func(c *Config) {
+			c.HTTP.APIKeys = nil
+			c.Dashboard = DashboardConfig{Enabled: true, SessionSecret: "s", SessionLifetime: time.Hour}
+		}, "dashboard"},
+		{"ical time zone", func(c *Config) {
+			c.ICal = ICalConfig{Enabled: true, PublicURL: "https://example.com", TimeZone: "Mars/Olympus"}
+		}, "ical.time_zone"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			config := validTestConfig()
+			c.change(config)
+			problems := config.Validate()
+			found := false
+			for _, problem := range problems {
+				if problem.Path == c.path && !problem.Warning {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("problems %v, want an error for %s", problems, c.path)
+			}
+		})
+	}
+}
+
+func TestInitOrgsEmail(t *testing.T) {
+	app = &App{config: &Config{
+		Email:         EmailConfig{Host: "smtp.example.com"},
+		Organizations: []OrganizationConfig{{Name: "north", Email: EmailConfig{Host: "smtp.north.example.com"}}, {Name: "south"}},
+	}}
+	app.InitOrgs()
+
+	// Organizations use their own email server, or the top level one without it.
+	if app.orgs[0].Email.Host != "smtp.north.example.com" || app.orgs[1].Email != &app.config.Email {
+		t.Errorf("organizations use email servers %s and %s", app.orgs[0].Email.Host, app.orgs[1].Email.Host)
+	}
+}
+
+func TestValidateOrganizations(t *testing.T) {
+	config := validTestConfig()
+	config.Organizations = []OrganizationConfig{validTestOrg("north", "app1", "xoxb-1"), validTestOrg("south", "app2", "xoxb-2")}
+	config.PlanningCenter = PlanningCenterConfig{}
+	config.Slack = DefaultSlackConfig
+	config.Reminders = DefaultRemindersConfig
+	problems := config.Validate()
+	if len(problems) != 0 {
+		t.Errorf("organizations with their own accounts have problems %v", problems)
+	}
+
+	// The top level configuration would be ignored.
+	config.Slack.StickyUsers = []string{"U1"}
+	problems = config.Validate()
+	if len(problems) != 1 || problems[0].Path != "slack" || problems[0].Warning {
+		t.Errorf("problems %v, want an error for slack", problems)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	readConfig := func(config string) (ConfigProblems, error) {
+		t.Helper()
+		path := filepath.Join(dir, "config.yaml")
+		err := os.WriteFile(path, []byte(config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app = &App{flags: &Flags{ConfigPath: path}}
+		return app.ReadConfig()
+	}
+
+	// Files which can not be parsed are returned as errors.
+	_, err := readConfig("slack: [")
+	if err == nil || app.config != nil {
+		t.Errorf("read a file which can not be parsed: %v", err)
+	}
+
+	// So are invalid configurations, along with their problems.
+	problems, err := readConfig("planning_center:\n  app_id: app\n")
+	if err == nil || !problems.HasErrors() || app.config != nil {
+		t.Errorf("read an invalid configuration: %v %v", problems, err)
+	}
+
+	// Valid configurations are set.
+	problems, err = readConfig("planning_center:\n  app_id: app\n  secret: secret\nslack:\n  api_token: xoxb-token\n  signing_secret: signing\n")
+	if err != nil || problems.HasErrors() || app.config == nil || app.config.PlanningCenter.AppID != "app" {
+		t.Errorf("read a valid configuration: %v %v", problems, err)
+	}
+}
+
+func TestValidateWarnings(t *testing.T) {
+	config := validTestConfig()
+	config.Slack.SigningSecret = ""
+	problems := config.Validate()
+	if problems.HasErrors() || len(problems) != 1 || problems[0].Path != "slack.signing_secret" {
+		t.Errorf("problems %v, want a warning for slack.signing_secret", problems)
+	}
+}
+
+// Point the connectivity checks at a stand-in server.
+func checkStandIn(t *testing.T, handler http.HandlerFunc) *Org {
+	s := httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+	pcBaseURL, slackAPIURL, client := PCBaseURL, SlackAPIURL, CheckClient
+	PCBaseURL = s.URL
+	SlackAPIURL = s.URL + "/api/"
+	CheckClient = &http.Client{Timeout: 500 * time.Millisecond}
+	t.Cleanup(func() {
+		PCBaseURL, SlackAPIURL, CheckClient = pcBaseURL, slackAPIURL, client
+	})
+	return &Org{
+		Name:           DefaultOrgName,
+		PlanningCenter: &PlanningCenterConfig{AppID: "app", Secret: "secret"},
+		Slack:          &SlackConfig{APIToken: "xoxb-token"},
+	}
+}
+
+func TestCheckPlanningCenter(t *testing.T) {
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("app:secret"))
+	org := checkStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/v2" || r.Header.Get("Authorization") != auth {
+			http.Error(w, `{"errors":[{"detail":"unauthorized"}]}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":{}}`))
+	})
+	err := CheckPlanningCenter(org)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	org.PlanningCenter.Secret = "wrong"
+	err = CheckPlanningCenter(org)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("checked with the wrong secret: %v", err)
+	}
+}
+
+func TestCheckSlack(t *testing.T) {
+	org := checkStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// The token may be sent as a header or form value.
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.FormValue("token")
+		}
+		if r.URL.Path != "/api/auth.test" || token != "xoxb-token" {
+			w.Write([]byte(`{"ok":false,"error":"invalid_auth"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"team":"Church","user":"notify","team_id":"T1","user_id":"U1"}`))
+	})
+	err := CheckSlack(org)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	org.Slack.APIToken = "xoxb-wrong"
+	err = CheckSlack(org)
+	if err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Errorf("checked with the wrong token: %v", err)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	org := checkStandIn(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+
+	// Services which do not respond fail the checks.
+	for name, check := range map[string]func(*Org) error{
+		"planning_center": CheckPlanningCenter,
+		"slack":           CheckSlack,
+	} {
+		start := time.Now()
+		err := check(org)
+		if err == nil {
+			t.Errorf("%s: checked a service which did not respond", name)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("%s: took %s to time out", name, time.Since(start))
+		}
+	}
+}
+
+func TestConfigKeyProblems(t *testing.T) {
+	dir := t.TempDir()
+	for file, config := range map[string]string{
+		"config.yaml": "slack:\n  api_tokn: xoxb-token\norganizations:\n  - name: north\n    colour: blue\n",
+		"config.toml": "[slack]\napi_tokn = \"xoxb-token\"\n\n[[organizations]]\nname = \"north\"\ncolour = \"blue\"\n",
+	} {
+		path := filepath.Join(dir, file)
+		err := os.WriteFile(path, []byte(config), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, problem := range ConfigKeyProblems(path) {
+			paths = append(paths, problem.Path)
+		}
+		want := []string{"organizations[0].colour", "slack.api_tokn"}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("%s has problems for %v, want %v", file, paths, want)
+		}
+	}
+}
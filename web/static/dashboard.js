@@ -0,0 +1,29 @@
+// Show messages as they are sent.
+(function () {
+    const list = document.getElementById("messages");
+    if (!list || !window.EventSource) {
+        return;
+    }
+    let replayed = false;
+    const stream = new EventSource("/api/stream");
+    stream.addEventListener("open", () => {
+        // The stream replays recent messages, which are already shown.
+        setTimeout(() => { replayed = true; }, 1000);
+    });
+    stream.addEventListener("message", (e) => {
+        if (!replayed) {
+            return;
+        }
+        const message = JSON.parse(e.data);
+        const empty = list.querySelector(".empty");
+        if (empty) {
+            empty.remove();
+        }
+        const item = document.createElement("li");
+        const time = document.createElement("span");
+        time.className = "muted";
+        time.textContent = new Date(message.time).toLocaleString([], { weekday: "short", month: "short", day: "numeric", hour: "numeric", minute: "2-digit" });
+        item.append(time, " " + message.message);
+        list.prepend(item);
+    });
+})();
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Planning center webhook delivery structure.
+type PCWebhookDelivery struct {
+	Data []struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			Name    string `json:"name"`
+			Attempt int    `json:"attempt"`
+			Payload string `json:"payload"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// The payload of a webhook event, which contains the resource that changed.
+type PCWebhookPayload struct {
+	Data PCDict `json:"data"`
+}
+
+// Verify the authenticity of a planning center webhook using the secret an organization configured for the event.
+func PCWebhookVerify(org *Org, event string, body []byte, authenticity string) bool {
+	// Find the secret for this event.
+	secret, ok := org.PlanningCenter.WebhookSecrets[event]
+	if !ok || secret == "" {
+		return false
+	}
+
+	// Compute the expected signature and compare.
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(authenticity))
+}
+
+// Handle an event from the planning center account of an organization, updating the affected rows and reconciling the plan channel.
+func PCWebhookHandleEvent(org *Org, event string, payload PCWebhookPayload) error {
+	// Event names are formatted as services.v2.events.RESOURCE.ACTION.
+	parts := strings.Split(event, ".")
+	if len(parts) != 5 || parts[0] != "services" || parts[2] != "events" {
+		return fmt.Errorf("unsupported event: %s", event)
+	}
+	resource, action := parts[3], parts[4]
+
+	// Get the resource ID and the IDs of its parents from the self link.
+	data := payload.Data
+	id := data.GetUint64("id")
+	if id == 0 {
+		return fmt.Errorf("no resource id in payload")
+	}
+	ids := PCLinkIDs(data.GetDict("links").GetString("self"))
+
+	// Find the plan that this event affects.
+	var planID uint64
+	switch resource {
+	case "plan":
+		planID = id
+		if action == "destroyed" {
+			// Remove the plan and everything associated with it.
+			app.db.Where("org = ? AND plan = ?", org.Name, planID).Delete(&PlanTimes{})
+			app.db.Where("org = ? AND plan = ?", org.Name, planID).Delete(&PlanPeople{})
+			app.db.Where("org = ? AND id = ?", org.Name, planID).Delete(&Plans{})
+			ArchivePlanChannel(org, ActorPCWebhook, planID)
+			return nil
+		}
+
+		// Determine the service type, either from the relationship or the link.
+		serviceTypeID := data.GetDict("relationships").GetDict("service_type").GetDict("data").GetUint64("id")
+		if serviceTypeID == 0 {
+			serviceTypeID = ids["service_types"]
+		}
+		err := SavePCPlan(org, serviceTypeID, data)
+		if err != nil {
+			return err
+		}
+	case "plan_time":
+		planID = ids["plans"]
+		if action == "destroyed" {
+			// Find the plan before deleting so we can reconcile it.
+			var p PlanTimes
+			app.db.Where("org = ? AND id = ?", org.Name, id).First(&p)
+			planID = p.Plan
+			app.db.Where("org = ? AND id = ?", org.Name, id).Delete(&PlanTimes{})
+			break
+		}
+		if planID == 0 {
+			return fmt.Errorf("unable to determine plan for plan time %d", id)
+		}
+		err := PCWebhookSavePlan(org, ids["service_types"], planID)
+		if err != nil {
+			return err
+		}
+		err = SavePCPlanTime(org, planID, data)
+		if err != nil {
+			return err
+		}
+	case "team_member", "plan_person":
+		planID = ids["plans"]
+		if planID == 0 {
+			planID = data.GetDict("relationships").GetDict("plan").GetDict("data").GetUint64("id")
+		}
+		if action == "destroyed" {
+			// Find the plan before deleting so we can reconcile it.
+			var p PlanPeople
+			app.db.Where("org = ? AND id = ?", org.Name, id).First(&p)
+			planID = p.Plan
+			app.db.Where("org = ? AND id = ?", org.Name, id).Delete(&PlanPeople{})
+			break
+		}
+		if planID == 0 {
+			return fmt.Errorf("unable to determine plan for team member %d", id)
+		}
+		err := PCWebhookSavePlan(org, ids["service_types"], planID)
+		if err != nil {
+			return err
+		}
+		err = SavePCPlanPerson(org, planID, data)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported event: %s", event)
+	}
+
+	// If we do not have a plan, there is nothing to reconcile.
+	if planID == 0 {
+		return nil
+	}
+
+	// Reconcile the channel for the affected plan.
+	return ReconcilePlan(ActorPCWebhook, planID)
+}
+
+// Save a plan of an organization which is not stored yet, as Planning Center
+// may deliver changes to the times and people of a new plan before the plan.
+func PCWebhookSavePlan(org *Org, serviceTypeID, planID uint64) error {
+	var plan Plans
+	app.db.Where("id = ?", planID).First(&plan)
+	if plan.ID != 0 {
+		return nil
+	}
+	if serviceTypeID == 0 {
+		return fmt.Errorf("unable to determine service type for plan %d", planID)
+	}
+	data, err := PCGet(org, fmt.Sprintf("/services/v2/service_types/%d/plans/%d", serviceTypeID, planID))
+	if err != nil {
+		return fmt.Errorf("unable to get plan %d: %s", planID, err)
+	}
+	return SavePCPlan(org, serviceTypeID, data)
+}
+
+// Archive the channel for a plan of an organization if one exists.
+func ArchivePlanChannel(org *Org, actor string, planID uint64) {
+	var channel SlackChannels
+	app.db.Where("org = ? AND pc_plan = ? AND archived != 1", org.Name, planID).First(&channel)
+	if channel.ID == "" {
+		return
+	}
+	err := NotifierAs(org, channel.Notifier, actor).Archive(channel.ID)
+	if err != nil {
+		log.Println("Error closing channel:", err)
+	}
+	channel.Archived = true
+	app.db.Save(&channel)
+}
+
+// Setup HTTP router with routes for receiving webhooks.
+func (s *HTTPServer) RegisterWebhookRoutes(r *mux.Router) {
+	webhooks := r.PathPrefix("/webhooks").Subrouter()
+
+	// Receive webhooks from planning center.
+	webhooks.HandleFunc("/planningcenter", func(w http.ResponseWriter, r *http.Request) {
+		// Read the body for verification.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			log.Println("Error reading webhook:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			s.APISendGeneralResp(w, APIERR, "Error reading body")
+			return
+		}
+
+		// Verify the webhook was sent by planning center, finding the organization by whose secret matches.
+		event := r.Header.Get("X-PCO-Webhooks-Name")
+		var org *Org
+		for _, o := range app.orgs {
+			if PCWebhookVerify(o, event, body, r.Header.Get("X-PCO-Webhooks-Authenticity")) {
+				org = o
+				break
+			}
+		}
+		if org == nil {
+			log.Println("Webhook failed verification:", event)
+			w.WriteHeader(http.StatusForbidden)
+			s.APISendGeneralResp(w, APIERR, APIForbidden)
+			return
+		}
+
+		// Decode the delivery.
+		var delivery PCWebhookDelivery
+		err = json.Unmarshal(body, &delivery)
+		if err != nil {
+			log.Println("Error decoding webhook:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			s.APISendGeneralResp(w, APIERR, "Error decoding webhook")
+			return
+		}
+
+		// Handle each event in the delivery.
+		for _, d := range delivery.Data {
+			// Each event must match the event that was verified.
+			if d.Attributes.Name != event {
+				log.Println("Webhook event does not match header:", d.Attributes.Name)
+				continue
+			}
+
+			// The payload is a JSON encoded string.
+			var payload PCWebhookPayload
+			err = json.Unmarshal([]byte(d.Attributes.Payload), &payload)
+			if err != nil {
+				log.Println("Error decoding webhook payload:", err)
+				continue
+			}
+
+			err = PCWebhookHandleEvent(org, event, payload)
+			if err != nil {
+				log.Println("Error handling webhook:", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				s.APISendGeneralResp(w, APIERR, "Error handling webhook")
+				return
+			}
+		}
+
+		// Return a success.
+		s.APISendGeneralResp(w, APIOK, "")
+	}).Methods(http.MethodPost)
+}
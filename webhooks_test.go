@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Event names used by the tests.
+const (
+	testPlanTimeEvent   = "services.v2.events.plan_time.created"
+	testTeamMemberEvent = "services.v2.events.team_member.created"
+)
+
+// Build a Planning Center webhook delivery of an event with a resource as its payload.
+func pcWebhookDelivery(t *testing.T, event string, resource PCDict) []byte {
+	payload, err := json.Marshal(PCWebhookPayload{Data: resource})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var delivery PCWebhookDelivery
+	delivery.Data = make([]struct {
+		ID         string `json:"id"`
+		Type       string `json:"type"`
+		Attributes struct {
+			Name    string `json:"name"`
+			Attempt int    `json:"attempt"`
+			Payload string `json:"payload"`
+		} `json:"attributes"`
+	}, 1)
+	delivery.Data[0].Type = "EventDelivery"
+	delivery.Data[0].Attributes.Name = event
+	delivery.Data[0].Attributes.Attempt = 1
+	delivery.Data[0].Attributes.Payload = string(payload)
+	body, err := json.Marshal(delivery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// Sign a webhook body with a secret, as Planning Center does.
+func pcWebhookSign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send a webhook delivery signed with a secret, returning the response status.
+func pcWebhookSend(t *testing.T, r *mux.Router, event, secret string, body []byte) int {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/planningcenter", strings.NewReader(string(body)))
+	req.Header.Set("X-PCO-Webhooks-Name", event)
+	req.Header.Set("X-PCO-Webhooks-Authenticity", pcWebhookSign(secret, body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+// Setup the app to receive webhooks signed with a secret for the events used by the tests.
+func pcWebhookTestRouter(t *testing.T) *mux.Router {
+	newTestApp(t)
+	app.config.PlanningCenter.WebhookSecrets = map[string]string{
+		testPlanTimeEvent:   "secret",
+		testTeamMemberEvent: "secret",
+	}
+	s := &HTTPServer{config: &app.config.HTTP}
+	r := mux.NewRouter()
+	s.RegisterWebhookRoutes(r)
+	return r
+}
+
+// A Planning Center stand-in serving plans.
+type pcPlanStandIn struct {
+	mu      sync.Mutex
+	plans   map[string]string // Plan resources by path.
+	fetched []string          // Paths fetched.
+}
+
+func newPCPlanStandIn(t *testing.T, plans map[string]string) *pcPlanStandIn {
+	pc := &pcPlanStandIn{plans: plans}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pc.mu.Lock()
+		defer pc.mu.Unlock()
+		pc.fetched = append(pc.fetched, r.URL.Path)
+		plan, ok := pc.plans[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[{"status":"404","detail":"Resource Not Found"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":` + plan + `}`))
+	}))
+	t.Cleanup(s.Close)
+	baseURL := PCBaseURL
+	PCBaseURL = s.URL
+	t.Cleanup(func() { PCBaseURL = baseURL })
+	return pc
+}
+
+func TestPCWebhookBeforePlan(t *testing.T) {
+	r := pcWebhookTestRouter(t)
+	pc := newPCPlanStandIn(t, map[string]string{
+		"/services/v2/service_types/1/plans/20": `{"type":"Plan","id":"20","attributes":{"title":"Easter","sort_date":"2030-04-20T10:00:00Z"}}`,
+	})
+
+	// A plan time of a plan not stored yet saves the plan first.
+	status := pcWebhookSend(t, r, testPlanTimeEvent, "secret", pcWebhookDelivery(t, testPlanTimeEvent, PCDict{
+		"type":       "PlanTime",
+		"id":         "200",
+		"attributes": map[string]interface{}{"time_type": "service", "starts_at": "2030-04-20T10:00:00Z"},
+		"links":      map[string]interface{}{"self": "https://api.planningcenteronline.com/services/v2/service_types/1/plans/20/plan_times/200"},
+	}))
+	if status != http.StatusOK {
+		t.Fatalf("responded %d", status)
+	}
+	var plan Plans
+	app.db.Where("id = ?", 20).First(&plan)
+	if plan.Title != "Easter" || plan.ServiceType != 1 || plan.Org != DefaultOrgName {
+		t.Errorf("saved plan %+v", plan)
+	}
+	var planTime PlanTimes
+	app.db.Where("id = ?", 200).First(&planTime)
+	if planTime.Plan != 20 {
+		t.Errorf("saved plan time %+v", planTime)
+	}
+
+	// Team members of the stored plan do not fetch it again.
+	status = pcWebhookSend(t, r, testTeamMemberEvent, "secret", pcWebhookDelivery(t, testTeamMemberEvent, PCDict{
+		"type":          "PlanPerson",
+		"id":            "300",
+		"attributes":    map[string]interface{}{"status": "C", "team_position_name": "Vocals"},
+		"relationships": map[string]interface{}{"person": map[string]interface{}{"data": map[string]interface{}{"id": "5"}}},
+		"links":         map[string]interface{}{"self": "https://api.planningcenteronline.com/services/v2/service_types/1/plans/20/team_members/300"},
+	}))
+	if status != http.StatusOK {
+		t.Fatalf("responded %d", status)
+	}
+	var planPerson PlanPeople
+	app.db.Where("id = ?", 300).First(&planPerson)
+	if planPerson.Plan != 20 || planPerson.Person != 5 {
+		t.Errorf("saved team member %+v", planPerson)
+	}
+	if len(pc.fetched) != 1 {
+		t.Errorf("fetched %v, want the plan once", pc.fetched)
+	}
+
+	// A plan which can not be fetched fails the delivery, so Planning Center tries again.
+	status = pcWebhookSend(t, r, testTeamMemberEvent, "secret", pcWebhookDelivery(t, testTeamMemberEvent, PCDict{
+		"type":  "PlanPerson",
+		"id":    "301",
+		"links": map[string]interface{}{"self": "https://api.planningcenteronline.com/services/v2/service_types/1/plans/21/team_members/301"},
+	}))
+	if status != http.StatusInternalServerError {
+		t.Errorf("responded %d for a missing plan, want 500", status)
+	}
+	var count int64
+	app.db.Model(&PlanPeople{}).Where("id = ?", 301).Count(&count)
+	if count != 0 {
+		t.Errorf("saved a team member without its plan")
+	}
+}
+
+func TestSavePCPlanTimeWithoutPlan(t *testing.T) {
+	newTestApp(t)
+
+	// Rows of plans which are not stored are rejected by the foreign key.
+	err := SavePCPlanTime(app.orgs[0], 99, PCDict{"id": "1"})
+	if err == nil {
+		t.Error("saved a plan time without its plan")
+	}
+}
+
+func TestPCWebhookVerify(t *testing.T) {
+	org := &Org{Name: DefaultOrgName, PlanningCenter: &PlanningCenterConfig{WebhookSecrets: map[string]string{
+		testPlanTimeEvent:   "secret",
+		testTeamMemberEvent: "",
+	}}}
+	body := []byte(`{"data":[]}`)
+	for _, c := range []struct {
+		name         string
+		event        string
+		body         []byte
+		authenticity string
+		ok           bool
+	}{
+		{"valid", testPlanTimeEvent, body, pcWebhookSign("secret", body), true},
+		{"wrong secret", testPlanTimeEvent, body, pcWebhookSign("other", body), false},
+		{"other body", testPlanTimeEvent, []byte(`{"data":[{}]}`), pcWebhookSign("secret", body), false},
+		{"missing", testPlanTimeEvent, body, "", false},
+		{"event without secret", testTeamMemberEvent, body, pcWebhookSign("", body), false},
+		{"unknown event", "services.v2.events.plan.created", body, pcWebhookSign("secret", body), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if PCWebhookVerify(org, c.event, c.body, c.authenticity) != c.ok {
+				t.Errorf("verified %v, want %v", !c.ok, c.ok)
+			}
+		})
+	}
+}
+
+func TestPCWebhookOrganizations(t *testing.T) {
+	r := pcWebhookTestRouter(t)
+	north := &Org{
+		Name:           "north",
+		PlanningCenter: &PlanningCenterConfig{WebhookSecrets: map[string]string{testPlanTimeEvent: "north-secret"}},
+		Slack:          &SlackConfig{},
+		Reminders:      &RemindersConfig{},
+		notifier:       &recordingNotifier{},
+	}
+	app.orgs = append(app.orgs, north)
+	app.db.Create(&Plans{ID: 10, Org: DefaultOrgName, ServiceType: 1})
+	app.db.Create(&Plans{ID: 20, Org: "north", ServiceType: 2})
+
+	// A plan time of a plan in an organization.
+	planTime := func(id, planID int) []byte {
+		return pcWebhookDelivery(t, testPlanTimeEvent, PCDict{
+			"type":       "PlanTime",
+			"id":         fmt.Sprint(id),
+			"attributes": map[string]interface{}{"time_type": "service", "starts_at": "2030-04-20T10:00:00Z"},
+			"links":      map[string]interface{}{"self": fmt.Sprintf("https://api.planningcenteronline.com/services/v2/service_types/1/plans/%d/plan_times/%d", planID, id)},
+		})
+	}
+	for _, c := range []struct {
+		name   string
+		event  string
+		secret string
+		body   []byte
+		status int
+		org    string // Organization the plan time is saved to, if any.
+	}{
+		{"first organization", testPlanTimeEvent, "secret", planTime(100, 10), http.StatusOK, DefaultOrgName},
+		{"second organization", testPlanTimeEvent, "north-secret", planTime(200, 20), http.StatusOK, "north"},
+		{"secret of another event", testTeamMemberEvent, "north-secret", planTime(201, 20), http.StatusForbidden, ""},
+		{"unknown secret", testPlanTimeEvent, "guess", planTime(202, 20), http.StatusForbidden, ""},
+		{"event not matching header", testTeamMemberEvent, "secret", planTime(101, 10), http.StatusOK, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			status := pcWebhookSend(t, r, c.event, c.secret, c.body)
+			if status != c.status {
+				t.Errorf("responded %d, want %d", status, c.status)
+			}
+			var payload PCWebhookPayload
+			var delivery PCWebhookDelivery
+			json.Unmarshal(c.body, &delivery)
+			json.Unmarshal([]byte(delivery.Data[0].Attributes.Payload), &payload)
+			var saved PlanTimes
+			app.db.Where("id = ?", payload.Data.GetUint64("id")).First(&saved)
+			if saved.Org != c.org {
+				t.Errorf("saved to %q, want %q", saved.Org, c.org)
+			}
+		})
+	}
+}
+
+func TestPCWebhookDestroyed(t *testing.T) {
+	r := pcWebhookTestRouter(t)
+	app.config.PlanningCenter.WebhookSecrets["services.v2.events.plan.destroyed"] = "secret"
+	app.config.PlanningCenter.WebhookSecrets["services.v2.events.team_member.destroyed"] = "secret"
+	createTestPlan(t, 10, 5, 6)
+	app.db.Create(&SlackChannels{ID: "C10", Org: DefaultOrgName, PCPlan: 10})
+	count := func(model interface{}) (n int64) {
+		app.db.Model(model).Where("plan = ?", 10).Count(&n)
+		return
+	}
+
+	// Destroyed team members are removed.
+	event := "services.v2.events.team_member.destroyed"
+	status := pcWebhookSend(t, r, event, "secret", pcWebhookDelivery(t, event, PCDict{
+		"type":  "PlanPerson",
+		"id":    "1000",
+		"links": map[string]interface{}{"self": "https://api.planningcenteronline.com/services/v2/service_types/1/plans/10/team_members/1000"},
+	}))
+	if status != http.StatusOK || count(&PlanPeople{}) != 1 {
+		t.Errorf("responded %d with %d team members, want 1 left", status, count(&PlanPeople{}))
+	}
+
+	// Destroyed plans are removed with their times and people, and their channel archived.
+	event = "services.v2.events.plan.destroyed"
+	status = pcWebhookSend(t, r, event, "secret", pcWebhookDelivery(t, event, PCDict{"type": "Plan", "id": "10"}))
+	if status != http.StatusOK {
+		t.Fatalf("responded %d", status)
+	}
+	var plans int64
+	app.db.Model(&Plans{}).Where("id = ?", 10).Count(&plans)
+	if plans != 0 || count(&PlanTimes{}) != 0 || count(&PlanPeople{}) != 0 {
+		t.Errorf("plan left with %d plans, %d times and %d people", plans, count(&PlanTimes{}), count(&PlanPeople{}))
+	}
+	var channel SlackChannels
+	app.db.Where("id = ?", "C10").First(&channel)
+	if !channel.Archived {
+		t.Error("plan channel not archived")
+	}
+	events, _ := QueryAuditEvents(AuditFilter{Action: AuditChannelArchive})
+	if len(events) != 1 || events[0].Actor != ActorPCWebhook || events[0].Target != "C10" {
+		t.Errorf("audit events %+v, want the archive recorded", events)
+	}
+}